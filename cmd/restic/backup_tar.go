@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompressTar returns a reader for the uncompressed tar archive, gzip and
+// zstd compressed archives are detected automatically. It also reports
+// whether the archive was compressed.
+func decompressTar(rd io.Reader) (io.ReadCloser, bool, error) {
+	brd := bufio.NewReader(rd)
+	magic, err := brd.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, false, errors.Wrap(err, "Peek")
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zrd, err := gzip.NewReader(brd)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "gzip.NewReader")
+		}
+		return zrd, true, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zrd, err := zstd.NewReader(brd)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "zstd.NewReader")
+		}
+		return zrd.IOReadCloser(), true, nil
+	}
+
+	return ioutil.NopCloser(brd), false, nil
+}
+
+// openTarFS returns a file system for the tar archive in the file filename,
+// or stdin if filename is "-". Files from the archive are not read in the
+// order in which they are stored, so a compressed archive or an archive read
+// from stdin is copied uncompressed into a temporary file first. The returned
+// function removes the temporary file.
+func openTarFS(filename string) (*fs.Tar, func(), error) {
+	var f *os.File
+	if filename == "-" {
+		f = os.Stdin
+	} else {
+		var err error
+		f, err = os.Open(filename)
+		if err != nil {
+			return nil, nil, errors.Fatalf("unable to open tar archive: %v", err)
+		}
+	}
+
+	rd, compressed, err := decompressTar(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, errors.Fatalf("unable to read tar archive: %v", err)
+	}
+
+	if compressed || filename == "-" {
+		tmpfile, err := fs.TempFile("", "restic-tar-")
+		if err != nil {
+			_ = f.Close()
+			return nil, nil, err
+		}
+		debug.Log("copy tar archive from %v to %v", filename, tmpfile.Name())
+
+		_, err = io.Copy(tmpfile, rd)
+		_ = rd.Close()
+		_ = f.Close()
+		f = tmpfile
+		if err != nil {
+			_ = tmpfile.Close()
+			_ = os.Remove(tmpfile.Name())
+			return nil, nil, errors.Fatalf("unable to read tar archive: %v", err)
+		}
+	}
+
+	cleanup := func() {
+		_ = f.Close()
+		if f.Name() != filename {
+			// fs.TempFile already removes the file on most systems
+			_ = os.Remove(f.Name())
+		}
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		cleanup()
+		return nil, nil, errors.Wrap(err, "Stat")
+	}
+
+	tarFS, err := fs.NewTar(f, fi.Size())
+	if err != nil {
+		cleanup()
+		return nil, nil, errors.Fatalf("unable to read tar archive: %v", err)
+	}
+
+	return tarFS, cleanup, nil
+}
@@ -86,16 +86,22 @@ type BackupOptions struct {
 	InsensitiveExcludeFiles []string
 	ExcludeOtherFS          bool
 	ExcludeIfPresent        []string
+	IgnoreFiles             []string
 	ExcludeCaches           bool
 	ExcludeLargerThan       string
+	ExcludeExprs            []string
 	Stdin                   bool
 	StdinFilename           string
+	CommandOutputs          []string
+	FromTar                 string
 	Tags                    []string
 	Host                    string
 	FilesFrom               []string
 	TimeStamp               string
 	WithAtime               bool
 	IgnoreInode             bool
+	CheckpointInterval      time.Duration
+	DryRun                  bool
 }
 
 var backupOptions BackupOptions
@@ -109,16 +115,20 @@ func init() {
 	f := cmdBackup.Flags()
 	f.StringVar(&backupOptions.Parent, "parent", "", "use this parent `snapshot` (default: last snapshot in the repo that has the same target files/directories)")
 	f.BoolVarP(&backupOptions.Force, "force", "f", false, `force re-reading the target files/directories (overrides the "parent" flag)`)
-	f.StringArrayVarP(&backupOptions.Excludes, "exclude", "e", nil, "exclude a `pattern` (can be specified multiple times)")
+	f.StringArrayVarP(&backupOptions.Excludes, "exclude", "e", nil, "exclude a `pattern`, a pattern starting with ! includes matching files again (can be specified multiple times)")
 	f.StringArrayVar(&backupOptions.InsensitiveExcludes, "iexclude", nil, "same as --exclude `pattern` but ignores the casing of filenames")
 	f.StringArrayVar(&backupOptions.ExcludeFiles, "exclude-file", nil, "read exclude patterns from a `file` (can be specified multiple times)")
 	f.StringArrayVar(&backupOptions.InsensitiveExcludeFiles, "iexclude-file", nil, "same as --exclude-file but ignores casing of `file`names in patterns")
 	f.BoolVarP(&backupOptions.ExcludeOtherFS, "one-file-system", "x", false, "exclude other file systems")
 	f.StringArrayVar(&backupOptions.ExcludeIfPresent, "exclude-if-present", nil, "takes `filename[:header]`, exclude contents of directories containing filename (except filename itself) if header of that file is as provided (can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.IgnoreFiles, "ignore-file", nil, "exclude files according to the gitignore-style patterns in files named `filename` (e.g. .resticignore) in the directories of the backup (can be specified multiple times)")
 	f.BoolVar(&backupOptions.ExcludeCaches, "exclude-caches", false, `excludes cache directories that are marked with a CACHEDIR.TAG file. See https://bford.info/cachedir/ for the Cache Directory Tagging Standard`)
 	f.StringVar(&backupOptions.ExcludeLargerThan, "exclude-larger-than", "", "max `size` of the files to be backed up (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.StringArrayVar(&backupOptions.ExcludeExprs, "exclude-expr", nil, "exclude files matching the `expression`, e.g. \"age > 2y and uid > 10000\" (can be specified multiple times)")
 	f.BoolVar(&backupOptions.Stdin, "stdin", false, "read backup from stdin")
 	f.StringVar(&backupOptions.StdinFilename, "stdin-filename", "stdin", "`filename` to use when reading from stdin")
+	f.StringVar(&backupOptions.FromTar, "from-tar", "", "read the files to backup from the tar archive `file` instead of the file system, \"-\" reads it from stdin")
+	f.StringArrayVar(&backupOptions.CommandOutputs, "command-output", nil, "run a command and save its output as a file, takes `filename=command` (can be specified multiple times)")
 	f.StringArrayVar(&backupOptions.Tags, "tag", nil, "add a `tag` for the new snapshot (can be specified multiple times)")
 
 	f.StringVarP(&backupOptions.Host, "host", "H", "", "set the `hostname` for the snapshot manually. To prevent an expensive rescan use the \"parent\" flag")
@@ -129,6 +139,8 @@ func init() {
 	f.StringVar(&backupOptions.TimeStamp, "time", "", "`time` of the backup (ex. '2012-11-01 22:08:41') (default: now)")
 	f.BoolVar(&backupOptions.WithAtime, "with-atime", false, "store the atime for all files and directories")
 	f.BoolVar(&backupOptions.IgnoreInode, "ignore-inode", false, "ignore inode number changes when checking for modified files")
+	f.DurationVar(&backupOptions.CheckpointInterval, "checkpoint-interval", 0, "save the index and a checkpoint snapshot of the completed files every `interval`, so that an interrupted backup can be resumed (0 disables checkpoints)")
+	f.BoolVarP(&backupOptions.DryRun, "dry-run", "n", false, "do not upload or write any data, just show what would be done")
 }
 
 // filterExisting returns a slice of all existing items, or an error if no
@@ -206,6 +218,10 @@ func (opts BackupOptions) Check(gopts GlobalOptions, args []string) error {
 				return errors.Fatal("unable to read password from stdin when data is to be read from stdin, use --password-file or $RESTIC_PASSWORD")
 			}
 		}
+
+		if opts.FromTar == "-" {
+			return errors.Fatal("unable to read password from stdin when the tar archive is to be read from stdin, use --password-file or $RESTIC_PASSWORD")
+		}
 	}
 
 	if opts.Stdin {
@@ -218,9 +234,43 @@ func (opts BackupOptions) Check(gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	if opts.FromTar != "" {
+		if opts.Stdin || len(opts.CommandOutputs) > 0 {
+			return errors.Fatal("--from-tar cannot be used together with --stdin or --command-output")
+		}
+
+		if len(opts.FilesFrom) > 0 {
+			return errors.Fatal("--from-tar and --files-from cannot be used together")
+		}
+
+		if len(args) > 0 {
+			return errors.Fatal("--from-tar was specified and files/dirs were listed as arguments")
+		}
+	}
+
+	if len(opts.CommandOutputs) > 0 {
+		if opts.Stdin {
+			return errors.Fatal("--stdin and --command-output cannot be used together")
+		}
+
+		if len(opts.FilesFrom) > 0 {
+			return errors.Fatal("--command-output and --files-from cannot be used together")
+		}
+
+		if len(args) > 0 {
+			return errors.Fatal("--command-output was specified and files/dirs were listed as arguments")
+		}
+	}
+
 	return nil
 }
 
+// readsLocalFiles returns true if the data to back up is read from the local
+// file system instead of stdin, the output of commands or a tar archive.
+func (opts BackupOptions) readsLocalFiles() bool {
+	return !opts.Stdin && len(opts.CommandOutputs) == 0 && opts.FromTar == ""
+}
+
 // collectRejectByNameFuncs returns a list of all functions which may reject data
 // from being saved in a snapshot based on path only
 func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, targets []string) (fs []RejectByNameFunc, err error) {
@@ -279,7 +329,7 @@ func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, t
 // from being saved in a snapshot based on path and file info
 func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, targets []string) (fs []RejectFunc, err error) {
 	// allowed devices
-	if opts.ExcludeOtherFS && !opts.Stdin {
+	if opts.ExcludeOtherFS && opts.readsLocalFiles() {
 		f, err := rejectByDevice(targets)
 		if err != nil {
 			return nil, err
@@ -287,7 +337,17 @@ func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, targets
 		fs = append(fs, f)
 	}
 
-	if len(opts.ExcludeLargerThan) != 0 && !opts.Stdin {
+	if opts.readsLocalFiles() {
+		for _, filename := range opts.IgnoreFiles {
+			f, err := rejectByIgnoreFile(filename)
+			if err != nil {
+				return nil, err
+			}
+			fs = append(fs, f)
+		}
+	}
+
+	if len(opts.ExcludeLargerThan) != 0 && opts.readsLocalFiles() {
 		f, err := rejectBySize(opts.ExcludeLargerThan)
 		if err != nil {
 			return nil, err
@@ -295,6 +355,14 @@ func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, targets
 		fs = append(fs, f)
 	}
 
+	if len(opts.ExcludeExprs) > 0 && (opts.readsLocalFiles() || opts.FromTar != "") {
+		f, err := rejectByExpr(opts.ExcludeExprs)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+
 	return fs, nil
 }
 
@@ -347,7 +415,7 @@ func readExcludePatternsFromFiles(excludeFiles []string) ([]string, error) {
 
 // collectTargets returns a list of target files/dirs from several sources.
 func collectTargets(opts BackupOptions, args []string) (targets []string, err error) {
-	if opts.Stdin {
+	if !opts.readsLocalFiles() {
 		return nil, nil
 	}
 
@@ -426,6 +494,11 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		return err
 	}
 
+	commandOutputs, err := parseCommandOutputs(opts.CommandOutputs)
+	if err != nil {
+		return err
+	}
+
 	timeStamp := time.Now()
 	if opts.TimeStamp != "" {
 		timeStamp, err = time.ParseInLocation(TimeFormat, opts.TimeStamp, time.Local)
@@ -452,6 +525,7 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		ScannerError(item string, fi os.FileInfo, err error) error
 		ReportTotal(item string, s archiver.ScanStats)
 		SetMinUpdatePause(d time.Duration)
+		SetDryRun()
 		Run(ctx context.Context) error
 		Error(item string, fi os.FileInfo, err error) error
 		Finish(snapshotID restic.ID)
@@ -502,6 +576,11 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		return err
 	}
 
+	if opts.DryRun {
+		repo.SetDryRun()
+		p.SetDryRun()
+	}
+
 	// rejectByNameFuncs collect functions that can reject items from the backup based on path only
 	rejectByNameFuncs, err := collectRejectByNameFuncs(opts, repo, targets)
 	if err != nil {
@@ -522,6 +601,10 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		return err
 	}
 
+	if !gopts.JSON {
+		p.V("%s", formatIndexMemoryUsage(repo.Index()))
+	}
+
 	parentSnapshotID, err := findParentSnapshot(gopts.ctx, repo, opts, targets)
 	if err != nil {
 		return err
@@ -564,6 +647,61 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		targets = []string{filename}
 	}
 
+	// readers holds the output of the commands, indexed like commandOutputs
+	var readers []*commandReader
+	if len(opts.CommandOutputs) > 0 {
+		cmdCtx, cancel := context.WithCancel(gopts.ctx)
+		defer func() {
+			// kill all commands which are still running when the backup was
+			// aborted, and wait for them to exit
+			cancel()
+			for _, rd := range readers {
+				_ = rd.Close()
+			}
+		}()
+
+		multiFS := &fs.MultiReader{}
+		targets = nil
+		for _, output := range commandOutputs {
+			if !gopts.JSON {
+				p.V("save output of %v as %v", output.Args, output.Filename)
+			}
+
+			rd, err := startCommand(cmdCtx, output.Args)
+			if err != nil {
+				return err
+			}
+			readers = append(readers, rd)
+
+			multiFS.Readers = append(multiFS.Readers, &fs.Reader{
+				ModTime:        timeStamp,
+				Name:           output.Filename,
+				Mode:           0644,
+				ReadCloser:     rd,
+				AllowEmptyFile: true,
+			})
+			targets = append(targets, output.Filename)
+		}
+		targetFS = multiFS
+	}
+
+	if opts.FromTar != "" {
+		if !gopts.JSON {
+			p.V("read tar archive %v", opts.FromTar)
+		}
+		tarFS, cleanup, err := openTarFS(opts.FromTar)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		targets = tarFS.Names()
+		if len(targets) == 0 {
+			return errors.Fatal("the tar archive does not contain any files")
+		}
+		targetFS = tarFS
+	}
+
 	sc := archiver.NewScanner(targetFS)
 	sc.SelectByName = selectByNameFilter
 	sc.Select = selectFilter
@@ -575,14 +713,45 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	}
 	t.Go(func() error { return sc.Scan(t.Context(gopts.ctx), targets) })
 
-	arch := archiver.New(repo, targetFS, archiver.Options{})
+	checkpointInterval := opts.CheckpointInterval
+	if opts.DryRun {
+		// checkpoints are not saved in dry-run mode
+		checkpointInterval = 0
+	}
+
+	arch := archiver.New(repo, targetFS, archiver.Options{
+		CheckpointInterval: checkpointInterval,
+	})
 	arch.SelectByName = selectByNameFilter
 	arch.Select = selectFilter
 	arch.WithAtime = opts.WithAtime
 	success := true
 	arch.Error = func(item string, fi os.FileInfo, err error) error {
 		success = false
-		return p.Error(item, fi, err)
+		reportErr := p.Error(item, fi, err)
+		if len(commandOutputs) > 0 {
+			// the output of a failed command must never end up in a snapshot
+			return err
+		}
+		return reportErr
+	}
+	arch.BeforeSnapshot = func() error {
+		// wait for all commands, the snapshot is discarded if one of them
+		// failed without being noticed while its output was read
+		failed := 0
+		for i, rd := range readers {
+			if err := rd.Close(); err != nil {
+				failed++
+				_ = p.Error(commandOutputs[i].Filename, nil, err)
+			}
+		}
+		if failed > 0 {
+			return errors.Errorf("%d of %d commands failed", failed, len(readers))
+		}
+		return nil
+	}
+	arch.CheckpointError = func(err error) {
+		Warnf("unable to save checkpoint, continuing backup: %v\n", err)
 	}
 	arch.CompleteItem = p.CompleteItem
 	arch.StartFile = p.StartFile
@@ -617,7 +786,7 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 
 	// Report finished execution
 	p.Finish(id)
-	if !gopts.JSON {
+	if !gopts.JSON && !opts.DryRun {
 		p.P("snapshot %s saved\n", id.Str())
 	}
 	if !success {
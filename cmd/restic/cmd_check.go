@@ -167,6 +167,9 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 
 	chkr := checker.New(repo)
 
+	// damaged packs are only replaced while the repository is locked exclusively
+	chkr.NoRepair = gopts.NoLock
+
 	Verbosef("load indexes\n")
 	hints, errs := chkr.LoadIndex(gopts.ctx)
 
@@ -189,6 +192,10 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("LoadIndex returned errors")
 	}
 
+	if gopts.verbosity >= 2 {
+		Printf("%s\n", formatIndexMemoryUsage(repo.Index()))
+	}
+
 	errorsFound := false
 	orphanedPacks := 0
 	errChan := make(chan error)
@@ -255,10 +262,20 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 
 		go chkr.ReadPacks(gopts.ctx, packs, p, errChan)
 
+		repairedPacks := 0
 		for err := range errChan {
+			if checker.IsRepairedPack(err) {
+				repairedPacks++
+				Printf("%v\n", err)
+				continue
+			}
 			errorsFound = true
 			Warnf("%v\n", err)
 		}
+
+		if repairedPacks > 0 {
+			Printf("%d packs or parity files were damaged and have been repaired\n", repairedPacks)
+		}
 	}
 
 	switch {
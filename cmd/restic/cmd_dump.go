@@ -21,8 +21,11 @@ var cmdDump = &cobra.Command{
 	Long: `
 The "dump" command extracts files from a snapshot from the repository. If a
 single file is selected, it prints its contents to stdout. Folders are output
-as a tar file containing the contents of the specified folder.  Pass "/" as
-file name to dump the whole snapshot as a tar file.
+as an archive containing the contents of the specified folder.  Pass "/" as
+file name to dump the whole snapshot as an archive.
+
+The archive format can be selected with "--archive", the supported formats
+are "tar" (the default), "tar.gz", "tar.zst" and "zip".
 
 The special snapshot "latest" can be used to use the latest snapshot in the
 repository.
@@ -40,9 +43,10 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 
 // DumpOptions collects all options for the dump command.
 type DumpOptions struct {
-	Hosts []string
-	Paths []string
-	Tags  restic.TagLists
+	Hosts   []string
+	Paths   []string
+	Tags    restic.TagLists
+	Archive dump.Format
 }
 
 var dumpOptions DumpOptions
@@ -54,6 +58,7 @@ func init() {
 	flags.StringArrayVarP(&dumpOptions.Hosts, "host", "H", nil, `only consider snapshots for this host when the snapshot ID is "latest" (can be specified multiple times)`)
 	flags.Var(&dumpOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
 	flags.StringArrayVar(&dumpOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
+	flags.Var(&dumpOptions.Archive, "archive", "set archive `format` for folders, one of (tar|tar.gz|tar.zst|zip)")
 }
 
 func splitPath(p string) []string {
@@ -65,7 +70,7 @@ func splitPath(p string) []string {
 	return append(s, f)
 }
 
-func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repository, prefix string, pathComponents []string) error {
+func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repository, prefix string, pathComponents []string, format dump.Format) error {
 
 	if tree == nil {
 		return fmt.Errorf("called with a nil tree")
@@ -84,7 +89,7 @@ func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repositor
 		if err := checkStdoutTar(); err != nil {
 			return err
 		}
-		return dump.WriteTar(ctx, repo, tree, "/", os.Stdout)
+		return dump.Write(ctx, repo, tree, "/", format, os.Stdout)
 	}
 
 	item := filepath.Join(prefix, pathComponents[0])
@@ -100,7 +105,7 @@ func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repositor
 				if err != nil {
 					return errors.Wrapf(err, "cannot load subtree for %q", item)
 				}
-				return printFromTree(ctx, subtree, repo, item, pathComponents[1:])
+				return printFromTree(ctx, subtree, repo, item, pathComponents[1:], format)
 			case dump.IsDir(node):
 				if err := checkStdoutTar(); err != nil {
 					return err
@@ -109,7 +114,7 @@ func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repositor
 				if err != nil {
 					return err
 				}
-				return dump.WriteTar(ctx, repo, subtree, item, os.Stdout)
+				return dump.Write(ctx, repo, subtree, item, format, os.Stdout)
 			case l > 1:
 				return fmt.Errorf("%q should be a dir, but is a %q", item, node.Type)
 			case !dump.IsFile(node):
@@ -176,7 +181,7 @@ func runDump(opts DumpOptions, gopts GlobalOptions, args []string) error {
 		Exitf(2, "loading tree for snapshot %q failed: %v", snapshotIDString, err)
 	}
 
-	err = printFromTree(ctx, tree, repo, "/", splittedPath)
+	err = printFromTree(ctx, tree, repo, "/", splittedPath, opts.Archive)
 	if err != nil {
 		Exitf(2, "cannot dump file: %v", err)
 	}
@@ -16,14 +16,17 @@ import (
 )
 
 var cmdFind = &cobra.Command{
-	Use:   "find [flags] PATTERN...",
+	Use:   "find [flags] [PATTERN...]",
 	Short: "Find a file, a directory or restic IDs",
 	Long: `
 The "find" command searches for files or directories in snapshots stored in the
 repo.
+With --expr, only files matching the expression are shown, the pattern may
+then be omitted.
 It can also be used to search for restic blobs or trees for troubleshooting.`,
 	Example: `restic find config.json
 restic find --json "*.yml" "*.json"
+restic find --expr "size > 100m and age < 7d"
 restic find --json --blob 420f620f b46ebe8a ddd38656
 restic find --show-pack-id --blob 420f620f
 restic find --tree 577c2bc9 f81f2e22 a62827a9
@@ -50,6 +53,7 @@ type FindOptions struct {
 	BlobID, TreeID     bool
 	PackID, ShowPackID bool
 	CaseInsensitive    bool
+	Expr               string
 	ListLong           bool
 	Hosts              []string
 	Paths              []string
@@ -70,6 +74,7 @@ func init() {
 	f.BoolVar(&findOptions.PackID, "pack", false, "pattern is a pack-ID")
 	f.BoolVar(&findOptions.ShowPackID, "show-pack-id", false, "display the pack-ID the blobs belong to (with --blob or --tree)")
 	f.BoolVarP(&findOptions.CaseInsensitive, "ignore-case", "i", false, "ignore case for pattern")
+	f.StringVar(&findOptions.Expr, "expr", "", "only show files matching the `expression`, e.g. \"type == file and size > 1g\"")
 	f.BoolVarP(&findOptions.ListLong, "long", "l", false, "use a long listing format showing size and mode")
 
 	f.StringArrayVarP(&findOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
@@ -81,6 +86,7 @@ type findPattern struct {
 	oldest, newest time.Time
 	pattern        []string
 	ignoreCase     bool
+	expr           *filter.Expr
 }
 
 var timeFormats = []string{
@@ -334,6 +340,11 @@ func (f *Finder) findInSnapshot(ctx context.Context, sn *restic.Snapshot) error
 			return ignoreIfNoMatch, errIfNoMatch
 		}
 
+		if f.pat.expr != nil && !f.pat.expr.Match(nodeAttributes(nodepath, node)) {
+			debug.Log("    not matched by expression")
+			return ignoreIfNoMatch, errIfNoMatch
+		}
+
 		debug.Log("    found match\n")
 		f.out.PrintPattern(nodepath, node)
 		return false, nil
@@ -490,12 +501,22 @@ func (f *Finder) findObjectsPacks(ctx context.Context) {
 }
 
 func runFind(opts FindOptions, gopts GlobalOptions, args []string) error {
-	if len(args) == 0 {
+	if len(args) == 0 && (opts.Expr == "" || opts.BlobID || opts.TreeID || opts.PackID) {
 		return errors.Fatal("wrong number of arguments")
 	}
 
 	var err error
 	pat := findPattern{pattern: args}
+	if opts.Expr != "" {
+		if pat.expr, err = filter.ParseExpr(opts.Expr, time.Now()); err != nil {
+			return errors.Fatal(err.Error())
+		}
+
+		if len(pat.pattern) == 0 {
+			// match all files
+			pat.pattern = []string{"*"}
+		}
+	}
 	if opts.CaseInsensitive {
 		for i := range pat.pattern {
 			pat.pattern[i] = strings.ToLower(pat.pattern[i])
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"strconv"
+
 	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/backend/location"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
 
 	"github.com/spf13/cobra"
 )
@@ -30,6 +33,12 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 type InitOptions struct {
 	secondaryRepoOptions
 	CopyChunkerParameters bool
+	RepositoryVersion     string
+	PackSize              uint
+	Parity                uint
+	ChunkMinSize          uint
+	ChunkAvgSize          uint
+	ChunkMaxSize          uint
 }
 
 var initOptions InitOptions
@@ -40,6 +49,12 @@ func init() {
 	f := cmdInit.Flags()
 	initSecondaryRepoOptions(f, &initOptions.secondaryRepoOptions, "secondary", "to copy chunker parameters from")
 	f.BoolVar(&initOptions.CopyChunkerParameters, "copy-chunker-params", false, "copy chunker parameters from the secondary repository (useful with the copy command)")
+	f.StringVar(&initOptions.RepositoryVersion, "repository-version", "stable", "repository format version to use, allowed values are a format version, 'latest' and 'stable'")
+	f.UintVar(&initOptions.PackSize, "pack-size", 0, "target `size` of pack files in MiB, stored in the repository config (default: 4)")
+	f.UintVar(&initOptions.Parity, "parity", 0, "store Reed-Solomon parity data of `percent` of the size of each pack file, which allows repairing damaged packs")
+	f.UintVar(&initOptions.ChunkAvgSize, "chunk-avg-size", 0, "average `size` of the chunks files are split into in KiB, must be a power of two (default: 1024)")
+	f.UintVar(&initOptions.ChunkMinSize, "chunk-min-size", 0, "minimal `size` of the chunks files are split into in KiB (default: half the average size)")
+	f.UintVar(&initOptions.ChunkMaxSize, "chunk-max-size", 0, "maximal `size` of the chunks files are split into in KiB (default: eight times the average size)")
 }
 
 func runInit(opts InitOptions, gopts GlobalOptions, args []string) error {
@@ -47,11 +62,41 @@ func runInit(opts InitOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("Please specify repository location (-r)")
 	}
 
-	chunkerPolynomial, err := maybeReadChunkerPolynomial(opts, gopts)
+	version, err := parseRepositoryVersion(opts.RepositoryVersion)
 	if err != nil {
 		return err
 	}
 
+	var packSize uint
+	if opts.PackSize != 0 {
+		packSize, err = restic.PackSizeFromMiB(opts.PackSize)
+		if err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
+	if opts.Parity != 0 {
+		if err := restic.CheckParity(opts.Parity); err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
+	chunkerSizes, err := parseChunkerSizes(opts)
+	if err != nil {
+		return err
+	}
+
+	chunkerPolynomial, otherSizes, err := maybeReadChunkerParameters(opts, gopts)
+	if err != nil {
+		return err
+	}
+	if opts.CopyChunkerParameters {
+		if chunkerSizes != (restic.ChunkerSizes{}) {
+			return errors.Fatal("chunk sizes cannot be specified when copying the chunker parameters")
+		}
+		chunkerSizes = otherSizes
+	}
+
 	repo, err := ReadRepo(gopts)
 	if err != nil {
 		return err
@@ -71,7 +116,7 @@ func runInit(opts InitOptions, gopts GlobalOptions, args []string) error {
 
 	s := repository.New(be)
 
-	err = s.Init(gopts.ctx, gopts.password, chunkerPolynomial)
+	err = s.Init(gopts.ctx, version, gopts.password, chunkerPolynomial, chunkerSizes, packSize, opts.Parity)
 	if err != nil {
 		return errors.Fatalf("create key in repository at %s failed: %v\n", location.StripPassword(gopts.Repo), err)
 	}
@@ -85,24 +130,86 @@ func runInit(opts InitOptions, gopts GlobalOptions, args []string) error {
 	return nil
 }
 
-func maybeReadChunkerPolynomial(opts InitOptions, gopts GlobalOptions) (*chunker.Pol, error) {
+// parseRepositoryVersion resolves the value of the --repository-version flag
+// to a repository format version.
+func parseRepositoryVersion(s string) (uint, error) {
+	switch s {
+	case "stable", "":
+		return restic.StableRepoVersion, nil
+	case "latest":
+		return restic.MaxRepoVersion, nil
+	}
+
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, errors.Fatalf("invalid repository version %q", s)
+	}
+
+	if v < restic.MinRepoVersion || v > restic.MaxRepoVersion {
+		return 0, errors.Fatalf("unsupported repository version %v, must be between %v and %v",
+			v, restic.MinRepoVersion, restic.MaxRepoVersion)
+	}
+
+	return uint(v), nil
+}
+
+// parseChunkerSizes returns the chunk sizes in bytes for the --chunk-*-size
+// flags, which are given in KiB. Sizes which are not specified are derived
+// from the average size. If no size is specified, the zero value is returned.
+func parseChunkerSizes(opts InitOptions) (restic.ChunkerSizes, error) {
+	if opts.ChunkMinSize == 0 && opts.ChunkAvgSize == 0 && opts.ChunkMaxSize == 0 {
+		return restic.ChunkerSizes{}, nil
+	}
+
+	// check the sizes in KiB first, converting them to bytes may overflow
+	for _, size := range []uint{opts.ChunkMinSize, opts.ChunkAvgSize, opts.ChunkMaxSize} {
+		if size > restic.MaxChunkSize/1024 {
+			return restic.ChunkerSizes{}, errors.Fatalf("invalid chunk size %d KiB, must be at most %d KiB",
+				size, restic.MaxChunkSize/1024)
+		}
+	}
+
+	sizes := restic.DefaultChunkerSizes
+	if opts.ChunkAvgSize != 0 {
+		sizes = restic.ChunkerSizesFromAvg(opts.ChunkAvgSize * 1024)
+	}
+	if opts.ChunkMinSize != 0 {
+		sizes.Min = opts.ChunkMinSize * 1024
+	}
+	if opts.ChunkMaxSize != 0 {
+		sizes.Max = opts.ChunkMaxSize * 1024
+	}
+
+	if err := restic.CheckChunkerSizes(sizes); err != nil {
+		return restic.ChunkerSizes{}, errors.Fatal(err.Error())
+	}
+
+	return sizes, nil
+}
+
+func maybeReadChunkerParameters(opts InitOptions, gopts GlobalOptions) (*chunker.Pol, restic.ChunkerSizes, error) {
 	if opts.CopyChunkerParameters {
 		otherGopts, err := fillSecondaryGlobalOpts(opts.secondaryRepoOptions, gopts, "secondary")
 		if err != nil {
-			return nil, err
+			return nil, restic.ChunkerSizes{}, err
 		}
 
 		otherRepo, err := OpenRepository(otherGopts)
 		if err != nil {
-			return nil, err
+			return nil, restic.ChunkerSizes{}, err
 		}
 
-		pol := otherRepo.Config().ChunkerPolynomial
-		return &pol, nil
+		cfg := otherRepo.Config()
+		sizes := restic.ChunkerSizes{
+			Min: cfg.ChunkerMinSize,
+			Avg: cfg.ChunkerAvgSize,
+			Max: cfg.ChunkerMaxSize,
+		}
+		return &cfg.ChunkerPolynomial, sizes, nil
 	}
 
 	if opts.Repo != "" {
-		return nil, errors.Fatal("Secondary repository must only be specified when copying the chunker parameters")
+		return nil, restic.ChunkerSizes{}, errors.Fatal("Secondary repository must only be specified when copying the chunker parameters")
 	}
-	return nil, nil
+	return nil, restic.ChunkerSizes{}, nil
 }
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
-	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/pack"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 
@@ -17,6 +25,19 @@ var cmdPrune = &cobra.Command{
 The "prune" command checks the repository and removes data that is not
 referenced and therefore not needed any more.
 
+Pack files which only contain unused data are deleted. Pack files which
+contain both used and unused data are only rewritten until the amount of
+unused data left in the repository is below the limit given by --max-unused.
+
+With --repack-small, pack files smaller than the target pack size are also
+rewritten, so that they are consolidated into larger ones.
+
+With --shared-lock, prune only takes a non-exclusive lock, so that backups
+can run concurrently. Packs are then not deleted right away but marked as
+pending deletion. They are removed by a later prune run once all processes
+which were running at that time have finished. Only one prune can run at a
+time, even with --shared-lock.
+
 EXIT STATUS
 ===========
 
@@ -24,12 +45,103 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runPrune(globalOptions)
+		return runPrune(pruneOptions, globalOptions)
 	},
 }
 
+// PruneOptions collects all options for the prune command.
+type PruneOptions struct {
+	MaxUnused string
+	// maxUnusedBytes returns the number of unused bytes which are tolerated
+	// after pruning, given the number of bytes still in use
+	maxUnusedBytes func(used uint64) (unused uint64)
+
+	MaxRepackSize  string
+	MaxRepackBytes uint64
+
+	RepackSmall bool
+
+	DryRun     bool
+	SharedLock bool
+}
+
+var pruneOptions PruneOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdPrune)
+	f := cmdPrune.Flags()
+	f.BoolVarP(&pruneOptions.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
+	f.BoolVar(&pruneOptions.SharedLock, "shared-lock", false, "only take a non-exclusive lock and mark packs for deletion by a later prune run")
+	addPruneOptions(cmdPrune)
+}
+
+func addPruneOptions(c *cobra.Command) {
+	f := c.Flags()
+	f.StringVar(&pruneOptions.MaxUnused, "max-unused", "5%", "tolerate given `limit` of unused data (absolute value in bytes with suffixes k/K, m/M, g/G, t/T, a value in % or the word 'unlimited')")
+	f.StringVar(&pruneOptions.MaxRepackSize, "max-repack-size", "", "maximum `size` to repack (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.BoolVar(&pruneOptions.RepackSmall, "repack-small", false, "consolidate pack files which are smaller than the target pack size")
+}
+
+func verifyPruneOptions(opts *PruneOptions) error {
+	if len(opts.MaxRepackSize) > 0 {
+		size, err := parseSizeStr(opts.MaxRepackSize)
+		if err != nil {
+			return errors.Fatalf("invalid size %q for --max-repack-size: %v", opts.MaxRepackSize, err)
+		}
+		if size < 0 {
+			return errors.Fatalf("invalid size %q for --max-repack-size: must not be negative", opts.MaxRepackSize)
+		}
+		opts.MaxRepackBytes = uint64(size)
+	}
+
+	maxUnused := strings.TrimSpace(opts.MaxUnused)
+	if maxUnused == "" {
+		return errors.Fatalf("invalid value for --max-unused: %q", opts.MaxUnused)
+	}
+
+	// parse MaxUnused either as unlimited, a percentage, or an absolute number of bytes
+	switch {
+	case maxUnused == "unlimited":
+		opts.maxUnusedBytes = func(used uint64) uint64 {
+			return math.MaxUint64
+		}
+
+	case strings.HasSuffix(maxUnused, "%"):
+		maxUnused = strings.TrimSuffix(maxUnused, "%")
+		p, err := strconv.ParseFloat(maxUnused, 64)
+		if err != nil {
+			return errors.Fatalf("invalid percentage %q passed for --max-unused: %v", opts.MaxUnused, err)
+		}
+
+		if p < 0 {
+			return errors.Fatal("percentage for --max-unused must be positive")
+		}
+
+		if p >= 100 {
+			return errors.Fatal("percentage for --max-unused must be below 100%")
+		}
+
+		// the percentage relates to the total size after pruning, i.e. the
+		// sum of used and unused data
+		opts.maxUnusedBytes = func(used uint64) uint64 {
+			return uint64(p / (100 - p) * float64(used))
+		}
+
+	default:
+		size, err := parseSizeStr(maxUnused)
+		if err != nil {
+			return errors.Fatalf("invalid number of bytes %q for --max-unused: %v", opts.MaxUnused, err)
+		}
+		if size < 0 {
+			return errors.Fatalf("invalid number of bytes %q for --max-unused: must not be negative", opts.MaxUnused)
+		}
+
+		opts.maxUnusedBytes = func(used uint64) uint64 {
+			return uint64(size)
+		}
+	}
+
+	return nil
 }
 
 func shortenStatus(maxLength int, s string) string {
@@ -44,13 +156,28 @@ func shortenStatus(maxLength int, s string) string {
 	return s[:maxLength-3] + "..."
 }
 
-func runPrune(gopts GlobalOptions) error {
+func runPrune(opts PruneOptions, gopts GlobalOptions) error {
+	err := verifyPruneOptions(&opts)
+	if err != nil {
+		return err
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
 
-	lock, err := lockRepoExclusive(repo)
+	var lock *restic.Lock
+	if opts.DryRun {
+		// a dry run does not modify the repository
+		lock, err = lockRepo(repo)
+	} else if opts.SharedLock {
+		// with a shared lock no pack is deleted which may still be in use,
+		// but only a single prune may run at a time
+		lock, err = lockRepoPrune(repo)
+	} else {
+		lock, err = lockRepoExclusive(repo)
+	}
 	defer unlockRepo(lock)
 	if err != nil {
 		return err
@@ -59,29 +186,77 @@ func runPrune(gopts GlobalOptions) error {
 	// we do not need index updates while pruning!
 	repo.DisableAutoIndexUpdate()
 
-	return pruneRepository(gopts, repo)
+	return pruneRepository(gopts, opts, repo)
+}
+
+const missingDataHint = "Data blobs seem to be missing, aborting prune to prevent further data loss!\n" +
+	"Please report this error (along with the output of the 'prune' run) at\n" +
+	"https://github.com/restic/restic/issues/new/choose"
+
+// packInfo collects the usage of the blobs in a single pack file.
+type packInfo struct {
+	usedBlobs      uint
+	unusedBlobs    uint
+	duplicateBlobs uint
+	usedSize       uint64
+	unusedSize     uint64
+	tpe            restic.BlobType // restic.InvalidBlob for packs with mixed blob types
 }
 
-func mixedBlobs(list []restic.Blob) bool {
-	var tree, data bool
+type packInfoWithID struct {
+	ID restic.ID
+	packInfo
 
-	for _, pb := range list {
-		switch pb.Type {
-		case restic.TreeBlob:
-			tree = true
-		case restic.DataBlob:
-			data = true
-		}
+	small bool // fully used pack below the target pack size
+}
 
-		if tree && data {
-			return true
-		}
+type pruneStats struct {
+	blobs struct {
+		used      uint
+		duplicate uint
+		unused    uint
+		remove    uint
+		repack    uint
+		repackrm  uint
+	}
+	size struct {
+		used      uint64
+		duplicate uint64
+		unused    uint64
+		remove    uint64
+		repack    uint64
+		repackrm  uint64
+		unref     uint64
+		repacked  uint64
 	}
+	packs struct {
+		used       uint
+		unused     uint
+		partlyUsed uint
+		keep       uint
+		repacked   uint
+		small      uint
+	}
+}
 
-	return false
+// prunePlan describes what prune is going to do with the repository.
+type prunePlan struct {
+	removePacksFirst restic.IDSet   // unreferenced packs, can be removed right away
+	repackPacks      restic.IDSet   // packs to repack
+	keepBlobs        restic.BlobSet // blobs to keep while repacking
+	removePacks      restic.IDSet   // unused packs to remove
+	ignorePacks      restic.IDSet   // unused packs missing in the repository, only dropped from the index
+	repackedPacks    restic.IDSet   // packs already repacked by an interrupted prune run, to remove
+	stateIDs         restic.IDSet   // prune states found in the repository
+	snapshots        restic.IDs     // snapshots the plan is based on
+
+	missingBlobs []restic.BlobHandle // used blobs not contained in the index
+	missingPacks restic.IDs          // needed packs referenced by the index but missing in the repository
+
+	stats pruneStats
 }
 
-func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
+func pruneRepository(gopts GlobalOptions, opts PruneOptions, repo restic.Repository) error {
 	ctx := gopts.ctx
 
 	err := repo.LoadIndex(ctx)
@@ -89,185 +264,723 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 		return err
 	}
 
-	var stats struct {
-		blobs     int
-		packs     int
-		snapshots int
-		bytes     int64
+	if gopts.verbosity >= 2 && !gopts.JSON {
+		Printf("%s\n", formatIndexMemoryUsage(repo.Index()))
 	}
 
-	Verbosef("counting files in repo\n")
-	err = repo.List(ctx, restic.PackFile, func(restic.ID, int64) error {
-		stats.packs++
-		return nil
-	})
+	stateIDs, repacked := loadPruneStates(gopts, repo)
+	if len(stateIDs) != 0 && !gopts.JSON {
+		Verbosef("resuming interrupted prune, %d packs were already repacked\n", len(repacked))
+	}
+
+	// packs marked by an earlier run can be deleted once all processes which
+	// may still use them have finished
+	pending, err := sweepPending(gopts, repo, opts.DryRun)
 	if err != nil {
 		return err
 	}
 
-	Verbosef("building new index for repo\n")
+	if !gopts.JSON {
+		Verbosef("load all snapshots\n")
+	}
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	if err != nil {
+		return err
+	}
 
-	bar := newProgressMax(!gopts.Quiet, uint64(stats.packs), "packs")
-	idx, invalidFiles, err := index.New(ctx, repo, restic.NewIDSet(), bar)
+	usedBlobs, err := getUsedBlobs(gopts, repo, snapshots)
 	if err != nil {
 		return err
 	}
 
-	for _, id := range invalidFiles {
-		Warnf("incomplete pack file (will be removed): %v\n", id)
+	plan, err := planPrune(gopts, opts, repo, usedBlobs, repacked, pending)
+	if err != nil {
+		return err
+	}
+	plan.stateIDs = stateIDs
+	for _, sn := range snapshots {
+		plan.snapshots = append(plan.snapshots, *sn.ID())
 	}
 
-	blobs := 0
-	for _, pack := range idx.Packs {
-		stats.bytes += pack.Size
-		blobs += len(pack.Entries)
+	if gopts.JSON {
+		err = printPrunePlanJSON(gopts.stdout, opts, plan)
+		if err != nil {
+			return err
+		}
+	} else {
+		printPruneStats(plan)
 	}
-	Verbosef("repository contains %v packs (%v blobs) with %v\n",
-		len(idx.Packs), blobs, formatBytes(uint64(stats.bytes)))
 
-	blobCount := make(map[restic.BlobHandle]int)
-	var duplicateBlobs uint64
-	var duplicateBytes uint64
+	if len(plan.missingBlobs) != 0 {
+		return errors.Fatalf("%v not found in the index\n"+missingDataHint, plan.missingBlobs)
+	}
 
-	// find duplicate blobs
-	for _, p := range idx.Packs {
-		for _, entry := range p.Entries {
-			stats.blobs++
-			h := restic.BlobHandle{ID: entry.ID, Type: entry.Type}
-			blobCount[h]++
+	if len(plan.missingPacks) != 0 {
+		return errors.Fatalf("%d packs referenced by the index are missing in the repository: %v\n"+missingDataHint,
+			len(plan.missingPacks), plan.missingPacks)
+	}
 
-			if blobCount[h] > 1 {
-				duplicateBlobs++
-				duplicateBytes += uint64(entry.Length)
-			}
+	if opts.DryRun {
+		if !gopts.JSON {
+			Verbosef("dry-run, not modifying the repository\n")
 		}
+		return nil
 	}
 
-	Verbosef("processed %d blobs: %d duplicate blobs, %v duplicate\n",
-		stats.blobs, duplicateBlobs, formatBytes(uint64(duplicateBytes)))
-	Verbosef("load all snapshots\n")
+	return doPrune(gopts, opts, repo, plan)
+}
+
+// planPrune decides which packs are deleted and which are repacked, based on
+// the index and the set of used blobs. The packs in repacked were reported as
+// already repacked by an interrupted prune run, the packs in pending are
+// already marked for deletion. It does not modify the repository.
+func planPrune(gopts GlobalOptions, opts PruneOptions, repo restic.Repository, usedBlobs restic.BlobSet,
+	repacked restic.IDSet, pending restic.IDSet) (plan prunePlan, err error) {
 
-	// find referenced blobs
-	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
-	if err != nil {
-		return err
+	ctx := gopts.ctx
+	stats := &plan.stats
+
+	if !gopts.JSON {
+		Verbosef("searching used packs\n")
 	}
 
-	stats.snapshots = len(snapshots)
+	// packs which were already repacked are ignored below, their blobs would
+	// otherwise show up as duplicates
+	repackedPacks := findRepackedPacks(ctx, repo, usedBlobs, repacked)
 
-	usedBlobs, err := getUsedBlobs(gopts, repo, snapshots)
-	if err != nil {
-		return err
+	keepBlobs := restic.NewBlobSet()
+	duplicateBlobs := restic.NewBlobSet()
+
+	// iterate over all blobs in index to find out which blobs are duplicates
+	for blob := range repo.Index().Each(ctx) {
+		if repackedPacks.Has(blob.PackID) {
+			continue
+		}
+		h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+		size := uint64(blob.Length)
+		switch {
+		case usedBlobs.Has(h): // used blob, move to keepBlobs
+			usedBlobs.Delete(h)
+			keepBlobs.Insert(h)
+			stats.size.used += size
+			stats.blobs.used++
+		case keepBlobs.Has(h): // duplicate blob
+			duplicateBlobs.Insert(h)
+			stats.size.duplicate += size
+			stats.blobs.duplicate++
+		default:
+			stats.size.unused += size
+			stats.blobs.unused++
+		}
 	}
 
-	var missingBlobs []restic.BlobHandle
+	// all blobs still left in usedBlobs are not contained in the index
 	for h := range usedBlobs {
-		if _, ok := blobCount[h]; !ok {
-			missingBlobs = append(missingBlobs, h)
+		plan.missingBlobs = append(plan.missingBlobs, h)
+	}
+
+	indexPack := make(map[restic.ID]packInfo)
+
+	// compute the usage of all packs, the header is counted as used data
+	for blob := range repo.Index().Each(ctx) {
+		if repackedPacks.Has(blob.PackID) {
+			continue
+		}
+		ip, ok := indexPack[blob.PackID]
+		if !ok {
+			ip = packInfo{tpe: blob.Type, usedSize: uint64(pack.HeaderSize)}
+		}
+		// mark mixed packs with "Invalid blob type"
+		if ip.tpe != blob.Type {
+			ip.tpe = restic.InvalidBlob
 		}
+
+		h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+		size := uint64(blob.Length)
+		ip.usedSize += uint64(pack.CalculateEntrySize(blob.Blob))
+		switch {
+		case duplicateBlobs.Has(h): // duplicate blob
+			ip.usedSize += size
+			ip.duplicateBlobs++
+		case keepBlobs.Has(h): // used blob, not duplicate
+			ip.usedSize += size
+			ip.usedBlobs++
+		default: // unused blob
+			ip.unusedSize += size
+			ip.unusedBlobs++
+		}
+
+		indexPack[blob.PackID] = ip
 	}
-	if len(missingBlobs) > 0 {
-		return errors.Fatalf("%v not found in the new index\n"+
-			"Data blobs seem to be missing, aborting prune to prevent further data loss!\n"+
-			"Please report this error (along with the output of the 'prune' run) at\n"+
-			"https://github.com/restic/restic/issues/new/choose", missingBlobs)
+
+	if !gopts.JSON {
+		Verbosef("collecting packs for deletion and repacking\n")
+	}
+	removePacksFirst := restic.NewIDSet()
+	removePacks := restic.NewIDSet()
+	repackPacks := restic.NewIDSet()
+
+	var repackCandidates []packInfoWithID
+	var repackSmallCandidates []packInfoWithID
+	var smallSize uint64
+	targetPackSize := uint64(repo.PackSize())
+
+	repack := func(id restic.ID, p packInfo) {
+		repackPacks.Insert(id)
+		stats.blobs.repack += p.unusedBlobs + p.duplicateBlobs + p.usedBlobs
+		stats.size.repack += p.unusedSize + p.usedSize
+		stats.blobs.repackrm += p.unusedBlobs
+		stats.size.repackrm += p.unusedSize
 	}
 
-	Verbosef("found %d of %d data blobs still in use, removing %d blobs\n",
-		len(usedBlobs), stats.blobs, stats.blobs-len(usedBlobs))
+	// repacked packs which are not found in the repository are only dropped
+	// from the index
+	missingRepackedPacks := restic.NewIDSet()
+	missingRepackedPacks.Merge(repackedPacks)
+
+	err = repo.List(ctx, restic.PackFile, func(id restic.ID, packSize int64) error {
+		if repackedPacks.Has(id) {
+			missingRepackedPacks.Delete(id)
+			stats.packs.repacked++
+			stats.size.repacked += uint64(packSize)
+			return nil
+		}
 
-	// find packs that need a rewrite
-	rewritePacks := restic.NewIDSet()
-	for _, pack := range idx.Packs {
-		if mixedBlobs(pack.Entries) {
-			rewritePacks.Insert(pack.ID)
-			continue
+		p, ok := indexPack[id]
+		if !ok && pending.Has(id) {
+			// pack is already marked for deletion
+			return nil
 		}
+		if !ok {
+			// pack is not referenced in the index and therefore not used => remove it first
+			debug.Log("pack %v is not contained in the index", id.Str())
+			removePacksFirst.Insert(id)
+			stats.size.unref += uint64(packSize)
+			return nil
+		}
+		delete(indexPack, id)
 
-		for _, blob := range pack.Entries {
-			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
-			if !usedBlobs.Has(h) {
-				rewritePacks.Insert(pack.ID)
-				continue
-			}
+		unused := p.usedBlobs == 0 && p.duplicateBlobs == 0
 
-			if blobCount[h] > 1 {
-				rewritePacks.Insert(pack.ID)
+		if p.unusedSize+p.usedSize != uint64(packSize) && !unused {
+			// packs which only contain unused blobs are removed below anyway
+			return errors.Fatalf("pack %v: calculated size %d does not match real size %d\nRun 'restic rebuild-index'.",
+				id.Str(), p.unusedSize+p.usedSize, packSize)
+		}
+
+		// statistics
+		switch {
+		case unused:
+			stats.packs.unused++
+		case p.unusedBlobs == 0:
+			stats.packs.used++
+		default:
+			stats.packs.partlyUsed++
+		}
+
+		// decide what to do
+		switch {
+		case unused:
+			// all blobs in pack are no longer used => remove pack!
+			removePacks.Insert(id)
+			stats.blobs.remove += p.unusedBlobs
+			stats.size.remove += p.unusedSize
+
+		case p.unusedBlobs == 0 && p.duplicateBlobs == 0 && p.tpe != restic.InvalidBlob:
+			if opts.RepackSmall && uint64(packSize) < targetPackSize {
+				// small packs are consolidated if there are enough of them, see below
+				repackSmallCandidates = append(repackSmallCandidates, packInfoWithID{ID: id, packInfo: p, small: true})
+				smallSize += uint64(packSize)
+				break
 			}
+
+			// all blobs in pack are used and not mixed => keep pack!
+			stats.packs.keep++
+
+		default:
+			// all other packs are candidates for repacking
+			repackCandidates = append(repackCandidates, packInfoWithID{ID: id, packInfo: p})
 		}
+
+		return nil
+	})
+	if err != nil {
+		return plan, err
 	}
 
-	removeBytes := duplicateBytes
+	// packs which are still left in indexPack are contained in the index but
+	// missing in the repository
+	ignorePacks := restic.NewIDSet()
+	for id, p := range indexPack {
+		if p.usedBlobs == 0 && p.duplicateBlobs == 0 {
+			// unused pack, just drop it from the index
+			ignorePacks.Insert(id)
+			stats.blobs.remove += p.unusedBlobs
+			stats.size.remove += p.unusedSize
+			continue
+		}
+		plan.missingPacks = append(plan.missingPacks, id)
+	}
+	ignorePacks.Merge(missingRepackedPacks)
+	repackedPacks = repackedPacks.Sub(missingRepackedPacks)
 
-	// find packs that are unneeded
-	removePacks := restic.NewIDSet()
+	if len(ignorePacks) != 0 && !gopts.JSON {
+		Warnf("missing but unneeded pack files are referenced in the index, will be repaired\n")
+		for id := range ignorePacks {
+			Warnf("will forget missing pack file %v\n", id)
+		}
+	}
 
-	Verbosef("will remove %d invalid files\n", len(invalidFiles))
-	for _, id := range invalidFiles {
-		removePacks.Insert(id)
+	// Consolidating small packs only pays off if they fill at least one pack
+	// of the target size. This also prevents repacking the same small packs
+	// on every run.
+	if smallSize >= targetPackSize {
+		repackCandidates = append(repackCandidates, repackSmallCandidates...)
+	} else {
+		stats.packs.keep += uint(len(repackSmallCandidates))
 	}
 
-	for packID, p := range idx.Packs {
+	// calculate limit for number of unused bytes in the repo after repacking
+	maxUnusedSizeAfter := opts.maxUnusedBytes(stats.size.used)
+
+	// Sort repackCandidates such that packs with highest ratio unused/used space are picked first.
+	// This is equivalent to sorting by unused / total space.
+	// Instead of unused[i] / used[i] > unused[j] / used[j] we use
+	// unused[i] * used[j] > unused[j] * used[i] as uint32*uint32 < uint64
+	// Moreover duplicates and packs containing trees are sorted to the beginning
+	sort.Slice(repackCandidates, func(i, j int) bool {
+		pi := repackCandidates[i].packInfo
+		pj := repackCandidates[j].packInfo
+		switch {
+		case pi.duplicateBlobs > 0 && pj.duplicateBlobs == 0:
+			return true
+		case pj.duplicateBlobs > 0 && pi.duplicateBlobs == 0:
+			return false
+		case pi.tpe != restic.DataBlob && pj.tpe == restic.DataBlob:
+			return true
+		case pj.tpe != restic.DataBlob && pi.tpe == restic.DataBlob:
+			return false
+		}
+		return pi.unusedSize*pj.usedSize > pj.unusedSize*pi.usedSize
+	})
+
+	reachedRepackSize := false
+	for _, p := range repackCandidates {
+		reachedUnusedSizeAfter := stats.size.unused-stats.size.remove-stats.size.repackrm < maxUnusedSizeAfter
+
+		if !reachedRepackSize && opts.MaxRepackBytes > 0 {
+			reachedRepackSize = stats.size.repack+p.unusedSize+p.usedSize > opts.MaxRepackBytes
+		}
+
+		switch {
+		case reachedRepackSize:
+			stats.packs.keep++
+
+		case p.small:
+			// consolidating small packs is only limited by the repack size
+			repack(p.ID, p.packInfo)
+			stats.packs.small++
+
+		case p.duplicateBlobs > 0, p.tpe != restic.DataBlob:
+			// repacking duplicates, trees and mixed packs is only limited by the repack size
+			repack(p.ID, p.packInfo)
+
+		case reachedUnusedSizeAfter:
+			// for all other packs stop repacking once the tolerated unused size is reached
+			stats.packs.keep++
 
-		hasActiveBlob := false
-		for _, blob := range p.Entries {
-			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
-			if usedBlobs.Has(h) {
-				hasActiveBlob = true
+		default:
+			repack(p.ID, p.packInfo)
+		}
+	}
+
+	if len(repackPacks) != 0 {
+		// blobs which are also contained in a pack that is kept need not be repacked
+		for blob := range repo.Index().Each(ctx) {
+			if repackPacks.Has(blob.PackID) || removePacks.Has(blob.PackID) {
 				continue
 			}
-
-			removeBytes += uint64(blob.Length)
+			keepBlobs.Delete(restic.BlobHandle{ID: blob.ID, Type: blob.Type})
 		}
+	}
+
+	plan.removePacksFirst = removePacksFirst
+	plan.removePacks = removePacks
+	plan.repackPacks = repackPacks
+	plan.ignorePacks = ignorePacks
+	plan.repackedPacks = repackedPacks
+	plan.keepBlobs = keepBlobs
+
+	return plan, nil
+}
 
-		if hasActiveBlob {
+// findRepackedPacks returns the packs from hints which are still contained in
+// the index and can be removed without repacking them: all used blobs in such
+// a pack must also be stored in a pack not listed in hints.
+func findRepackedPacks(ctx context.Context, repo restic.Repository, usedBlobs restic.BlobSet, hints restic.IDSet) restic.IDSet {
+	repackedPacks := restic.NewIDSet()
+	if len(hints) == 0 {
+		return repackedPacks
+	}
+
+	// collect all blobs stored outside of the packs listed in hints
+	storedBlobs := restic.NewBlobSet()
+	for blob := range repo.Index().Each(ctx) {
+		if hints.Has(blob.PackID) {
+			repackedPacks.Insert(blob.PackID)
 			continue
 		}
+		storedBlobs.Insert(restic.BlobHandle{ID: blob.ID, Type: blob.Type})
+	}
 
-		removePacks.Insert(packID)
+	for blob := range repo.Index().Each(ctx) {
+		if !repackedPacks.Has(blob.PackID) {
+			continue
+		}
 
-		if !rewritePacks.Has(packID) {
-			return errors.Fatalf("pack %v is unneeded, but not contained in rewritePacks", packID.Str())
+		h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+		if usedBlobs.Has(h) && !storedBlobs.Has(h) {
+			debug.Log("pack %v listed as repacked still holds the only copy of blob %v", blob.PackID.Str(), h)
+			repackedPacks.Delete(blob.PackID)
 		}
+	}
+
+	return repackedPacks
+}
 
-		rewritePacks.Delete(packID)
+// printPruneStats prints the statistics of plan to stdout.
+func printPruneStats(plan prunePlan) {
+	stats := plan.stats
+
+	Verbosef("\nused:         %10d blobs / %s\n", stats.blobs.used, formatBytes(stats.size.used))
+	if stats.blobs.duplicate > 0 {
+		Verbosef("duplicates:   %10d blobs / %s\n", stats.blobs.duplicate, formatBytes(stats.size.duplicate))
+	}
+	Verbosef("unused:       %10d blobs / %s\n", stats.blobs.unused, formatBytes(stats.size.unused))
+	if stats.size.unref > 0 {
+		Verbosef("unreferenced:                    %s\n", formatBytes(stats.size.unref))
+	}
+	if stats.size.repacked > 0 {
+		Verbosef("already repacked:                %s\n", formatBytes(stats.size.repacked))
+	}
+	totalBlobs := stats.blobs.used + stats.blobs.unused + stats.blobs.duplicate
+	totalSize := stats.size.used + stats.size.duplicate + stats.size.unused + stats.size.unref + stats.size.repacked
+	Verbosef("total:        %10d blobs / %s\n", totalBlobs, formatBytes(totalSize))
+	Verbosef("unused size: %s of total size\n", formatPercent(stats.size.unused+stats.size.unref+stats.size.repacked, totalSize))
+
+	Verbosef("\nto repack:    %10d blobs / %s\n", stats.blobs.repack, formatBytes(stats.size.repack))
+	Verbosef("this removes  %10d blobs / %s\n", stats.blobs.repackrm, formatBytes(stats.size.repackrm))
+	Verbosef("to delete:    %10d blobs / %s\n", stats.blobs.remove, formatBytes(stats.size.remove+stats.size.unref+stats.size.repacked))
+	totalPruneSize := stats.size.remove + stats.size.repackrm + stats.size.unref + stats.size.repacked
+	Verbosef("total prune:  %10d blobs / %s\n", stats.blobs.remove+stats.blobs.repackrm, formatBytes(totalPruneSize))
+	Verbosef("remaining:    %10d blobs / %s\n", totalBlobs-(stats.blobs.remove+stats.blobs.repackrm), formatBytes(totalSize-totalPruneSize))
+	unusedAfter := stats.size.unused - stats.size.remove - stats.size.repackrm
+	Verbosef("unused size after prune: %s (%s of remaining size)\n",
+		formatBytes(unusedAfter), formatPercent(unusedAfter, totalSize-totalPruneSize))
+	Verbosef("\n")
+
+	Verbosef("totally used packs: %10d\n", stats.packs.used)
+	Verbosef("partly used packs:  %10d\n", stats.packs.partlyUsed)
+	Verbosef("unused packs:       %10d\n\n", stats.packs.unused)
+
+	Verbosef("to keep:      %10d packs\n", stats.packs.keep)
+	Verbosef("to repack:    %10d packs\n", len(plan.repackPacks))
+	if stats.packs.small > 0 {
+		Verbosef("consolidate:  %10d small packs\n", stats.packs.small)
+	}
+	Verbosef("to delete:    %10d packs\n", len(plan.removePacks))
+	if len(plan.removePacksFirst) > 0 {
+		Verbosef("to delete:    %10d unreferenced packs\n", len(plan.removePacksFirst))
+	}
+	if len(plan.repackedPacks) > 0 {
+		Verbosef("to delete:    %10d already repacked packs\n", len(plan.repackedPacks))
+	}
+	Verbosef("\n")
+}
+
+// prunePlanJSON is the plan printed by prune in JSON mode.
+type prunePlanJSON struct {
+	DryRun bool `json:"dry_run"`
+
+	UsedBlobs      uint   `json:"used_blobs"`
+	UsedSize       uint64 `json:"used_size"`
+	DuplicateBlobs uint   `json:"duplicate_blobs"`
+	DuplicateSize  uint64 `json:"duplicate_size"`
+	UnusedBlobs    uint   `json:"unused_blobs"`
+	UnusedSize     uint64 `json:"unused_size"`
+
+	PacksToKeep               uint `json:"packs_to_keep"`
+	PacksToRepack             int  `json:"packs_to_repack"`
+	PacksToDelete             int  `json:"packs_to_delete"`
+	UnreferencedPacksToDelete int  `json:"unreferenced_packs_to_delete"`
+	RepackedPacksToDelete     int  `json:"repacked_packs_to_delete"`
+	SmallPacksToRepack        uint `json:"small_packs_to_repack"`
+	MissingPacksToForget      int  `json:"missing_packs_to_forget"`
+
+	BlobsToRepack  uint   `json:"blobs_to_repack"`
+	BytesToRepack  uint64 `json:"bytes_to_repack"`
+	BytesReupload  uint64 `json:"bytes_reupload"`
+	BlobsToRemove  uint   `json:"blobs_to_remove"`
+	BytesFreed     uint64 `json:"bytes_freed"`
+	UnusedSizeLeft uint64 `json:"unused_size_after_prune"`
+
+	MissingBlobs []missingBlobJSON `json:"missing_blobs"`
+	MissingPacks restic.IDs        `json:"missing_packs"`
+}
+
+type missingBlobJSON struct {
+	ID   restic.ID       `json:"id"`
+	Type restic.BlobType `json:"type"`
+}
+
+func printPrunePlanJSON(stdout io.Writer, opts PruneOptions, plan prunePlan) error {
+	stats := plan.stats
+
+	out := prunePlanJSON{
+		DryRun: opts.DryRun,
+
+		UsedBlobs:      stats.blobs.used,
+		UsedSize:       stats.size.used,
+		DuplicateBlobs: stats.blobs.duplicate,
+		DuplicateSize:  stats.size.duplicate,
+		UnusedBlobs:    stats.blobs.unused,
+		UnusedSize:     stats.size.unused,
+
+		PacksToKeep:               stats.packs.keep,
+		PacksToRepack:             len(plan.repackPacks),
+		PacksToDelete:             len(plan.removePacks),
+		UnreferencedPacksToDelete: len(plan.removePacksFirst),
+		RepackedPacksToDelete:     len(plan.repackedPacks),
+		SmallPacksToRepack:        stats.packs.small,
+		MissingPacksToForget:      len(plan.ignorePacks),
+
+		BlobsToRepack:  stats.blobs.repack,
+		BytesToRepack:  stats.size.repack,
+		BytesReupload:  stats.size.repack - stats.size.repackrm,
+		BlobsToRemove:  stats.blobs.remove + stats.blobs.repackrm,
+		BytesFreed:     stats.size.remove + stats.size.repackrm + stats.size.unref + stats.size.repacked,
+		UnusedSizeLeft: stats.size.unused - stats.size.remove - stats.size.repackrm,
+
+		MissingBlobs: []missingBlobJSON{},
+		MissingPacks: restic.IDs{},
+	}
+
+	for _, h := range plan.missingBlobs {
+		out.MissingBlobs = append(out.MissingBlobs, missingBlobJSON{ID: h.ID, Type: h.Type})
+	}
+	out.MissingPacks = append(out.MissingPacks, plan.missingPacks...)
+
+	return json.NewEncoder(stdout).Encode(out)
+}
+
+// doPrune executes plan and modifies the repository accordingly.
+func doPrune(gopts GlobalOptions, opts PruneOptions, repo restic.Repository, plan prunePlan) error {
+	ctx := gopts.ctx
+
+	removePacks := plan.removePacks
+
+	// unreferenced packs can be safely deleted first, unless they may belong
+	// to a backup which is still running
+	if len(plan.removePacksFirst) != 0 && !opts.SharedLock {
+		if !gopts.JSON {
+			Verbosef("deleting unreferenced packs\n")
+		}
+		DeleteFiles(gopts, repo, plan.removePacksFirst, restic.PackFile)
 	}
 
-	Verbosef("will delete %d packs and rewrite %d packs, this frees %s\n",
-		len(removePacks), len(rewritePacks), formatBytes(uint64(removeBytes)))
+	// packs repacked by an interrupted prune run only need to be removed
+	removePacks.Merge(plan.repackedPacks)
 
-	var obsoletePacks restic.IDSet
-	if len(rewritePacks) != 0 {
-		bar := newProgressMax(!gopts.Quiet, uint64(len(rewritePacks)), "packs rewritten")
-		obsoletePacks, err = repository.Repack(ctx, repo, rewritePacks, usedBlobs, bar)
+	if len(plan.repackPacks) != 0 {
+		if !gopts.JSON {
+			Verbosef("repacking packs\n")
+		}
+		err := repackInBatches(gopts, repo, plan)
 		if err != nil {
 			return err
 		}
+
+		// Also remove repacked packs
+		removePacks.Merge(plan.repackPacks)
 	}
 
-	removePacks.Merge(obsoletePacks)
+	if len(plan.ignorePacks)+len(removePacks) != 0 {
+		removePacks.Merge(plan.ignorePacks)
 
-	if err = rebuildIndex(ctx, repo, removePacks); err != nil {
-		return err
+		// the index now also contains the packs written while repacking
+		packs := restic.NewIDSet()
+		for blob := range repo.Index().Each(ctx) {
+			if !removePacks.Has(blob.PackID) {
+				packs.Insert(blob.PackID)
+			}
+		}
+
+		if !gopts.JSON {
+			Verbosef("rebuilding index\n")
+		}
+		bar := newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(len(packs)), "packs processed")
+		obsoleteIndexes, err := repo.Index().Save(ctx, repo, removePacks, bar)
+		if err != nil {
+			return err
+		}
+
+		if !gopts.JSON {
+			Verbosef("deleting obsolete index files\n")
+		}
+		err = DeleteFilesChecked(gopts, repo, obsoleteIndexes, restic.IndexFile)
+		if err != nil {
+			return errors.Fatalf("unable to remove an obsolete index: %v\n", err)
+		}
+
+		// missing packs need not be deleted
+		for id := range plan.ignorePacks {
+			removePacks.Delete(id)
+		}
+	}
+
+	if opts.SharedLock {
+		removePacks.Merge(plan.removePacksFirst)
 	}
 
-	if len(removePacks) != 0 {
-		Verbosef("remove %d old packs\n", len(removePacks))
+	if len(removePacks) != 0 && opts.SharedLock {
+		// other processes may still use the packs, they are only deleted by
+		// a later prune run
+		if !gopts.JSON {
+			Verbosef("marking %d old packs for deletion\n", len(removePacks))
+		}
+		err := markPending(gopts, repo, removePacks, plan.snapshots)
+		if err != nil {
+			return errors.Fatalf("unable to mark packs for deletion: %v\nRun 'restic rebuild-index' to add them to the index again.", err)
+		}
+	} else if len(removePacks) != 0 {
+		if !gopts.JSON {
+			Verbosef("removing %d old packs\n", len(removePacks))
+		}
 		DeleteFiles(gopts, repo, removePacks, restic.PackFile)
 	}
 
-	Verbosef("done\n")
+	// the repository is consistent again, the prune states are no longer needed
+	if len(plan.stateIDs) != 0 {
+		DeleteFiles(gopts, repo, plan.stateIDs, restic.PruneStateFile)
+	}
+
+	if repo.Config().Parity > 0 {
+		deleteOrphanedParity(gopts, repo)
+	}
+
+	if !gopts.JSON {
+		Verbosef("done\n")
+	}
+	return nil
+}
+
+// deleteOrphanedParity removes parity files for which the pack no longer
+// exists. Parity files are listed before the packs: a pack is always saved
+// before its parity file, so a pack which is saved concurrently is listed too.
+// Errors are only printed as warnings, the orphaned parity files are removed by
+// a later run.
+func deleteOrphanedParity(gopts GlobalOptions, repo restic.Repository) {
+	ctx := gopts.ctx
+
+	parityFiles := restic.NewIDSet()
+	err := repo.List(ctx, restic.ParityFile, func(id restic.ID, size int64) error {
+		parityFiles.Insert(id)
+		return nil
+	})
+	if err != nil {
+		if !gopts.JSON {
+			Warnf("unable to list parity files: %v\n", err)
+		}
+		return
+	}
+	if len(parityFiles) == 0 {
+		return
+	}
+
+	err = repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		parityFiles.Delete(id)
+		return nil
+	})
+	if err != nil {
+		if !gopts.JSON {
+			Warnf("unable to list packs: %v\n", err)
+		}
+		return
+	}
+
+	if len(parityFiles) != 0 {
+		if !gopts.JSON {
+			Verbosef("removing %d parity files of deleted packs\n", len(parityFiles))
+		}
+		DeleteFiles(gopts, repo, parityFiles, restic.ParityFile)
+	}
+}
+
+// repackInBatches repacks the packs selected in plan. After each batch the
+// index for the new packs has been written, the repacked packs are then
+// recorded in a new prune state. This allows an interrupted prune run to be
+// resumed without repacking these packs again. The state files are added to
+// plan.stateIDs, which are removed once prune has finished.
+func repackInBatches(gopts GlobalOptions, repo restic.Repository, plan prunePlan) error {
+	ctx := gopts.ctx
+
+	packs := plan.repackPacks.List()
+	sort.Sort(packs)
+
+	repacked := restic.NewIDSet()
+	var lastState restic.ID
+
+	bar := newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(len(packs)), "packs repacked")
+	bar.Start()
+	defer bar.Done()
+
+	for len(packs) > 0 {
+		n := pruneStateBatchSize
+		if n > len(packs) {
+			n = len(packs)
+		}
+
+		batch := restic.NewIDSet(packs[:n]...)
+		packs = packs[n:]
+
+		_, err := repository.Repack(ctx, repo, batch, plan.keepBlobs, bar)
+		if err != nil {
+			return err
+		}
+
+		repacked.Merge(batch)
+		id := savePruneState(gopts, repo, repacked)
+		if id.IsNull() {
+			continue
+		}
+		plan.stateIDs.Insert(id)
+
+		// the new state includes all packs listed in the previous one
+		if !lastState.IsNull() {
+			h := restic.Handle{Type: restic.PruneStateFile, Name: lastState.String()}
+			err = repo.Backend().Remove(ctx, h)
+			if err != nil {
+				debug.Log("unable to remove prune state %v: %v", lastState.Str(), err)
+			} else {
+				plan.stateIDs.Delete(lastState)
+			}
+		}
+		lastState = id
+	}
+
 	return nil
 }
 
 func getUsedBlobs(gopts GlobalOptions, repo restic.Repository, snapshots []*restic.Snapshot) (usedBlobs restic.BlobSet, err error) {
 	ctx := gopts.ctx
 
-	Verbosef("find data that is still in use for %d snapshots\n", len(snapshots))
+	if !gopts.JSON {
+		Verbosef("find data that is still in use for %d snapshots\n", len(snapshots))
+	}
 
 	usedBlobs = restic.NewBlobSet()
 
-	bar := newProgressMax(!gopts.Quiet, uint64(len(snapshots)), "snapshots")
+	bar := newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(len(snapshots)), "snapshots")
 	bar.Start()
 	defer bar.Done()
 	for _, sn := range snapshots {
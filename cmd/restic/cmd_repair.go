@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+)
+
+var cmdRepair = &cobra.Command{
+	Use:   "repair",
+	Short: "Repair the repository",
+}
+
+var cmdRepairSnapshots = &cobra.Command{
+	Use:   "snapshots [flags] [snapshot-ID ...]",
+	Short: "Repair snapshots which reference missing data",
+	Long: `
+The "repair snapshots" command salvages snapshots which reference data that is
+no longer contained in the repository. For each damaged snapshot, it saves a
+new snapshot which only references data that still exists:
+
+ * Files for which data is missing are truncated before the first missing
+   part, the amount of lost data is recorded as an error for the file.
+ * Directories which cannot be loaded are replaced by empty directories,
+   the reason is recorded as an error for the directory.
+
+The new snapshot refers to the damaged one as its original snapshot. The
+damaged snapshots are kept, unless --forget is given. Snapshots without damage
+are not modified.
+
+Missing data is detected using the index, so "restic rebuild-index" should be
+run first if pack files have been lost.
+
+When no snapshot-ID is given, all snapshots matching the host, tag and path
+filter criteria are repaired.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairSnapshots(repairSnapshotsOptions, globalOptions, args)
+	},
+}
+
+// RepairSnapshotsOptions bundles all options for the 'repair snapshots' command.
+type RepairSnapshotsOptions struct {
+	Hosts  []string
+	Paths  []string
+	Tags   restic.TagLists
+	DryRun bool
+	Forget bool
+}
+
+var repairSnapshotsOptions RepairSnapshotsOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdRepair)
+	cmdRepair.AddCommand(cmdRepairSnapshots)
+
+	f := cmdRepairSnapshots.Flags()
+	f.BoolVarP(&repairSnapshotsOptions.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
+	f.BoolVar(&repairSnapshotsOptions.Forget, "forget", false, "remove the damaged snapshots after saving the repaired ones")
+
+	f.StringArrayVarP(&repairSnapshotsOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
+	f.Var(&repairSnapshotsOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
+	f.StringArrayVar(&repairSnapshotsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
+}
+
+// repairDir is a directory whose new tree is being assembled by repairTree.
+type repairDir struct {
+	path string
+	// node is the node for the directory in the new parent tree, it is nil
+	// for the root directory
+	node    *restic.Node
+	tree    *restic.Tree
+	changed bool
+}
+
+// repairFile truncates the file node before the first data blob which is not
+// contained in the index. It returns true if the node was modified.
+func repairFile(repo restic.Repository, node *restic.Node) bool {
+	var size uint64
+	for i, id := range node.Content {
+		blobSize, ok := repo.LookupBlobSize(id, restic.DataBlob)
+		if ok {
+			size += uint64(blobSize)
+			continue
+		}
+
+		msg := fmt.Sprintf("file was truncated from %d to %d bytes, data blob %v is missing", node.Size, size, id.Str())
+		if node.Error != "" {
+			msg = node.Error + "; " + msg
+		}
+
+		node.Error = msg
+		node.Content = node.Content[:i:i]
+		node.Size = size
+		return true
+	}
+
+	return false
+}
+
+// repairTree walks the tree with the given ID and assembles new trees in
+// which files with missing data are truncated and directories which cannot be
+// loaded are replaced by empty directories. It returns the ID of the new root
+// tree and whether anything was changed. Trees are only saved if they were
+// changed and dryRun is false.
+func repairTree(ctx context.Context, repo restic.Repository, id restic.ID, dryRun bool) (restic.ID, bool, error) {
+	var (
+		stack       []*repairDir
+		newID       = id
+		changed     bool
+		emptyTreeID *restic.ID
+	)
+
+	saveTree := func(tree *restic.Tree) (restic.ID, error) {
+		if dryRun {
+			return restic.ID{}, nil
+		}
+		return repo.SaveTree(ctx, tree)
+	}
+
+	// finish completes the innermost directory and saves its tree if it was
+	// changed
+	finish := func() error {
+		dir := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if !dir.changed {
+			return nil
+		}
+
+		treeID, err := saveTree(dir.tree)
+		if err != nil {
+			return err
+		}
+
+		if dir.node == nil {
+			newID = treeID
+			changed = true
+			return nil
+		}
+
+		dir.node.Subtree = &treeID
+		stack[len(stack)-1].changed = true
+		return nil
+	}
+
+	err := walker.Walk(ctx, repo, id, nil, func(_ restic.ID, nodepath string, node *restic.Node, nodeErr error) (bool, error) {
+		if node == nil {
+			if nodeErr != nil {
+				return false, errors.Errorf("unable to load the root tree: %v", nodeErr)
+			}
+			stack = append(stack, &repairDir{path: "/", tree: restic.NewTree()})
+			return false, nil
+		}
+
+		for path.Dir(nodepath) != stack[len(stack)-1].path {
+			if err := finish(); err != nil {
+				return false, err
+			}
+		}
+		dir := stack[len(stack)-1]
+
+		// the node belongs to the tree loaded by the walker, work on a copy
+		n := *node
+		node = &n
+
+		var skip bool
+		switch {
+		case node.Type == "dir" && nodeErr != nil:
+			Verbosef("  dir %q: replaced with an empty directory\n", nodepath)
+			if emptyTreeID == nil {
+				treeID, err := saveTree(restic.NewTree())
+				if err != nil {
+					return false, err
+				}
+				emptyTreeID = &treeID
+			}
+
+			node.Subtree = emptyTreeID
+			node.Error = fmt.Sprintf("directory was replaced, it could not be loaded: %v", nodeErr)
+			dir.changed = true
+			skip = true
+
+		case node.Type == "dir":
+			stack = append(stack, &repairDir{path: nodepath, node: node, tree: restic.NewTree()})
+
+		case node.Type == "file":
+			if repairFile(repo, node) {
+				Verbosef("  file %q: truncated, data is missing\n", nodepath)
+				dir.changed = true
+			}
+		}
+
+		if err := dir.tree.Insert(node); err != nil {
+			return false, err
+		}
+
+		if skip {
+			return false, walker.ErrSkipNode
+		}
+		return false, nil
+	})
+	if err != nil {
+		return restic.ID{}, false, err
+	}
+
+	for len(stack) > 0 {
+		if err := finish(); err != nil {
+			return restic.ID{}, false, err
+		}
+	}
+
+	return newID, changed, nil
+}
+
+func runRepairSnapshots(opts RepairSnapshotsOptions, gopts GlobalOptions, args []string) error {
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		if opts.DryRun {
+			lock, err = lockRepo(repo)
+		} else {
+			Verbosef("create exclusive lock for repository\n")
+			lock, err = lockRepoExclusive(repo)
+		}
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	Verbosef("load index files\n")
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	repaired, failed := 0, 0
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
+		Verbosef("\nsnapshot %s of %v at %s\n", sn.ID().Str(), sn.Paths, sn.Time)
+
+		treeID, changed, err := repairTree(ctx, repo, *sn.Tree, opts.DryRun)
+		if err != nil {
+			Warnf("unable to repair snapshot %v: %v\n", sn.ID().Str(), err)
+			failed++
+			continue
+		}
+
+		if !changed {
+			Verbosef("  snapshot is intact\n")
+			continue
+		}
+		repaired++
+
+		if opts.DryRun {
+			Verbosef("  would save a repaired snapshot\n")
+			continue
+		}
+
+		err = repo.Flush(ctx)
+		if err != nil {
+			return err
+		}
+
+		oldID := *sn.ID()
+		sn.Tree = &treeID
+		sn.Original = &oldID
+
+		id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+		if err != nil {
+			return err
+		}
+		debug.Log("repaired snapshot %v saved as %v", oldID, id)
+		Verbosef("  saved repaired snapshot %v\n", id.Str())
+
+		if opts.Forget {
+			h := restic.Handle{Type: restic.SnapshotFile, Name: oldID.String()}
+			if err = repo.Backend().Remove(ctx, h); err != nil {
+				return err
+			}
+			Verbosef("  removed damaged snapshot %v\n", oldID.Str())
+		}
+	}
+
+	Verbosef("\n")
+	switch {
+	case repaired == 0:
+		Verbosef("no snapshots were modified\n")
+	case opts.DryRun:
+		Verbosef("would repair %d snapshots\n", repaired)
+	default:
+		Verbosef("repaired %d snapshots\n", repaired)
+	}
+
+	if failed > 0 {
+		return errors.Fatalf("%d snapshots could not be repaired, use \"restic forget\" to remove them", failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRepairTree(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx := context.TODO()
+	data := rtest.Random(23, 1000)
+	blobID, _, err := repo.SaveBlob(ctx, restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+	missing := restic.NewRandomID()
+	emptyID, err := repo.SaveTree(ctx, restic.NewTree())
+	rtest.OK(t, err)
+
+	subtree := restic.NewTree()
+	rtest.OK(t, subtree.Insert(&restic.Node{Name: "intact", Type: "file", Size: 1000, Content: restic.IDs{blobID}}))
+	rtest.OK(t, subtree.Insert(&restic.Node{Name: "truncated", Type: "file", Size: 3000, Content: restic.IDs{blobID, missing, blobID}}))
+	subtreeID, err := repo.SaveTree(ctx, subtree)
+	rtest.OK(t, err)
+
+	root := restic.NewTree()
+	rtest.OK(t, root.Insert(&restic.Node{Name: "broken", Type: "dir", Subtree: &missing}))
+	rtest.OK(t, root.Insert(&restic.Node{Name: "sub", Type: "dir", Subtree: &subtreeID}))
+	rootID, err := repo.SaveTree(ctx, root)
+	rtest.OK(t, err)
+
+	intact := restic.NewTree()
+	rtest.OK(t, intact.Insert(&restic.Node{Name: "sub", Type: "dir", Subtree: &emptyID}))
+	intactID, err := repo.SaveTree(ctx, intact)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(ctx))
+
+	// an intact tree is not modified
+	id, changed, err := repairTree(ctx, repo, intactID, false)
+	rtest.OK(t, err)
+	rtest.Assert(t, !changed, "intact tree was changed")
+	rtest.Equals(t, intactID, id)
+
+	id, changed, err = repairTree(ctx, repo, rootID, false)
+	rtest.OK(t, err)
+	rtest.Assert(t, changed, "damaged tree was not changed")
+	rtest.OK(t, repo.Flush(ctx))
+
+	newRoot, err := repo.LoadTree(ctx, id)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(newRoot.Nodes))
+
+	broken := newRoot.Nodes[0]
+	rtest.Equals(t, "broken", broken.Name)
+	rtest.Assert(t, broken.Error != "", "replaced directory has no error")
+	emptyTree, err := repo.LoadTree(ctx, *broken.Subtree)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(emptyTree.Nodes))
+	rtest.Equals(t, emptyID, *broken.Subtree)
+
+	sub := newRoot.Nodes[1]
+	rtest.Assert(t, !sub.Subtree.Equal(subtreeID), "subtree was not replaced")
+	newSubtree, err := repo.LoadTree(ctx, *sub.Subtree)
+	rtest.OK(t, err)
+	rtest.Equals(t, subtree.Nodes[0], newSubtree.Nodes[0])
+
+	truncated := newSubtree.Nodes[1]
+	rtest.Equals(t, restic.IDs{blobID}, truncated.Content)
+	rtest.Equals(t, uint64(1000), truncated.Size)
+	rtest.Assert(t, truncated.Error != "", "truncated file has no error")
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/restorer"
+	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/json"
+	"github.com/restic/restic/internal/ui/termstatus"
 
 	"github.com/spf13/cobra"
+	tomb "gopkg.in/tomb.v2"
 )
 
 var cmdRestore = &cobra.Command{
@@ -29,7 +37,16 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runRestore(restoreOptions, globalOptions, args)
+		var t tomb.Tomb
+		term := termstatus.New(globalOptions.stdout, globalOptions.stderr, globalOptions.Quiet)
+		t.Go(func() error { term.Run(t.Context(globalOptions.ctx)); return nil })
+
+		err := runRestore(restoreOptions, globalOptions, term, args)
+		if err != nil {
+			return err
+		}
+		t.Kill(nil)
+		return t.Wait()
 	},
 }
 
@@ -39,11 +56,21 @@ type RestoreOptions struct {
 	InsensitiveExclude []string
 	Include            []string
 	InsensitiveInclude []string
+	ExcludeExprs       []string
+	IncludeExprs       []string
 	Target             string
 	Hosts              []string
 	Paths              []string
 	Tags               restic.TagLists
 	Verify             bool
+	Sparse             bool
+	Overwrite          restorer.OverwriteBehavior
+	Delete             bool
+	DryRun             bool
+	NoOwner            bool
+	OwnerByName        bool
+	MapUIDs            []string
+	MapGIDs            []string
 }
 
 var restoreOptions RestoreOptions
@@ -52,22 +79,32 @@ func init() {
 	cmdRoot.AddCommand(cmdRestore)
 
 	flags := cmdRestore.Flags()
-	flags.StringArrayVarP(&restoreOptions.Exclude, "exclude", "e", nil, "exclude a `pattern` (can be specified multiple times)")
+	flags.StringArrayVarP(&restoreOptions.Exclude, "exclude", "e", nil, "exclude a `pattern`, a pattern starting with ! includes matching files again (can be specified multiple times)")
 	flags.StringArrayVar(&restoreOptions.InsensitiveExclude, "iexclude", nil, "same as `--exclude` but ignores the casing of filenames")
-	flags.StringArrayVarP(&restoreOptions.Include, "include", "i", nil, "include a `pattern`, exclude everything else (can be specified multiple times)")
+	flags.StringArrayVarP(&restoreOptions.Include, "include", "i", nil, "include a `pattern`, exclude everything else, a pattern starting with ! excludes matching files again (can be specified multiple times)")
 	flags.StringArrayVar(&restoreOptions.InsensitiveInclude, "iinclude", nil, "same as `--include` but ignores the casing of filenames")
+	flags.StringArrayVar(&restoreOptions.ExcludeExprs, "exclude-expr", nil, "exclude files matching the `expression` (can be specified multiple times)")
+	flags.StringArrayVar(&restoreOptions.IncludeExprs, "include-expr", nil, "include files matching the `expression`, exclude everything else (can be specified multiple times)")
 	flags.StringVarP(&restoreOptions.Target, "target", "t", "", "directory to extract data to")
 
 	flags.StringArrayVarP(&restoreOptions.Hosts, "host", "H", nil, `only consider snapshots for this host when the snapshot ID is "latest" (can be specified multiple times)`)
 	flags.Var(&restoreOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
 	flags.StringArrayVar(&restoreOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
 	flags.BoolVar(&restoreOptions.Verify, "verify", false, "verify restored files content")
+	flags.BoolVar(&restoreOptions.Sparse, "sparse", false, "restore files as sparse files, parts containing only zeros are not written")
+	flags.Var(&restoreOptions.Overwrite, "overwrite", "overwrite behavior for existing files, one of (always|if-changed|if-newer|never)")
+	flags.BoolVar(&restoreOptions.Delete, "delete", false, "delete files from the target directory which are not in the snapshot")
+	flags.BoolVarP(&restoreOptions.DryRun, "dry-run", "n", false, "do not download or write anything, just print what would be done")
+	flags.BoolVar(&restoreOptions.NoOwner, "no-owner", false, "do not restore the owner of files, they belong to the current user")
+	flags.BoolVar(&restoreOptions.OwnerByName, "owner-by-name", false, "restore the owner of files by looking up the user and group names in the local database")
+	flags.StringArrayVar(&restoreOptions.MapUIDs, "map-uid", nil, "restore files owned by user `old:new` with user ID new (can be specified multiple times)")
+	flags.StringArrayVar(&restoreOptions.MapGIDs, "map-gid", nil, "restore files owned by group `old:new` with group ID new (can be specified multiple times)")
 }
 
-func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
+func runRestore(opts RestoreOptions, gopts GlobalOptions, term *termstatus.Terminal, args []string) error {
 	ctx := gopts.ctx
-	hasExcludes := len(opts.Exclude) > 0 || len(opts.InsensitiveExclude) > 0
-	hasIncludes := len(opts.Include) > 0 || len(opts.InsensitiveInclude) > 0
+	hasExcludes := len(opts.Exclude) > 0 || len(opts.InsensitiveExclude) > 0 || len(opts.ExcludeExprs) > 0
+	hasIncludes := len(opts.Include) > 0 || len(opts.InsensitiveInclude) > 0 || len(opts.IncludeExprs) > 0
 
 	for i, str := range opts.InsensitiveExclude {
 		opts.InsensitiveExclude[i] = strings.ToLower(str)
@@ -92,6 +129,21 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("exclude and include patterns are mutually exclusive")
 	}
 
+	excludeExprs, err := parseExprs(opts.ExcludeExprs)
+	if err != nil {
+		return err
+	}
+
+	includeExprs, err := parseExprs(opts.IncludeExprs)
+	if err != nil {
+		return err
+	}
+
+	owner, err := ownerMapping(opts)
+	if err != nil {
+		return err
+	}
+
 	snapshotIDString := args[0]
 
 	debug.Log("restore %v to %v", snapshotIDString, opts.Target)
@@ -132,14 +184,62 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 	if err != nil {
 		Exitf(2, "creating restorer failed: %v\n", err)
 	}
+	res.Sparse = opts.Sparse
+	res.Overwrite = opts.Overwrite
+	res.Delete = opts.Delete
+	res.DryRun = opts.DryRun
+	res.Owner = owner
+
+	type RestoreProgressReporter interface {
+		ReportTotal(files, bytes uint64)
+		CompleteBlob(location string, bytes uint64)
+		CompleteFile(location string)
+		SkipFile(location string, size uint64)
+		PlanFile(location string, action restorer.FileAction, size uint64)
+		SetDryRun()
+		SetMinUpdatePause(d time.Duration)
+		Run(ctx context.Context) error
+		Error(location string, err error) error
+		Finish()
+
+		// ui.Message
+		E(msg string, args ...interface{})
+		P(msg string, args ...interface{})
+		V(msg string, args ...interface{})
+		VV(msg string, args ...interface{})
+	}
+
+	var p RestoreProgressReporter
+	if gopts.JSON {
+		p = json.NewRestore(term, gopts.verbosity)
+	} else {
+		p = ui.NewRestore(term, gopts.verbosity)
+	}
+
+	if s, ok := os.LookupEnv("RESTIC_PROGRESS_FPS"); ok {
+		fps, err := strconv.Atoi(s)
+		if err == nil && fps >= 1 {
+			if fps > 60 {
+				fps = 60
+			}
+			p.SetMinUpdatePause(time.Second / time.Duration(fps))
+		}
+	}
 
-	totalErrors := 0
-	res.Error = func(location string, err error) error {
-		Warnf("ignoring error for %s: %s\n", location, err)
-		totalErrors++
-		return nil
+	if opts.DryRun {
+		p.SetDryRun()
 	}
 
+	var t tomb.Tomb
+	t.Go(func() error { return p.Run(t.Context(ctx)) })
+
+	res.Error = p.Error
+	res.ReportTotal = p.ReportTotal
+	res.CompleteBlob = p.CompleteBlob
+	res.CompleteFile = p.CompleteFile
+	res.SkipFile = p.SkipFile
+	res.PlanFile = p.PlanFile
+
 	selectExcludeFilter := func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
 		matched, _, err := filter.List(opts.Exclude, item)
 		if err != nil {
@@ -155,7 +255,9 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		// so even if a childMayMatch, other children of a dir may not,
 		// therefore childMayMatch does not matter, but we should not go down
 		// unless the dir is selected for restore
-		selectedForRestore = !matched && !matchedInsensitive
+		matchedExpr := matchAnyExpr(excludeExprs, nodeAttributes(item, node))
+
+		selectedForRestore = !matched && !matchedInsensitive && !matchedExpr
 		childMayBeSelected = selectedForRestore && node.Type == "dir"
 
 		return selectedForRestore, childMayBeSelected
@@ -172,8 +274,12 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 			Warnf("error for iexclude pattern: %v", err)
 		}
 
-		selectedForRestore = matched || matchedInsensitive
-		childMayBeSelected = (childMayMatch || childMayMatchInsensitive) && node.Type == "dir"
+		// any file in a directory may be matched by an expression
+		matchedExpr := matchAnyExpr(includeExprs, nodeAttributes(item, node))
+		childMayMatchExpr := len(includeExprs) > 0
+
+		selectedForRestore = matched || matchedInsensitive || matchedExpr
+		childMayBeSelected = (childMayMatch || childMayMatchInsensitive || childMayMatchExpr) && node.Type == "dir"
 
 		return selectedForRestore, childMayBeSelected
 	}
@@ -184,17 +290,89 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		res.SelectFilter = selectIncludeFilter
 	}
 
-	Verbosef("restoring %s to %s\n", res.Snapshot(), opts.Target)
+	if !gopts.JSON {
+		p.V("restoring %s to %s", res.Snapshot(), opts.Target)
+	}
 
 	err = res.RestoreTo(ctx, opts.Target)
-	if err == nil && opts.Verify {
-		Verbosef("verifying files in %s\n", opts.Target)
+
+	// cleanly shutdown the progress reporter, also if the restore failed
+	t.Kill(nil)
+	werr := t.Wait()
+	p.Finish()
+	if err != nil {
+		return err
+	}
+	if werr != nil {
+		return werr
+	}
+
+	if opts.Verify && !opts.DryRun {
+		if !gopts.JSON {
+			p.V("verifying files in %s", opts.Target)
+		}
 		var count int
 		count, err = res.VerifyFiles(ctx, opts.Target)
-		Verbosef("finished verifying %d files in %s\n", count, opts.Target)
-	}
-	if totalErrors > 0 {
-		Printf("There were %d errors\n", totalErrors)
+		if !gopts.JSON {
+			p.V("finished verifying %d files in %s", count, opts.Target)
+		}
 	}
 	return err
 }
+
+// ownerMapping returns the owner mapping configured by opts, or nil if the IDs
+// stored in the snapshot are used.
+func ownerMapping(opts RestoreOptions) (*restorer.OwnerMapping, error) {
+	mapIDs := len(opts.MapUIDs) > 0 || len(opts.MapGIDs) > 0
+	if opts.NoOwner && (opts.OwnerByName || mapIDs) {
+		return nil, errors.Fatal("--no-owner cannot be combined with --owner-by-name, --map-uid or --map-gid")
+	}
+
+	if !opts.NoOwner && !opts.OwnerByName && !mapIDs {
+		return nil, nil
+	}
+
+	uids, err := parseIDMap(opts.MapUIDs)
+	if err != nil {
+		return nil, errors.Fatalf("invalid --map-uid: %v", err)
+	}
+
+	gids, err := parseIDMap(opts.MapGIDs)
+	if err != nil {
+		return nil, errors.Fatalf("invalid --map-gid: %v", err)
+	}
+
+	return &restorer.OwnerMapping{
+		NoOwner: opts.NoOwner,
+		ByName:  opts.OwnerByName,
+		UIDs:    uids,
+		GIDs:    gids,
+	}, nil
+}
+
+// parseIDMap parses a list of "old:new" pairs of numeric IDs.
+func parseIDMap(list []string) (map[uint32]uint32, error) {
+	ids := make(map[uint32]uint32, len(list))
+	for _, s := range list {
+		data := strings.SplitN(s, ":", 2)
+		if len(data) != 2 {
+			return nil, errors.Errorf("%q is not in the form old:new", s)
+		}
+
+		var pair [2]uint32
+		for i, str := range data {
+			id, err := strconv.ParseUint(str, 10, 32)
+			if err != nil {
+				return nil, errors.Errorf("%q is not a valid ID", str)
+			}
+			pair[i] = uint32(id)
+		}
+
+		if _, ok := ids[pair[0]]; ok {
+			return nil, errors.Errorf("ID %d is mapped more than once", pair[0])
+		}
+		ids[pair[0]] = pair[1]
+	}
+
+	return ids, nil
+}
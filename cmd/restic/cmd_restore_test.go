@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestoreParseIDMap(t *testing.T) {
+	var tests = []struct {
+		list  []string
+		ids   map[uint32]uint32
+		valid bool
+	}{
+		{nil, map[uint32]uint32{}, true},
+		{[]string{"1000:1001"}, map[uint32]uint32{1000: 1001}, true},
+		{[]string{"0:65534", "33:0"}, map[uint32]uint32{0: 65534, 33: 0}, true},
+		{[]string{"1000"}, nil, false},
+		{[]string{"1000:"}, nil, false},
+		{[]string{"alice:1000"}, nil, false},
+		{[]string{"1000:-1"}, nil, false},
+		{[]string{"1:2:3"}, nil, false},
+		{[]string{"1:2", "1:3"}, nil, false},
+	}
+
+	for _, test := range tests {
+		ids, err := parseIDMap(test.list)
+		if !test.valid {
+			rtest.Assert(t, err != nil, "expected error for %v", test.list)
+			continue
+		}
+		rtest.OK(t, err)
+		rtest.Equals(t, test.ids, ids)
+	}
+}
@@ -163,9 +163,17 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 		return list[i].Time.Before(list[j].Time)
 	})
 
-	// Determine the max widths for host and tag.
+	// Determine the max widths for host and tag, and whether any snapshot has
+	// a summary or is a checkpoint.
 	maxHost, maxTag := 10, 6
+	hasSummary, hasCheckpoint := false, false
 	for _, sn := range list {
+		if sn.Summary != nil {
+			hasSummary = true
+		}
+		if sn.Checkpoint {
+			hasCheckpoint = true
+		}
 		if len(sn.Hostname) > maxHost {
 			maxHost = len(sn.Hostname)
 		}
@@ -183,24 +191,37 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 		tab.AddColumn("Time", "{{ .Timestamp }}")
 		tab.AddColumn("Host", "{{ .Hostname }}")
 		tab.AddColumn("Tags  ", `{{ join .Tags "\n" }}`)
+		if hasCheckpoint {
+			tab.AddColumn("Checkpoint", "{{ .Checkpoint }}")
+		}
 	} else {
 		tab.AddColumn("ID", "{{ .ID }}")
 		tab.AddColumn("Time", "{{ .Timestamp }}")
 		tab.AddColumn("Host      ", "{{ .Hostname }}")
 		tab.AddColumn("Tags      ", `{{ join .Tags "," }}`)
+		if hasCheckpoint {
+			tab.AddColumn("Checkpoint", "{{ .Checkpoint }}")
+		}
 		if len(reasons) > 0 {
 			tab.AddColumn("Reasons", `{{ join .Reasons "\n" }}`)
 		}
+		if hasSummary {
+			tab.AddColumn("Size", "{{ .Size }}")
+			tab.AddColumn("Added", "{{ .Added }}")
+		}
 		tab.AddColumn("Paths", `{{ join .Paths "\n" }}`)
 	}
 
 	type snapshot struct {
-		ID        string
-		Timestamp string
-		Hostname  string
-		Tags      []string
-		Reasons   []string
-		Paths     []string
+		ID         string
+		Timestamp  string
+		Hostname   string
+		Tags       []string
+		Checkpoint string
+		Reasons    []string
+		Size       string
+		Added      string
+		Paths      []string
 	}
 
 	var multiline bool
@@ -213,6 +234,15 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 			Paths:     sn.Paths,
 		}
 
+		if sn.Summary != nil {
+			data.Size = formatBytes(sn.Summary.TotalBytesProcessed)
+			data.Added = formatBytes(sn.Summary.DataAdded)
+		}
+
+		if sn.Checkpoint {
+			data.Checkpoint = "yes"
+		}
+
 		if len(reasons) > 0 {
 			id := sn.ID()
 			data.Reasons = keepReasons[*id].Matches
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// commandOutput is a file in the snapshot whose content is the output of a
+// command.
+type commandOutput struct {
+	Filename string
+	Args     []string
+}
+
+// parseCommandOutput parses a "filename=command" string. The command is split
+// into arguments like a shell would do it, but it is not run by a shell.
+func parseCommandOutput(s string) (commandOutput, error) {
+	data := strings.SplitN(s, "=", 2)
+	if len(data) != 2 || data[0] == "" {
+		return commandOutput{}, errors.Fatalf("invalid command output %q, must be filename=command", s)
+	}
+
+	args, err := backend.SplitShellStrings(data[1])
+	if err != nil {
+		return commandOutput{}, errors.Fatalf("unable to parse command %q: %v", data[1], err)
+	}
+
+	if len(args) == 0 {
+		return commandOutput{}, errors.Fatalf("invalid command output %q, no command specified", s)
+	}
+
+	return commandOutput{
+		Filename: path.Join("/", data[0]),
+		Args:     args,
+	}, nil
+}
+
+// parseCommandOutputs parses all "filename=command" strings and ensures that
+// the filenames are unique.
+func parseCommandOutputs(list []string) (outputs []commandOutput, err error) {
+	seen := make(map[string]struct{})
+	for _, s := range list {
+		output, err := parseCommandOutput(s)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := seen[output.Filename]; ok {
+			return nil, errors.Fatalf("filename %v is used for more than one command output", output.Filename)
+		}
+		seen[output.Filename] = struct{}{}
+
+		outputs = append(outputs, output)
+	}
+
+	return outputs, nil
+}
+
+// commandReader returns the output of a running command. When the output has
+// been read completely, the exit status of the command is checked. If the
+// command failed, Read returns an error instead of io.EOF.
+type commandReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	eof    bool
+
+	wait    sync.Once
+	waitErr error
+}
+
+// startCommand runs the command described by args. The command is killed when
+// ctx is cancelled. Its stderr is passed through.
+func startCommand(ctx context.Context, args []string) (*commandReader, error) {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "StdoutPipe")
+	}
+
+	debug.Log("start command %v", args)
+	err = cmd.Start()
+	if err != nil {
+		return nil, errors.Fatalf("unable to run command %q: %v", args[0], err)
+	}
+
+	return &commandReader{cmd: cmd, stdout: stdout}, nil
+}
+
+// Wait waits for the command to exit and returns an error if it failed.
+func (r *commandReader) Wait() error {
+	r.wait.Do(func() {
+		err := r.cmd.Wait()
+		debug.Log("command %v exited: %v", r.cmd.Args, err)
+		if err != nil {
+			r.waitErr = errors.Errorf("command %q failed: %v", strings.Join(r.cmd.Args, " "), err)
+		}
+	})
+
+	return r.waitErr
+}
+
+func (r *commandReader) Read(p []byte) (int, error) {
+	// the output is closed by Wait, so it must not be read again
+	if r.eof {
+		return 0, r.eofErr()
+	}
+
+	n, err := r.stdout.Read(p)
+	if err == io.EOF {
+		r.eof = true
+		return n, r.eofErr()
+	}
+
+	return n, err
+}
+
+// eofErr returns io.EOF if the command was successful, and the reason
+// otherwise.
+func (r *commandReader) eofErr() error {
+	if err := r.Wait(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// Close closes the output of the command and waits for it to exit. A command
+// which is still writing output receives an error and is expected to exit.
+func (r *commandReader) Close() error {
+	_ = r.stdout.Close()
+	return r.Wait()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseCommandOutputs(t *testing.T) {
+	var tests = []struct {
+		input []string
+		want  []commandOutput
+	}{
+		{
+			[]string{"db.sql=pg_dump mydb"},
+			[]commandOutput{{"/db.sql", []string{"pg_dump", "mydb"}}},
+		},
+		{
+			[]string{"dumps/a.sql=sh -c 'dump a | gzip'", "/b=dump=b"},
+			[]commandOutput{
+				{"/dumps/a.sql", []string{"sh", "-c", "dump a | gzip"}},
+				{"/b", []string{"dump=b"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		outputs, err := parseCommandOutputs(test.input)
+		rtest.OK(t, err)
+		rtest.Equals(t, test.want, outputs)
+	}
+
+	for _, input := range [][]string{
+		{"pg_dump"},
+		{"=pg_dump"},
+		{"db.sql="},
+		{"db.sql=pg_dump a", "/db.sql=pg_dump b"},
+	} {
+		_, err := parseCommandOutputs(input)
+		rtest.Assert(t, err != nil, "expected error for %v", input)
+	}
+}
+
+func TestCommandReaderClose(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a POSIX shell")
+	}
+
+	rd, err := startCommand(context.TODO(), []string{"sh", "-c", "exit 0"})
+	rtest.OK(t, err)
+	rtest.OK(t, rd.Close())
+
+	// the exit status is reported even if the output was never read
+	rd, err = startCommand(context.TODO(), []string{"sh", "-c", "exit 3"})
+	rtest.OK(t, err)
+	rtest.Assert(t, rd.Close() != nil, "failed command was not reported")
+	rtest.Assert(t, rd.Close() != nil, "failed command was not reported on second Close")
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/textfile"
 )
 
 type rejectionCache struct {
@@ -198,6 +199,80 @@ func isDirExcludedByFile(dir, tagFilename, header string) bool {
 	return true
 }
 
+// ignoreListCache caches the parsed ignore files per directory, nil is stored
+// for directories without an ignore file.
+type ignoreListCache struct {
+	m   map[string]*filter.IgnoreList
+	mtx sync.Mutex
+}
+
+// Get returns the ignore list for dir, it is loaded from the file filename in
+// dir on first use.
+func (c *ignoreListCache) Get(dir, filename string) *filter.IgnoreList {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	list, ok := c.m[dir]
+	if !ok {
+		list = loadIgnoreList(dir, filename)
+		c.m[dir] = list
+	}
+
+	return list
+}
+
+func loadIgnoreList(dir, filename string) *filter.IgnoreList {
+	f := filepath.Join(dir, filename)
+	data, err := textfile.Read(f)
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil
+	}
+	if err != nil {
+		Warnf("could not read ignore file: %v\n", err)
+		return nil
+	}
+
+	list, err := filter.ParseIgnoreList(dir, data)
+	if err != nil {
+		Warnf("ignore file %v is invalid and not used: %v\n", f, err)
+		return nil
+	}
+
+	debug.Log("loaded ignore file %v", f)
+	return list
+}
+
+// rejectByIgnoreFile returns a RejectFunc which rejects files according to the
+// patterns in the ignore files named filename in the directories above them.
+// The patterns are interpreted like the patterns in a .gitignore file, a
+// pattern in a deeper directory overrides the patterns from the directories
+// above.
+func rejectByIgnoreFile(filename string) (RejectFunc, error) {
+	if filename == "" || filepath.Base(filename) != filename {
+		return nil, errors.Fatalf("invalid name for ignore file %q", filename)
+	}
+
+	cache := &ignoreListCache{m: make(map[string]*filter.IgnoreList)}
+	return func(item string, fi os.FileInfo) bool {
+		dir := filepath.Dir(item)
+		for {
+			list := cache.Get(dir, filename)
+			if list != nil {
+				matched, ignored := list.Match(item, fi.IsDir())
+				if matched {
+					return ignored
+				}
+			}
+
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return false
+			}
+			dir = parent
+		}
+	}, nil
+}
+
 // gatherDevices returns the set of unique device ids of the files and/or
 // directory paths listed in "items".
 func gatherDevices(items []string) (deviceMap map[string]uint64, err error) {
@@ -312,7 +387,30 @@ func rejectBySize(maxSizeStr string) (RejectFunc, error) {
 	}, nil
 }
 
+// rejectByExpr returns a RejectFunc which rejects files matching one of the
+// expressions. Directories are only rejected by expressions which test the
+// type, see matchAnyExpr.
+func rejectByExpr(exprs []string) (RejectFunc, error) {
+	compiled, err := parseExprs(exprs)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(item string, fi os.FileInfo) bool {
+		if matchAnyExpr(compiled, fileInfoAttributes(item, fi)) {
+			debug.Log("rejecting %v: matched by expression", item)
+			return true
+		}
+
+		return false
+	}, nil
+}
+
 func parseSizeStr(sizeStr string) (int64, error) {
+	if sizeStr == "" {
+		return 0, errors.New("expected size, got empty string")
+	}
+
 	numStr := sizeStr[:len(sizeStr)-1]
 	var unit int64 = 1
 
@@ -332,7 +430,7 @@ func parseSizeStr(sizeStr string) (int64, error) {
 	}
 	value, err := strconv.ParseInt(numStr, 10, 64)
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 	return value * unit, nil
 }
@@ -36,6 +36,33 @@ func TestRejectByPattern(t *testing.T) {
 	}
 }
 
+func TestRejectByPatternNegated(t *testing.T) {
+	var tests = []struct {
+		filename string
+		reject   bool
+	}{
+		{filename: "/home/alice/.cache/foo", reject: true},
+		{filename: "/home/alice/.cache/important", reject: false},
+		{filename: "/home/alice/.cache/important/x", reject: false},
+		{filename: "/home/bob/.cache/important", reject: true},
+		{filename: "/home/bob/foo.log", reject: true},
+		{filename: "/home/bob/keep.log", reject: false},
+	}
+
+	patterns := []string{"/home/*/.cache/*", "!/home/alice/.cache/important", "*.log", "!keep.log"}
+
+	for _, tc := range tests {
+		t.Run("", func(t *testing.T) {
+			reject := rejectByPattern(patterns)
+			res := reject(tc.filename)
+			if res != tc.reject {
+				t.Fatalf("wrong result for filename %v: want %v, got %v",
+					tc.filename, tc.reject, res)
+			}
+		})
+	}
+}
+
 func TestRejectByInsensitivePattern(t *testing.T) {
 	var tests = []struct {
 		filename string
@@ -190,6 +217,59 @@ func TestMultipleIsExcludedByFile(t *testing.T) {
 	}
 }
 
+func TestRejectByIgnoreFile(t *testing.T) {
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	files := map[string]string{
+		".resticignore":     "*.log\n!important.log\ncache/\n",
+		"a.log":             "",
+		"important.log":     "",
+		"cache/file":        "",
+		"sub/.resticignore": "!*.log\nsecret\n",
+		"sub/b.log":         "",
+		"sub/secret":        "",
+		"sub/other":         "",
+		"sub/deeper/c.log":  "",
+	}
+	for name, content := range files {
+		filename := filepath.Join(tempDir, filepath.FromSlash(name))
+		test.OK(t, os.MkdirAll(filepath.Dir(filename), 0700))
+		test.OK(t, ioutil.WriteFile(filename, []byte(content), 0600))
+	}
+
+	var tests = []struct {
+		path   string
+		reject bool
+	}{
+		{".resticignore", false},
+		{"a.log", true},
+		{"important.log", false},
+		{"cache", true},
+		{"sub", false},
+		{"sub/b.log", false},
+		{"sub/deeper/c.log", false},
+		{"sub/secret", true},
+		{"sub/other", false},
+	}
+
+	reject, err := rejectByIgnoreFile(".resticignore")
+	test.OK(t, err)
+
+	for _, tc := range tests {
+		filename := filepath.Join(tempDir, filepath.FromSlash(tc.path))
+		fi, err := os.Lstat(filename)
+		test.OK(t, err)
+
+		if res := reject(filename, fi); res != tc.reject {
+			t.Errorf("wrong result for %v: want %v, got %v", tc.path, tc.reject, res)
+		}
+	}
+
+	_, err = rejectByIgnoreFile("dir/.resticignore")
+	test.Assert(t, err != nil, "ignore file name with a directory was accepted")
+}
+
 func TestParseSizeStr(t *testing.T) {
 	sizeStrTests := []struct {
 		in       string
@@ -217,6 +297,13 @@ func TestParseSizeStr(t *testing.T) {
 			t.Errorf("parseSizeStr(%s) = %d; expected %d", tt.in, actual, tt.expected)
 		}
 	}
+
+	for _, in := range []string{"", "k", "foo", "10x"} {
+		_, err := parseSizeStr(in)
+		if err == nil {
+			t.Errorf("parseSizeStr(%q) did not return an error", in)
+		}
+	}
 }
 
 // TestIsExcludedByFileSize is for testing the instance of
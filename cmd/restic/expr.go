@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// parseExprs compiles the expressions given with --exclude-expr and similar
+// options.
+func parseExprs(exprs []string) ([]*filter.Expr, error) {
+	now := time.Now()
+
+	var res []*filter.Expr
+	for _, s := range exprs {
+		expr, err := filter.ParseExpr(s, now)
+		if err != nil {
+			return nil, errors.Fatal(err.Error())
+		}
+		res = append(res, expr)
+	}
+
+	return res, nil
+}
+
+// matchAnyExpr returns true if one of the expressions matches a. Selecting a
+// directory also selects everything below it, so directories are only matched
+// by expressions which explicitly test the type.
+func matchAnyExpr(exprs []*filter.Expr, a *filter.Attributes) bool {
+	for _, expr := range exprs {
+		if a.Type == "dir" && !expr.TestsType() {
+			continue
+		}
+		if expr.Match(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeAttributes returns the attributes of the node at path in a snapshot.
+func nodeAttributes(path string, node *restic.Node) *filter.Attributes {
+	return &filter.Attributes{
+		Path:    path,
+		Type:    node.Type,
+		Mode:    node.Mode,
+		Size:    node.Size,
+		ModTime: node.ModTime,
+		UID:     node.UID,
+		GID:     node.GID,
+		User:    node.User,
+		Group:   node.Group,
+	}
+}
+
+// fileInfoAttributes returns the attributes of the file at path, the names of
+// the owner and group are looked up.
+func fileInfoAttributes(path string, fi os.FileInfo) *filter.Attributes {
+	stat := fs.ExtendedStat(fi)
+
+	a := &filter.Attributes{
+		Path:    path,
+		Mode:    fi.Mode(),
+		ModTime: fi.ModTime(),
+		UID:     stat.UID,
+		GID:     stat.GID,
+		User:    ownerNames.user(stat.UID),
+		Group:   ownerNames.group(stat.GID),
+	}
+
+	switch fi.Mode() & (os.ModeType | os.ModeCharDevice) {
+	case 0:
+		a.Type = "file"
+		a.Size = uint64(fi.Size())
+	case os.ModeDir:
+		a.Type = "dir"
+	case os.ModeSymlink:
+		a.Type = "symlink"
+	case os.ModeDevice | os.ModeCharDevice:
+		a.Type = "chardev"
+	case os.ModeDevice:
+		a.Type = "dev"
+	case os.ModeNamedPipe:
+		a.Type = "fifo"
+	case os.ModeSocket:
+		a.Type = "socket"
+	}
+
+	return a
+}
+
+// ownerNameCache caches the names of users and groups.
+type ownerNameCache struct {
+	m      sync.Mutex
+	users  map[uint32]string
+	groups map[uint32]string
+}
+
+var ownerNames = &ownerNameCache{
+	users:  make(map[uint32]string),
+	groups: make(map[uint32]string),
+}
+
+// user returns the name of the user with the given uid, or the empty string
+// if the user does not exist.
+func (c *ownerNameCache) user(uid uint32) string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	name, ok := c.users[uid]
+	if !ok {
+		if u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10)); err == nil {
+			name = u.Username
+		}
+		c.users[uid] = name
+	}
+	return name
+}
+
+// group returns the name of the group with the given gid, or the empty string
+// if the group does not exist.
+func (c *ownerNameCache) group(gid uint32) string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	name, ok := c.groups[gid]
+	if !ok {
+		if g, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10)); err == nil {
+			name = g.Name
+		}
+		c.groups[gid] = name
+	}
+	return name
+}
@@ -25,6 +25,13 @@ func formatBytes(c uint64) string {
 	}
 }
 
+// formatIndexMemoryUsage returns a short description of the number of blobs in
+// the index and the memory it uses.
+func formatIndexMemoryUsage(idx restic.MasterIndex) string {
+	blobs := idx.Count(restic.DataBlob) + idx.Count(restic.TreeBlob)
+	return fmt.Sprintf("index contains %d blobs and uses %s of memory", blobs, formatBytes(idx.MemoryUsage()))
+}
+
 func formatSeconds(sec uint64) string {
 	hours := sec / 3600
 	sec -= hours * 3600
@@ -436,6 +436,15 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 	}
 
 	s := repository.New(be)
+	s.Warnf = Warnf
+
+	ropts := repository.Options{}
+	if err := opts.extended.Extract("repository").Apply("repository", &ropts); err != nil {
+		return nil, err
+	}
+	if err := s.ApplyOptions(ropts); err != nil {
+		return nil, errors.Fatal(err.Error())
+	}
 
 	passwordTriesLeft := 1
 	if stdinIsTerminal() && opts.password == "" {
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	mrand "math/rand"
 	"os"
 	"path/filepath"
@@ -21,8 +24,10 @@ import (
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/restorer"
 	rtest "github.com/restic/restic/internal/test"
 	"github.com/restic/restic/internal/ui/termstatus"
 	"golang.org/x/sync/errgroup"
@@ -97,6 +102,26 @@ func testRunList(t testing.TB, tpe string, opts GlobalOptions) restic.IDs {
 	return parseIDsFromReader(t, buf)
 }
 
+func testRunRestoreAssumeFailure(snapshotID string, opts RestoreOptions, gopts GlobalOptions) error {
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	var wg errgroup.Group
+	term := termstatus.New(gopts.stdout, gopts.stderr, gopts.Quiet)
+	wg.Go(func() error { term.Run(ctx); return nil })
+
+	restoreErr := runRestore(opts, gopts, term, []string{snapshotID})
+
+	cancel()
+
+	err := wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	return restoreErr
+}
+
 func testRunRestore(t testing.TB, opts GlobalOptions, dir string, snapshotID restic.ID) {
 	testRunRestoreExcludes(t, opts, dir, snapshotID, nil)
 }
@@ -108,7 +133,7 @@ func testRunRestoreLatest(t testing.TB, gopts GlobalOptions, dir string, paths [
 		Paths:  paths,
 	}
 
-	rtest.OK(t, runRestore(opts, gopts, []string{"latest"}))
+	rtest.OK(t, testRunRestoreAssumeFailure("latest", opts, gopts))
 }
 
 func testRunRestoreExcludes(t testing.TB, gopts GlobalOptions, dir string, snapshotID restic.ID, excludes []string) {
@@ -117,7 +142,7 @@ func testRunRestoreExcludes(t testing.TB, gopts GlobalOptions, dir string, snaps
 		Exclude: excludes,
 	}
 
-	rtest.OK(t, runRestore(opts, gopts, []string{snapshotID.String()}))
+	rtest.OK(t, testRunRestoreAssumeFailure(snapshotID.String(), opts, gopts))
 }
 
 func testRunRestoreIncludes(t testing.TB, gopts GlobalOptions, dir string, snapshotID restic.ID, includes []string) {
@@ -126,7 +151,7 @@ func testRunRestoreIncludes(t testing.TB, gopts GlobalOptions, dir string, snaps
 		Include: includes,
 	}
 
-	rtest.OK(t, runRestore(opts, gopts, []string{snapshotID.String()}))
+	rtest.OK(t, testRunRestoreAssumeFailure(snapshotID.String(), opts, gopts))
 }
 
 func testRunCheck(t testing.TB, gopts GlobalOptions) {
@@ -269,8 +294,8 @@ func testRunForgetJSON(t testing.TB, gopts GlobalOptions, args ...string) {
 		"Expected 2 snapshots to be removed, got %v", len(forgets[0].Remove))
 }
 
-func testRunPrune(t testing.TB, gopts GlobalOptions) {
-	rtest.OK(t, runPrune(gopts))
+func testRunPrune(t testing.TB, gopts GlobalOptions, opts PruneOptions) {
+	rtest.OK(t, runPrune(opts, gopts))
 }
 
 func testSetupBackupData(t testing.TB, env *testEnvironment) string {
@@ -520,6 +545,138 @@ func TestBackupErrors(t *testing.T) {
 		"expected one snapshot, got %v", snapshotIDs)
 }
 
+func TestBackupCommandOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a POSIX shell")
+	}
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	opts := BackupOptions{
+		CommandOutputs: []string{
+			"foo=echo foobar",
+			"dir/bar=sh -c 'echo first; echo second'",
+		},
+	}
+	testRunBackup(t, "", nil, opts, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	testRunCheck(t, env.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+	for name, want := range map[string]string{
+		"foo":     "foobar\n",
+		"dir/bar": "first\nsecond\n",
+	} {
+		buf, err := ioutil.ReadFile(filepath.Join(restoredir, filepath.FromSlash(name)))
+		rtest.OK(t, err)
+		rtest.Equals(t, want, string(buf))
+	}
+
+	// a failed command aborts the backup
+	opts.CommandOutputs = []string{
+		"foo=echo foobar",
+		"failed=sh -c 'echo partial; exit 1'",
+	}
+	gopts := env.gopts
+	gopts.stderr = ioutil.Discard
+	err := testRunBackupAssumeFailure(t, "", nil, opts, gopts)
+	rtest.Assert(t, err != nil, "failed command did not abort the backup")
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	// a command which fails after closing its output is reported as well
+	opts.CommandOutputs = []string{
+		"foo=echo foobar",
+		"failed=sh -c 'exec >&-; sleep 0.2; exit 1'",
+	}
+	err = testRunBackupAssumeFailure(t, "", nil, opts, gopts)
+	rtest.Assert(t, err != nil, "failed command did not fail the backup")
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	// the command output cannot be combined with files
+	err = testRunBackupAssumeFailure(t, "", []string{env.testdata}, opts, gopts)
+	rtest.Assert(t, err != nil, "command output and files were accepted together")
+}
+
+func TestBackupFromTar(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	modtime := time.Unix(1600000000, 0)
+	data := rtest.Random(23, 3*1024*1024)
+
+	archive := filepath.Join(env.base, "archive.tar.gz")
+	f, err := os.Create(archive)
+	rtest.OK(t, err)
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	for _, hdr := range []*tar.Header{
+		{Name: "data/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: modtime},
+		{Name: "data/file", Typeflag: tar.TypeReg, Mode: 0644, ModTime: modtime, Size: int64(len(data))},
+		{Name: "data/sub/link", Typeflag: tar.TypeSymlink, Linkname: "../file", Mode: 0777, ModTime: modtime},
+		{Name: "data/hardlink", Typeflag: tar.TypeLink, Linkname: "data/file", Mode: 0644, ModTime: modtime},
+	} {
+		rtest.OK(t, tw.WriteHeader(hdr))
+		if hdr.Size > 0 {
+			_, err = tw.Write(data)
+			rtest.OK(t, err)
+		}
+	}
+	rtest.OK(t, tw.Close())
+	rtest.OK(t, zw.Close())
+	rtest.OK(t, f.Close())
+
+	testRunBackup(t, "", nil, BackupOptions{FromTar: archive}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	testRunCheck(t, env.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+
+	buf, err := ioutil.ReadFile(filepath.Join(restoredir, "data", "file"))
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(data, buf), "restored file differs")
+
+	fi, err := os.Lstat(filepath.Join(restoredir, "data", "file"))
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.ModTime().Equal(modtime), "wrong modification time %v", fi.ModTime())
+
+	if runtime.GOOS != "windows" {
+		target, err := os.Readlink(filepath.Join(restoredir, "data", "sub", "link"))
+		rtest.OK(t, err)
+		rtest.Equals(t, "../file", target)
+
+		fi2, err := os.Lstat(filepath.Join(restoredir, "data", "hardlink"))
+		rtest.OK(t, err)
+		rtest.Assert(t, os.SameFile(fi, fi2), "hardlink was not restored")
+	}
+
+	// the first snapshot is used as the parent for a second backup
+	testRunBackup(t, "", nil, BackupOptions{FromTar: archive}, env.gopts)
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+	testRunCheck(t, env.gopts)
+
+	// the implicitly created directory data/sub must not change
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	var trees []restic.ID
+	for _, id := range snapshotIDs {
+		sn, err := restic.LoadSnapshot(env.gopts.ctx, repo, id)
+		rtest.OK(t, err)
+		trees = append(trees, *sn.Tree)
+	}
+	rtest.Equals(t, trees[0], trees[1])
+}
+
 const (
 	incrementalFirstWrite  = 10 * 1042 * 1024
 	incrementalSecondWrite = 1 * 1042 * 1024
@@ -586,6 +743,240 @@ func TestIncrementalBackup(t *testing.T) {
 	t.Logf("repository grown by %d bytes", stat3.size-stat2.size)
 }
 
+func TestBackupDryRun(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{DryRun: true}
+
+	// a dry run of the first backup does not write anything
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	for _, tpe := range []string{"snapshots", "packs", "index"} {
+		ids := testRunList(t, tpe, env.gopts)
+		rtest.Assert(t, len(ids) == 0, "expected no %v, got %v", tpe, ids)
+	}
+
+	opts.DryRun = false
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	stat1 := dirStats(env.repo)
+
+	// a dry run of a changed backup does not modify the repository
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "new-file"), []byte("new content"), 0644))
+	opts.DryRun = true
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	stat2 := dirStats(env.repo)
+	rtest.Equals(t, stat1, stat2)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	testRunCheck(t, env.gopts)
+}
+
+func TestBackupSummary(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	newest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
+	rtest.Assert(t, newest.Summary != nil, "expected a summary for the snapshot")
+	rtest.Assert(t, newest.Summary.FilesNew > 0 && newest.Summary.FilesNew == newest.Summary.TotalFilesProcessed,
+		"unexpected summary %+v", newest.Summary)
+	rtest.Assert(t, newest.Summary.DataAdded > 0, "no data added in summary %+v", newest.Summary)
+
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.stdout = buf
+	rtest.OK(t, runSnapshots(SnapshotOptions{}, gopts, nil))
+
+	header := strings.Fields(strings.SplitN(buf.String(), "\n", 2)[0])
+	rtest.Equals(t, []string{"ID", "Time", "Host", "Tags", "Size", "Added", "Paths"}, header)
+}
+
+func TestRestoreSparse(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	const size = 20 * 1024 * 1024
+	data := make([]byte, size)
+	copy(data[size/2:], "foobar")
+
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	filename := filepath.Join(env.testdata, "sparse")
+	rtest.OK(t, ioutil.WriteFile(filename, data, 0644))
+
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	restoredir := filepath.Join(env.base, "restore")
+	opts := RestoreOptions{
+		Target: restoredir,
+		Sparse: true,
+	}
+	rtest.OK(t, testRunRestoreAssumeFailure(snapshotIDs[0].String(), opts, env.gopts))
+
+	target := filepath.Join(restoredir, filename)
+	buf, err := ioutil.ReadFile(target)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(data, buf), "restored file has wrong content")
+
+	fi, err := os.Lstat(target)
+	rtest.OK(t, err)
+	stat := fs.ExtendedStat(fi)
+	if stat.Blocks == 0 && stat.Size > 0 {
+		// allocated blocks are not reported on this system
+		return
+	}
+	rtest.Assert(t, stat.Blocks*512 < size/2,
+		"restored file is not sparse, %d blocks allocated", stat.Blocks)
+}
+
+func TestRestoreOverwriteIfChanged(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	data := make([]byte, 10*1024*1024)
+	_, err := io.ReadFull(rand.Reader, data)
+	rtest.OK(t, err)
+
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	filename := filepath.Join(env.testdata, "file")
+	rtest.OK(t, ioutil.WriteFile(filename, data, 0644))
+
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+
+	// modify the restored file and add another one
+	target := filepath.Join(restoredir, filename)
+	f, err := os.OpenFile(target, os.O_WRONLY, 0644)
+	rtest.OK(t, err)
+	_, err = f.WriteAt([]byte("modified"), int64(len(data)/2))
+	rtest.OK(t, err)
+	_, err = f.WriteAt([]byte("appended data"), int64(len(data)))
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+
+	extra := filepath.Join(restoredir, env.testdata, "extra")
+	rtest.OK(t, ioutil.WriteFile(extra, []byte("extra"), 0644))
+
+	opts := RestoreOptions{
+		Target:    restoredir,
+		Overwrite: restorer.OverwriteIfChanged,
+		Delete:    true,
+	}
+	rtest.OK(t, testRunRestoreAssumeFailure(snapshotIDs[0].String(), opts, env.gopts))
+
+	buf, err := ioutil.ReadFile(target)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(data, buf), "restored file has wrong content")
+
+	_, err = os.Lstat(extra)
+	rtest.Assert(t, os.IsNotExist(err), "extra file was not removed")
+}
+
+func TestRestoreJSON(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "file1"), 1024*1024))
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "file2"), 512*1024))
+
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.JSON = true
+	gopts.stdout = buf
+
+	opts := RestoreOptions{Target: filepath.Join(env.base, "restore")}
+	rtest.OK(t, testRunRestoreAssumeFailure(snapshotIDs[0].String(), opts, gopts))
+
+	var summary struct {
+		MessageType   string `json:"message_type"`
+		FilesRestored uint64 `json:"files_restored"`
+		FilesSkipped  uint64 `json:"files_skipped"`
+		BytesRestored uint64 `json:"bytes_restored"`
+	}
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		rtest.OK(t, json.Unmarshal(sc.Bytes(), &summary))
+	}
+	rtest.OK(t, sc.Err())
+
+	rtest.Equals(t, "summary", summary.MessageType)
+	rtest.Equals(t, uint64(2), summary.FilesRestored)
+	rtest.Equals(t, uint64(0), summary.FilesSkipped)
+	rtest.Equals(t, uint64(1536*1024), summary.BytesRestored)
+}
+
+func TestRestoreDryRun(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "file1"), 1024*1024))
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "file2"), 512*1024))
+
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+	rtest.OK(t, appendRandomData(filepath.Join(restoredir, env.testdata, "file2"), 1024))
+
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.JSON = true
+	gopts.stdout = buf
+
+	opts := RestoreOptions{
+		Target:    restoredir,
+		Overwrite: restorer.OverwriteIfNewer,
+		DryRun:    true,
+	}
+	rtest.OK(t, testRunRestoreAssumeFailure(snapshotIDs[0].String(), opts, gopts))
+
+	var plan struct {
+		MessageType      string `json:"message_type"`
+		DryRun           bool   `json:"dry_run"`
+		FilesToCreate    uint64 `json:"files_to_create"`
+		FilesToOverwrite uint64 `json:"files_to_overwrite"`
+		FilesToSkip      uint64 `json:"files_to_skip"`
+		BytesToSkip      uint64 `json:"bytes_to_skip"`
+	}
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &plan))
+
+	rtest.Equals(t, "summary", plan.MessageType)
+	rtest.Assert(t, plan.DryRun, "plan is not marked as dry run")
+	rtest.Equals(t, uint64(0), plan.FilesToCreate)
+	rtest.Equals(t, uint64(0), plan.FilesToOverwrite)
+	rtest.Equals(t, uint64(2), plan.FilesToSkip)
+	rtest.Equals(t, uint64(1536*1024), plan.BytesToSkip)
+
+	// the modified file must not have been touched
+	rtest.OK(t, testFileSize(filepath.Join(restoredir, env.testdata, "file2"), 512*1024+1024))
+}
+
 func TestBackupTags(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -760,6 +1151,195 @@ func TestInitCopyChunkerParams(t *testing.T) {
 		otherRepo.Config().ChunkerPolynomial)
 }
 
+func TestInitRepositoryVersion(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+
+	for _, test := range []struct {
+		version string
+		want    uint
+	}{
+		{"", 1},
+		{"stable", 1},
+		{"2", 2},
+		{"latest", restic.MaxRepoVersion},
+	} {
+		t.Run(test.version, func(t *testing.T) {
+			env, cleanup := withTestEnvironment(t)
+			defer cleanup()
+
+			rtest.OK(t, runInit(InitOptions{RepositoryVersion: test.version}, env.gopts, nil))
+
+			repo, err := OpenRepository(env.gopts)
+			rtest.OK(t, err)
+			rtest.Equals(t, test.want, repo.Config().Version)
+		})
+	}
+}
+
+func TestInitPackSize(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+
+	rtest.Assert(t, runInit(InitOptions{PackSize: 2}, env.gopts, nil) != nil, "expected invalid pack size to fail")
+	rtest.OK(t, runInit(InitOptions{PackSize: 16}, env.gopts, nil))
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(16*1024*1024), repo.Config().PackSize)
+	rtest.Equals(t, uint(16*1024*1024), repo.PackSize())
+
+	// the pack size can be overridden for a single run
+	env.gopts.extended["repository.pack-size"] = "32"
+	repo, err = OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(16*1024*1024), repo.Config().PackSize)
+	rtest.Equals(t, uint(32*1024*1024), repo.PackSize())
+
+	env.gopts.extended["repository.pack-size"] = "256"
+	_, err = OpenRepository(env.gopts)
+	rtest.Assert(t, err != nil, "expected invalid pack size to fail")
+}
+
+func TestInitChunkerSizes(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+
+	rtest.Assert(t, runInit(InitOptions{ChunkAvgSize: 100}, env.gopts, nil) != nil, "expected invalid average chunk size to fail")
+	rtest.Assert(t, runInit(InitOptions{ChunkAvgSize: 64, ChunkMaxSize: 32}, env.gopts, nil) != nil, "expected invalid maximal chunk size to fail")
+	rtest.OK(t, runInit(InitOptions{ChunkAvgSize: 64}, env.gopts, nil))
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	sizes := restic.ChunkerSizes{Min: 32 * 1024, Avg: 64 * 1024, Max: 512 * 1024}
+	rtest.Equals(t, sizes, repo.Config().ChunkerSizes())
+
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	// the sizes are copied together with the polynomial
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	initOpts := InitOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     env.gopts.Repo,
+			password: env.gopts.password,
+		},
+		CopyChunkerParameters: true,
+	}
+	rtest.OK(t, runInit(initOpts, env2.gopts, nil))
+
+	otherRepo, err := OpenRepository(env2.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, sizes, otherRepo.Config().ChunkerSizes())
+}
+
+func TestCheckRepairParity(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+
+	rtest.Assert(t, runInit(InitOptions{Parity: 101}, env.gopts, nil) != nil, "expected invalid parity to fail")
+	rtest.OK(t, runInit(InitOptions{Parity: 10}, env.gopts, nil))
+
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	packs := testRunList(t, "packs", env.gopts)
+	rtest.Assert(t, len(packs) >= 2, "expected at least two packs, got %d", len(packs))
+
+	// damage the first pack and remove the parity file of the second one
+	name := packs[0].String()
+	filename := filepath.Join(env.repo, "data", name[:2], name)
+	buf, err := ioutil.ReadFile(filename)
+	rtest.OK(t, err)
+	buf[len(buf)/2] ^= 0xff
+	rtest.OK(t, os.Chmod(filename, 0644))
+	rtest.OK(t, ioutil.WriteFile(filename, buf, 0644))
+
+	name = packs[1].String()
+	rtest.OK(t, os.Remove(filepath.Join(env.repo, "parity", name[:2], name)))
+
+	output, err := testRunCheckOutput(env.gopts)
+	rtest.OK(t, err)
+	rtest.Assert(t, strings.Contains(output, "2 packs or parity files were damaged and have been repaired"),
+		"repairs not reported, output:\n%v", output)
+
+	output, err = testRunCheckOutput(env.gopts)
+	rtest.OK(t, err)
+	rtest.Assert(t, !strings.Contains(output, "repaired"), "unexpected repairs, output:\n%v", output)
+
+	// prune removes the parity files of deleted packs
+	testRunForget(t, env.gopts, testRunList(t, "snapshots", env.gopts)[0].String())
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "5%"})
+
+	files, err := filepath.Glob(filepath.Join(env.repo, "parity", "*", "*"))
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(files))
+}
+
+func TestRepairSnapshots(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	_, snapshots := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 1, len(snapshots))
+
+	// remove a pack which only contains data blobs
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(env.gopts.ctx))
+
+	treePacks := restic.NewIDSet()
+	dataPacks := restic.NewIDSet()
+	for pb := range repo.Index().Each(env.gopts.ctx) {
+		if pb.Type == restic.TreeBlob {
+			treePacks.Insert(pb.PackID)
+		} else {
+			dataPacks.Insert(pb.PackID)
+		}
+	}
+	dataPacks = dataPacks.Sub(treePacks)
+	rtest.Assert(t, len(dataPacks) > 0, "no pack with only data blobs found")
+
+	name := dataPacks.List()[0].String()
+	rtest.OK(t, os.Remove(filepath.Join(env.repo, "data", name[:2], name)))
+	testRunRebuildIndex(t, env.gopts)
+
+	rtest.OK(t, runRepairSnapshots(RepairSnapshotsOptions{DryRun: true}, env.gopts, nil))
+	_, snapshotsAfter := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, snapshots, snapshotsAfter)
+
+	rtest.OK(t, runRepairSnapshots(RepairSnapshotsOptions{Forget: true}, env.gopts, nil))
+	_, snapshotsAfter = testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 1, len(snapshotsAfter))
+	for id, sn := range snapshotsAfter {
+		_, ok := snapshots[id]
+		rtest.Assert(t, !ok, "damaged snapshot %v was not replaced", id.Str())
+		rtest.Assert(t, sn.Original != nil && snapshots[*sn.Original].ID != nil,
+			"repaired snapshot does not refer to the damaged one")
+	}
+
+	rtest.OK(t, runCheck(CheckOptions{}, env.gopts, nil))
+
+	// repairing the snapshot again does not change anything
+	rtest.OK(t, runRepairSnapshots(RepairSnapshotsOptions{}, env.gopts, nil))
+	_, snapshots = testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, snapshotsAfter, snapshots)
+}
+
 func testRunTag(t testing.TB, opts TagOptions, gopts GlobalOptions) {
 	rtest.OK(t, runTag(opts, gopts, []string{}))
 }
@@ -1186,6 +1766,125 @@ func TestFind(t *testing.T) {
 	rtest.Assert(t, len(lines) == 4, "expected three files found in repo (%v)", datafile)
 }
 
+func TestExprFilter(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	testfiles := []struct {
+		name string
+		size uint
+		old  bool
+	}{
+		{"small.c", 100, false},
+		{"large.exe", 5000, false},
+		{"subdir/old.txt", 200, true},
+		{"subdir/tmp.log", 300, false},
+	}
+
+	for _, testFile := range testfiles {
+		p := filepath.Join(env.testdata, testFile.name)
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, appendRandomData(p, testFile.size))
+		if testFile.old {
+			mtime := time.Now().AddDate(-3, 0, 0)
+			rtest.OK(t, os.Chtimes(p, mtime, mtime))
+		}
+	}
+
+	opts := BackupOptions{ExcludeExprs: []string{`name =~ "\.log$"`}}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+
+	for i, test := range []struct {
+		opts     RestoreOptions
+		restored []string
+	}{
+		{RestoreOptions{}, []string{"small.c", "large.exe", "subdir/old.txt"}},
+		{RestoreOptions{IncludeExprs: []string{"type == file and size > 1k"}}, []string{"large.exe"}},
+		{RestoreOptions{IncludeExprs: []string{"age > 2y", "name == *.c"}}, []string{"small.c", "subdir/old.txt"}},
+		{RestoreOptions{ExcludeExprs: []string{"type == file and age < 1d"}}, []string{"subdir/old.txt"}},
+		{RestoreOptions{ExcludeExprs: []string{"path == */subdir"}}, []string{"small.c", "large.exe"}},
+	} {
+		target := filepath.Join(env.base, fmt.Sprintf("restore%d", i))
+		test.opts.Target = target
+		rtest.OK(t, testRunRestoreAssumeFailure(snapshotID.String(), test.opts, env.gopts))
+
+		for _, testFile := range testfiles {
+			restored := false
+			for _, name := range test.restored {
+				restored = restored || name == testFile.name
+			}
+
+			err := testFileSize(filepath.Join(target, "testdata", testFile.name), int64(testFile.size))
+			if restored {
+				rtest.OK(t, err)
+			} else {
+				rtest.Assert(t, os.IsNotExist(errors.Cause(err)),
+					"expected %v to not exist in restore step %v, but it exists, err %v", testFile.name, i, err)
+			}
+		}
+	}
+
+	rtest.Assert(t, testRunRestoreAssumeFailure(snapshotID.String(), RestoreOptions{Target: env.base, IncludeExprs: []string{"size >"}}, env.gopts) != nil,
+		"expected invalid expression to fail")
+
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	rtest.OK(t, runFind(FindOptions{Expr: "type == file and age > 1y"}, env.gopts, nil))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	rtest.Assert(t, len(lines) == 1 && lines[0] == "/testdata/subdir/old.txt",
+		"unexpected find output %q", buf.String())
+}
+
+func TestExprFilterDir(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	dir := filepath.Join(env.testdata, "home", "user")
+	rtest.OK(t, os.MkdirAll(dir, 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(dir, "recent.txt"), 100))
+	rtest.OK(t, appendRandomData(filepath.Join(dir, "old.txt"), 200))
+	mtime := time.Now().AddDate(-3, 0, 0)
+	rtest.OK(t, os.Chtimes(filepath.Join(dir, "old.txt"), mtime, mtime))
+	rtest.OK(t, os.Chtimes(dir, mtime, mtime))
+
+	for i, test := range []struct {
+		expr  string
+		files map[string]bool
+	}{
+		// a recent file in an old directory is not excluded with the directory
+		{"age > 2y", map[string]bool{"recent.txt": true, "old.txt": false}},
+		// directories are only matched by expressions which test the type
+		{"type == dir and name == user", map[string]bool{"recent.txt": false, "old.txt": false}},
+	} {
+		opts := BackupOptions{ExcludeExprs: []string{test.expr}}
+		testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, opts, env.gopts)
+		snapshotIDs := testRunList(t, "snapshots", env.gopts)
+		rtest.Assert(t, len(snapshotIDs) == i+1, "expected %d snapshots, got %v", i+1, snapshotIDs)
+
+		target := filepath.Join(env.base, fmt.Sprintf("restore%d", i))
+		testRunRestoreLatest(t, env.gopts, target, nil, nil)
+
+		for name, exists := range test.files {
+			_, err := os.Lstat(filepath.Join(target, "testdata", "home", "user", name))
+			if exists {
+				rtest.OK(t, err)
+			} else {
+				rtest.Assert(t, os.IsNotExist(err), "expected %v to be excluded with %q, err %v", name, test.expr, err)
+			}
+		}
+	}
+}
+
 type testMatch struct {
 	Path        string    `json:"path,omitempty"`
 	Permissions string    `json:"permissions,omitempty"`
@@ -1325,6 +2024,38 @@ func TestCheckRestoreNoLock(t *testing.T) {
 }
 
 func TestPrune(t *testing.T) {
+	t.Run("0", func(t *testing.T) {
+		opts := PruneOptions{MaxUnused: "0%"}
+		checkOpts := CheckOptions{ReadData: true, CheckUnused: true}
+		testPrune(t, opts, checkOpts)
+	})
+
+	t.Run("50", func(t *testing.T) {
+		opts := PruneOptions{MaxUnused: "50%"}
+		checkOpts := CheckOptions{ReadData: true}
+		testPrune(t, opts, checkOpts)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		opts := PruneOptions{MaxUnused: "unlimited"}
+		checkOpts := CheckOptions{ReadData: true}
+		testPrune(t, opts, checkOpts)
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		opts := PruneOptions{MaxUnused: "1k"}
+		checkOpts := CheckOptions{ReadData: true}
+		testPrune(t, opts, checkOpts)
+	})
+
+	t.Run("repack-size", func(t *testing.T) {
+		opts := PruneOptions{MaxUnused: "0%", MaxRepackSize: "1k"}
+		checkOpts := CheckOptions{ReadData: true}
+		testPrune(t, opts, checkOpts)
+	})
+}
+
+func testPrune(t *testing.T, pruneOpts PruneOptions, checkOpts CheckOptions) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
@@ -1345,10 +2076,283 @@ func TestPrune(t *testing.T) {
 
 	testRunForgetJSON(t, env.gopts)
 	testRunForget(t, env.gopts, firstSnapshot[0].String())
-	testRunPrune(t, env.gopts)
+	testRunPrune(t, env.gopts, pruneOpts)
+	rtest.OK(t, runCheck(checkOpts, env.gopts, nil))
+}
+
+func TestPruneDryRun(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+
+	oldPacks := listPacks(env.gopts, t)
+
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.JSON = true
+	gopts.stdout = buf
+	rtest.OK(t, runPrune(PruneOptions{MaxUnused: "0%", DryRun: true}, gopts))
+
+	var plan prunePlanJSON
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &plan))
+	rtest.Assert(t, plan.DryRun, "plan is not marked as dry run")
+	rtest.Assert(t, plan.PacksToDelete+plan.PacksToRepack > 0,
+		"expected packs to be deleted or repacked, got plan %+v", plan)
+	rtest.Assert(t, plan.BytesFreed > 0, "expected bytes to be freed, got plan %+v", plan)
+	rtest.Equals(t, 0, len(plan.MissingBlobs))
+	rtest.Equals(t, 0, len(plan.MissingPacks))
+
+	// nothing must have been changed
+	rtest.Equals(t, oldPacks, listPacks(env.gopts, t))
+
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%"})
+	newPacks := listPacks(env.gopts, t)
+	removed := 0
+	for id := range oldPacks {
+		if !newPacks.Has(id) {
+			removed++
+		}
+	}
+	rtest.Equals(t, plan.PacksToDelete+plan.PacksToRepack, removed)
 	testRunCheck(t, env.gopts)
 }
 
+func TestPruneRepackSmall(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	// each backup creates a data pack below the target pack size
+	for i := 0; i < 3; i++ {
+		dir := filepath.Join(env.testdata, fmt.Sprintf("%d", i))
+		rtest.OK(t, os.MkdirAll(dir, 0755))
+		rtest.OK(t, appendRandomData(filepath.Join(dir, "file"), 2*1024*1024))
+		testRunBackup(t, "", []string{dir}, BackupOptions{}, env.gopts)
+	}
+
+	packsBefore := testRunList(t, "packs", env.gopts)
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "5%", RepackSmall: true})
+	packsAfter := testRunList(t, "packs", env.gopts)
+	rtest.Assert(t, len(packsAfter) < len(packsBefore),
+		"expected small packs to be consolidated, got %d packs before and %d after", len(packsBefore), len(packsAfter))
+	testRunCheck(t, env.gopts)
+
+	// the remaining small packs do not fill a pack of the target size
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "5%", RepackSmall: true})
+	rtest.Equals(t, restic.NewIDSet(packsAfter...), restic.NewIDSet(testRunList(t, "packs", env.gopts)...))
+}
+
+func TestPruneResume(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+
+	defer func(n int) {
+		pruneStateBatchSize = n
+	}(pruneStateBatchSize)
+	pruneStateBatchSize = 1
+
+	// simulate a prune run which is interrupted right after repacking
+	pruneOpts := PruneOptions{MaxUnused: "0%"}
+	rtest.OK(t, verifyPruneOptions(&pruneOpts))
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	repo.DisableAutoIndexUpdate()
+	rtest.OK(t, repo.LoadIndex(env.gopts.ctx))
+
+	snapshots, err := restic.LoadAllSnapshots(env.gopts.ctx, repo)
+	rtest.OK(t, err)
+	usedBlobs, err := getUsedBlobs(env.gopts, repo, snapshots)
+	rtest.OK(t, err)
+	plan, err := planPrune(env.gopts, pruneOpts, repo, usedBlobs, restic.NewIDSet(), restic.NewIDSet())
+	rtest.OK(t, err)
+	rtest.Assert(t, len(plan.repackPacks) > 0, "expected packs to repack, got none")
+
+	plan.stateIDs = restic.NewIDSet()
+	rtest.OK(t, repackInBatches(env.gopts, repo, plan))
+	rtest.Equals(t, 1, len(plan.stateIDs))
+
+	// the next run only has to remove the repacked packs
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.JSON = true
+	gopts.stdout = buf
+	rtest.OK(t, runPrune(PruneOptions{MaxUnused: "0%", DryRun: true}, gopts))
+
+	var resumed prunePlanJSON
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &resumed))
+	rtest.Equals(t, 0, resumed.PacksToRepack)
+	rtest.Equals(t, len(plan.repackPacks), resumed.RepackedPacksToDelete)
+
+	testRunPrune(t, env.gopts, pruneOpts)
+
+	packs := listPacks(env.gopts, t)
+	for id := range plan.repackPacks {
+		rtest.Assert(t, !packs.Has(id), "repacked pack %v was not removed", id.Str())
+	}
+
+	var states restic.IDs
+	rtest.OK(t, repo.List(env.gopts.ctx, restic.PruneStateFile, func(id restic.ID, size int64) error {
+		states = append(states, id)
+		return nil
+	}))
+	rtest.Equals(t, 0, len(states))
+
+	rtest.OK(t, runCheck(CheckOptions{ReadData: true, CheckUnused: true}, env.gopts, nil))
+}
+
+func TestPruneSharedLock(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	sn, err := restic.LoadSnapshot(env.gopts.ctx, repo, firstSnapshot[0])
+	rtest.OK(t, err)
+
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+
+	// simulate a backup running on another host
+	otherLock := &restic.Lock{Time: time.Now(), Hostname: "other-host", PID: 4711}
+	lockID, err := repo.SaveJSONUnpacked(env.gopts.ctx, restic.LockFile, otherLock)
+	rtest.OK(t, err)
+
+	pruneOpts := PruneOptions{MaxUnused: "0%", SharedLock: true}
+
+	// only one prune may run at a time
+	otherPrune := &restic.Lock{Time: time.Now(), Hostname: "other-host", PID: 4712, Prune: true}
+	pruneLockID, err := repo.SaveJSONUnpacked(env.gopts.ctx, restic.LockFile, otherPrune)
+	rtest.OK(t, err)
+	err = runPrune(pruneOpts, env.gopts)
+	rtest.Assert(t, restic.IsAlreadyLocked(err), "prune did not refuse to run concurrently, got %v", err)
+	rtest.OK(t, repo.Backend().Remove(env.gopts.ctx, restic.Handle{Type: restic.LockFile, Name: pruneLockID.String()}))
+
+	oldPacks := listPacks(env.gopts, t)
+	testRunPrune(t, env.gopts, pruneOpts)
+
+	// packs are only marked for deletion as long as the other process runs
+	packs := listPacks(env.gopts, t)
+	for id := range oldPacks {
+		rtest.Assert(t, packs.Has(id), "pack %v was removed while the other process still runs", id.Str())
+	}
+	testRunPrune(t, env.gopts, pruneOpts)
+	rtest.Equals(t, packs, listPacks(env.gopts, t))
+
+	// the backup finishes with a snapshot reusing the removed data
+	_, err = repo.SaveJSONUnpacked(env.gopts.ctx, restic.SnapshotFile, sn)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Backend().Remove(env.gopts.ctx, restic.Handle{Type: restic.LockFile, Name: lockID.String()}))
+
+	testRunPrune(t, env.gopts, pruneOpts)
+	rtest.OK(t, runCheck(CheckOptions{ReadData: true}, env.gopts, nil))
+
+	// an exclusive prune run carries out all pending deletions
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%"})
+	rtest.OK(t, runCheck(CheckOptions{ReadData: true, CheckUnused: true}, env.gopts, nil))
+
+	var pending restic.IDs
+	rtest.OK(t, repo.List(env.gopts.ctx, restic.PendingFile, func(id restic.ID, size int64) error {
+		pending = append(pending, id)
+		return nil
+	}))
+	rtest.Equals(t, 0, len(pending))
+}
+
+// unknownTypesBackend fails to list some file types, like a REST server which
+// does not know them.
+type unknownTypesBackend struct {
+	restic.Backend
+	types []restic.FileType
+}
+
+func (be *unknownTypesBackend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	for _, typ := range be.types {
+		if t == typ {
+			return errors.Errorf("List failed, server response: 404 Not Found (404)")
+		}
+	}
+	return be.Backend.List(ctx, t, fn)
+}
+
+func TestPruneUnknownFileTypes(t *testing.T) {
+	for _, parity := range []uint{0, 10} {
+		t.Run(fmt.Sprintf("parity-%d", parity), func(t *testing.T) {
+			env, cleanup := withTestEnvironment(t)
+			defer cleanup()
+
+			rtest.OK(t, runInit(InitOptions{Parity: parity}, env.gopts, nil))
+			rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+			opts := BackupOptions{}
+
+			testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+			firstSnapshot := testRunList(t, "snapshots", env.gopts)
+			testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+			testRunForget(t, env.gopts, firstSnapshot[0].String())
+
+			// prune has finished its work when the parity files are listed,
+			// failing to list them only results in a warning
+			gopts := env.gopts
+			gopts.backendTestHook = func(r restic.Backend) (restic.Backend, error) {
+				return &unknownTypesBackend{Backend: r, types: []restic.FileType{restic.PendingFile, restic.ParityFile}}, nil
+			}
+			testRunPrune(t, gopts, PruneOptions{MaxUnused: "0%"})
+			rtest.OK(t, runCheck(CheckOptions{ReadData: true, CheckUnused: true}, env.gopts, nil))
+		})
+	}
+}
+
+func TestPruneOptions(t *testing.T) {
+	for _, test := range []struct {
+		maxUnused string
+		used      uint64
+		unused    uint64
+	}{
+		{"0%", 1000, 0},
+		{"50%", 1000, 1000},
+		{"20%", 1000, 250},
+		{"unlimited", 1000, math.MaxUint64},
+		{"10k", 1000, 10240},
+		{"100", 1000, 100},
+	} {
+		opts := PruneOptions{MaxUnused: test.maxUnused}
+		rtest.OK(t, verifyPruneOptions(&opts))
+		rtest.Equals(t, test.unused, opts.maxUnusedBytes(test.used))
+	}
+
+	for _, maxUnused := range []string{"", "100%", "-5%", "foo", "x%", "-1"} {
+		opts := PruneOptions{MaxUnused: maxUnused}
+		rtest.Assert(t, verifyPruneOptions(&opts) != nil,
+			"expected error for --max-unused=%q", maxUnused)
+	}
+
+	opts := PruneOptions{MaxUnused: "5%", MaxRepackSize: "2m"}
+	rtest.OK(t, verifyPruneOptions(&opts))
+	rtest.Equals(t, uint64(2*1024*1024), opts.MaxRepackBytes)
+}
+
 func listPacks(gopts GlobalOptions, t *testing.T) restic.IDSet {
 	r, err := OpenRepository(gopts)
 	rtest.OK(t, err)
@@ -1390,8 +2394,19 @@ func TestPruneWithDamagedRepository(t *testing.T) {
 	rtest.Assert(t, len(snapshotIDs) == 1,
 		"expected one snapshot, got %v", snapshotIDs)
 
+	// a dry run should report the missing packs
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.JSON = true
+	gopts.stdout = buf
+	rtest.Assert(t, runPrune(PruneOptions{MaxUnused: "5%", DryRun: true}, gopts) != nil,
+		"expected prune dry run to fail")
+	var plan prunePlanJSON
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &plan))
+	rtest.Assert(t, len(plan.MissingPacks) > 0, "expected missing packs in plan %+v", plan)
+
 	// prune should fail
-	err := runPrune(env.gopts)
+	err := runPrune(PruneOptions{MaxUnused: "5%"}, env.gopts)
 	if err == nil {
 		t.Fatalf("expected prune to fail")
 	}
@@ -1412,9 +2427,9 @@ func TestEdgeCaseRepos(t *testing.T) {
 	})
 
 	// repo where an existing and used blob is missing from the index
-	// => check should fail, prune should heal this
+	// => check and prune should fail, prune only works from the index
 	t.Run("index-missing-blob", func(t *testing.T) {
-		testEdgeCaseRepo(t, "repo-index-missing-blob.tar.gz", opts, false, true)
+		testEdgeCaseRepo(t, "repo-index-missing-blob.tar.gz", opts, false, false)
 	})
 
 	// repo where a blob is missing
@@ -1467,11 +2482,12 @@ func testEdgeCaseRepo(t *testing.T, tarfile string, options CheckOptions, checkO
 			"check should have reported an error")
 	}
 
+	pruneOpts := PruneOptions{MaxUnused: "5%"}
 	if pruneOK {
-		testRunPrune(t, env.gopts)
+		testRunPrune(t, env.gopts, pruneOpts)
 		testRunCheck(t, env.gopts)
 	} else {
-		rtest.Assert(t, runPrune(env.gopts) != nil,
+		rtest.Assert(t, runPrune(pruneOpts, env.gopts) != nil,
 			"prune should have reported an error")
 	}
 }
@@ -19,24 +19,33 @@ var globalLocks struct {
 }
 
 func lockRepo(repo *repository.Repository) (*restic.Lock, error) {
-	return lockRepository(repo, false)
+	return lockRepository(repo, restic.NewLock)
 }
 
 func lockRepoExclusive(repo *repository.Repository) (*restic.Lock, error) {
-	return lockRepository(repo, true)
+	return lockRepository(repo, restic.NewExclusiveLock)
 }
 
-func lockRepository(repo *repository.Repository, exclusive bool) (*restic.Lock, error) {
-	lockFn := restic.NewLock
-	if exclusive {
-		lockFn = restic.NewExclusiveLock
-	}
+// lockRepoPrune takes a non-exclusive lock which conflicts with other prune
+// runs.
+func lockRepoPrune(repo *repository.Repository) (*restic.Lock, error) {
+	return lockRepository(repo, restic.NewPruneLock)
+}
 
+func lockRepository(repo *repository.Repository, lockFn func(context.Context, restic.Repository) (*restic.Lock, error)) (*restic.Lock, error) {
 	lock, err := lockFn(context.TODO(), repo)
 	if err != nil {
 		return nil, errors.WithMessage(err, "unable to create lock in backend")
 	}
-	debug.Log("create lock %p (exclusive %v)", lock, exclusive)
+	debug.Log("create lock %p (exclusive %v, prune %v)", lock, lock.Exclusive, lock.Prune)
+
+	if lock.Exclusive {
+		// a migration may have been interrupted after it removed the config
+		if err := repo.RestoreConfig(context.TODO()); err != nil {
+			_ = lock.Unlock()
+			return nil, errors.Fatalf("config cannot be restored: %v", err)
+		}
+	}
 
 	globalLocks.Lock()
 	if globalLocks.cancelRefresh == nil {
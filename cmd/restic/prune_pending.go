@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// pendingDeletion lists packs which have been removed from the index by a
+// prune run holding only a shared lock. Processes which were running at that
+// time may still use blobs from these packs, so they are only deleted by a
+// later prune run once all of these processes have finished.
+type pendingDeletion struct {
+	Time time.Time `json:"time"`
+
+	// Packs is the list of packs to delete.
+	Packs restic.IDs `json:"packs"`
+
+	// Snapshots lists the snapshots which existed when the packs were
+	// selected, all blobs they use are stored in other packs.
+	Snapshots restic.IDs `json:"snapshots"`
+
+	// Locks lists the processes which held a lock on the repository after
+	// the packs were removed from the index.
+	Locks []pendingLock `json:"locks"`
+}
+
+// pendingLock identifies the process which created a lock. The timestamp of a
+// lock cannot be used, as it is updated whenever the lock is refreshed.
+type pendingLock struct {
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+	PID      int    `json:"pid"`
+}
+
+// findActiveLocks returns the processes holding a lock on the repository,
+// except for the current process. Stale locks are ignored.
+func findActiveLocks(ctx context.Context, repo restic.Repository) ([]pendingLock, error) {
+	hostname, _ := os.Hostname()
+	pid := os.Getpid()
+
+	var locks []pendingLock
+	err := repo.List(ctx, restic.LockFile, func(id restic.ID, size int64) error {
+		lock, err := restic.LoadLock(ctx, repo, id)
+		if err != nil {
+			// ignore locks that cannot be loaded
+			debug.Log("ignore lock %v: %v", id, err)
+			return nil
+		}
+
+		if lock.Hostname == hostname && lock.PID == pid {
+			return nil
+		}
+
+		if lock.Stale() {
+			return nil
+		}
+
+		locks = append(locks, pendingLock{
+			Hostname: lock.Hostname,
+			Username: lock.Username,
+			PID:      lock.PID,
+		})
+		return nil
+	})
+
+	return locks, err
+}
+
+// markPending records that packs are to be deleted once all processes which
+// currently hold a lock on the repository have finished. The packs must
+// already have been removed from the index.
+func markPending(gopts GlobalOptions, repo restic.Repository, packs restic.IDSet, snapshots restic.IDs) error {
+	ctx := gopts.ctx
+
+	locks, err := findActiveLocks(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	pd := pendingDeletion{
+		Time:      time.Now(),
+		Packs:     packs.List(),
+		Snapshots: snapshots,
+		Locks:     locks,
+	}
+
+	id, err := repo.SaveJSONUnpacked(ctx, restic.PendingFile, pd)
+	if err != nil {
+		return err
+	}
+
+	debug.Log("marked %d packs for deletion in %v, waiting for %d locks", len(packs), id.Str(), len(locks))
+	return nil
+}
+
+// sweepPending deletes the packs of all pending deletions for which every
+// process listed in the pending deletion has released its lock. Packs which
+// are referenced by the index are never deleted.
+//
+// Backups which were already running while packs were marked may have
+// created snapshots which use blobs only contained in these packs. Such packs
+// are added to the index again instead of being deleted.
+//
+// Returned is the set of packs which are still pending deletion. If the
+// pending deletions cannot be listed, none are assumed to exist. In dry-run
+// mode, the repository is not modified.
+func sweepPending(gopts GlobalOptions, repo restic.Repository, dryRun bool) (pending restic.IDSet, err error) {
+	ctx := gopts.ctx
+	pending = restic.NewIDSet()
+
+	marks := make(map[restic.ID]pendingDeletion)
+	var loadErr error
+	err = repo.List(ctx, restic.PendingFile, func(id restic.ID, size int64) error {
+		var pd pendingDeletion
+		err := repo.LoadJSONUnpacked(ctx, restic.PendingFile, id, &pd)
+		if err != nil {
+			loadErr = errors.Fatalf("unable to load pending deletion %v: %v", id.Str(), err)
+			return loadErr
+		}
+		marks[id] = pd
+		return nil
+	})
+	if loadErr != nil {
+		return nil, loadErr
+	}
+	if err != nil {
+		// backends which do not know pending deletions, like older versions
+		// of the REST server, cannot have stored any
+		debug.Log("unable to list pending deletions, assuming there are none: %v", err)
+		return pending, nil
+	}
+
+	if len(marks) == 0 {
+		return pending, nil
+	}
+
+	locks, err := findActiveLocks(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[pendingLock]struct{}, len(locks))
+	for _, lock := range locks {
+		active[lock] = struct{}{}
+	}
+
+	// a pending deletion can be carried out once none of the processes
+	// listed in it hold a lock any more
+	done := restic.NewIDSet()
+	for id, pd := range marks {
+		waiting := false
+		for _, lock := range pd.Locks {
+			if _, ok := active[lock]; ok {
+				waiting = true
+				break
+			}
+		}
+
+		if waiting {
+			for _, packID := range pd.Packs {
+				pending.Insert(packID)
+			}
+			continue
+		}
+		done.Insert(id)
+	}
+
+	if !gopts.JSON {
+		Verbosef("found %d pending deletions, %d can be carried out\n", len(marks), len(done))
+	}
+
+	// packs which are referenced by the index again are not deleted, this
+	// happens e.g. when the index was rebuilt in the meantime
+	indexed := restic.NewIDSet()
+	for blob := range repo.Index().Each(ctx) {
+		indexed.Insert(blob.PackID)
+	}
+
+	packSize := make(map[restic.ID]int64)
+	err = repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		packSize[id] = size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := restic.NewIDSet()
+	for _, pd := range marks {
+		for _, packID := range pd.Packs {
+			if _, ok := packSize[packID]; ok && !indexed.Has(packID) {
+				candidates.Insert(packID)
+			}
+		}
+	}
+
+	rescued, err := rescuePending(gopts, repo, marks, candidates, packSize, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	donePacks := restic.NewIDSet()
+	for id := range done {
+		for _, packID := range marks[id].Packs {
+			donePacks.Insert(packID)
+		}
+	}
+
+	// packs also listed in a pending deletion which cannot be carried out
+	// yet are kept for now
+	deletePacks := candidates.Intersect(donePacks).Sub(rescued).Sub(pending)
+	pending = pending.Sub(rescued)
+
+	if dryRun {
+		if !gopts.JSON {
+			Verbosef("would delete %d pending packs\n", len(deletePacks))
+		}
+		return pending, nil
+	}
+
+	if len(deletePacks) != 0 {
+		if !gopts.JSON {
+			Verbosef("deleting %d pending packs\n", len(deletePacks))
+		}
+		err = DeleteFilesChecked(gopts, repo, deletePacks, restic.PackFile)
+		if err != nil {
+			return nil, errors.Fatalf("unable to remove a pending pack: %v", err)
+		}
+	}
+
+	if len(done) != 0 {
+		DeleteFiles(gopts, repo, done, restic.PendingFile)
+	}
+
+	return pending, nil
+}
+
+// rescuePending looks for snapshots which were created after packs were
+// marked for deletion and use blobs only contained in these packs. Such packs
+// are added to the index again, they are returned in rescued.
+func rescuePending(gopts GlobalOptions, repo restic.Repository, marks map[restic.ID]pendingDeletion,
+	candidates restic.IDSet, packSize map[restic.ID]int64, dryRun bool) (rescued restic.IDSet, err error) {
+
+	ctx := gopts.ctx
+	rescued = restic.NewIDSet()
+
+	if len(candidates) == 0 {
+		return rescued, nil
+	}
+
+	var knownSnapshots []restic.IDSet
+	for _, pd := range marks {
+		knownSnapshots = append(knownSnapshots, restic.NewIDSet(pd.Snapshots...))
+	}
+
+	// snapshots which were unknown to at least one of the prune runs
+	var newSnapshots restic.IDs
+	err = repo.List(ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
+		for _, known := range knownSnapshots {
+			if !known.Has(id) {
+				newSnapshots = append(newSnapshots, id)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(newSnapshots) == 0 {
+		return rescued, nil
+	}
+
+	if !gopts.JSON {
+		Verbosef("checking %d new snapshots for data in pending packs\n", len(newSnapshots))
+	}
+
+	mi, ok := repo.Index().(*repository.MasterIndex)
+	if !ok {
+		return nil, errors.New("unsupported index type")
+	}
+
+	pendingIdx := repository.NewIndex()
+	packBlobs := make(map[restic.ID][]restic.Blob, len(candidates))
+	for id := range candidates {
+		blobs, _, err := repo.ListPack(ctx, id, packSize[id])
+		if err != nil {
+			return nil, errors.Fatalf("unable to read pending pack %v: %v", id.Str(), err)
+		}
+		pendingIdx.StorePack(id, blobs)
+		packBlobs[id] = blobs
+	}
+	pendingIdx.Finalize()
+
+	// make the pending packs available while walking the trees of the new
+	// snapshots, the original index is restored afterwards
+	tmp := repository.NewMasterIndex()
+	for _, idx := range mi.All() {
+		tmp.Insert(idx)
+	}
+	tmp.Insert(pendingIdx)
+
+	err = repo.SetIndex(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	usedBlobs := restic.NewBlobSet()
+	for _, id := range newSnapshots {
+		var sn *restic.Snapshot
+		sn, err = restic.LoadSnapshot(ctx, repo, id)
+		if err != nil {
+			break
+		}
+
+		err = restic.FindUsedBlobs(ctx, repo, *sn.Tree, usedBlobs)
+		if err != nil {
+			break
+		}
+	}
+
+	if serr := repo.SetIndex(mi); serr != nil && err == nil {
+		err = serr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for h := range usedBlobs {
+		if mi.Has(h.ID, h.Type) {
+			continue
+		}
+		for _, pb := range pendingIdx.Lookup(h.ID, h.Type, nil) {
+			rescued.Insert(pb.PackID)
+		}
+	}
+
+	if len(rescued) == 0 {
+		return rescued, nil
+	}
+
+	if !gopts.JSON {
+		Verbosef("adding %d pending packs to the index again, they are used by new snapshots\n", len(rescued))
+	}
+
+	idx := repository.NewIndex()
+	for id := range rescued {
+		idx.StorePack(id, packBlobs[id])
+	}
+	idx.Finalize()
+
+	if !dryRun {
+		id, err := repository.SaveIndex(ctx, repo, idx)
+		if err != nil {
+			return nil, err
+		}
+		err = idx.SetID(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	mi.Insert(idx)
+
+	return rescued, nil
+}
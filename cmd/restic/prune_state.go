@@ -0,0 +1,78 @@
+package main
+
+import (
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// pruneStateBatchSize is the number of packs which are repacked before the
+// progress of prune is recorded in the repository.
+var pruneStateBatchSize = 100
+
+// pruneState records the progress of a prune run in the repository. The packs
+// listed in RepackedPacks have been repacked and the index containing the new
+// packs has been saved, so only the old packs are left to be removed.
+//
+// A prune run which was interrupted can use this information to skip
+// repacking these packs again. The state is only a hint, the packs are
+// checked against the index before they are removed.
+type pruneState struct {
+	Time          time.Time  `json:"time"`
+	RepackedPacks restic.IDs `json:"repacked_packs"`
+}
+
+// loadPruneStates loads all prune states stored in the repository. It returns
+// the IDs of the state files and the union of all packs which were already
+// repacked. Errors are only printed as warnings, as a missing state merely
+// means that prune has more work to do.
+func loadPruneStates(gopts GlobalOptions, repo restic.Repository) (stateIDs restic.IDSet, repacked restic.IDSet) {
+	ctx := gopts.ctx
+	stateIDs = restic.NewIDSet()
+	repacked = restic.NewIDSet()
+
+	err := repo.List(ctx, restic.PruneStateFile, func(id restic.ID, size int64) error {
+		stateIDs.Insert(id)
+
+		var state pruneState
+		err := repo.LoadJSONUnpacked(ctx, restic.PruneStateFile, id, &state)
+		if err != nil {
+			if !gopts.JSON {
+				Warnf("unable to load prune state %v: %v\n", id.Str(), err)
+			}
+			return nil
+		}
+
+		debug.Log("prune state %v from %v lists %d repacked packs", id.Str(), state.Time, len(state.RepackedPacks))
+		for _, packID := range state.RepackedPacks {
+			repacked.Insert(packID)
+		}
+		return nil
+	})
+	if err != nil && !gopts.JSON {
+		Warnf("unable to list prune states: %v\n", err)
+	}
+
+	return stateIDs, repacked
+}
+
+// savePruneState stores a new prune state listing the packs in repacked. If
+// the state cannot be saved, a warning is printed and the null ID is returned.
+func savePruneState(gopts GlobalOptions, repo restic.Repository, repacked restic.IDSet) restic.ID {
+	state := pruneState{
+		Time:          time.Now(),
+		RepackedPacks: repacked.List(),
+	}
+
+	id, err := repo.SaveJSONUnpacked(gopts.ctx, restic.PruneStateFile, state)
+	if err != nil {
+		if !gopts.JSON {
+			Warnf("unable to save prune state: %v\n", err)
+		}
+		return restic.ID{}
+	}
+
+	debug.Log("saved prune state %v with %d repacked packs", id.Str(), len(repacked))
+	return id
+}
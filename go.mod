@@ -16,7 +16,9 @@ require (
 	github.com/hashicorp/golang-lru v0.5.4
 	github.com/json-iterator/go v1.1.10 // indirect
 	github.com/juju/ratelimit v1.0.1
+	github.com/klauspost/compress v1.11.4
 	github.com/klauspost/cpuid v1.3.1 // indirect
+	github.com/klauspost/reedsolomon v1.9.11
 	github.com/kr/text v0.2.0 // indirect
 	github.com/kurin/blazer v0.5.3
 	github.com/minio/minio-go/v6 v6.0.57
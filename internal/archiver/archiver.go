@@ -53,13 +53,23 @@ type Archiver struct {
 	FS           fs.FS
 	Options      Options
 
-	blobSaver *BlobSaver
-	fileSaver *FileSaver
-	treeSaver *TreeSaver
+	blobSaver  *BlobSaver
+	fileSaver  *FileSaver
+	treeSaver  *TreeSaver
+	checkpoint *checkpoint
+	summary    *summary
 
 	// Error is called for all errors that occur during backup.
 	Error ErrorFunc
 
+	// BeforeSnapshot is called when all data has been saved, right before the
+	// snapshot is written. If it returns an error, no snapshot is saved.
+	BeforeSnapshot func() error
+
+	// CheckpointError is called when a checkpoint cannot be saved. The backup
+	// continues and the next checkpoint is tried at the next interval.
+	CheckpointError func(err error)
+
 	// CompleteItem is called for all files and dirs once they have been
 	// processed successfully. The parameter item contains the path as it will
 	// be in the snapshot after saving. s contains some statistics about this
@@ -97,6 +107,12 @@ type Options struct {
 	// SaveTreeConcurrency sets how many trees are marshalled and saved to the
 	// repo concurrently.
 	SaveTreeConcurrency uint
+
+	// CheckpointInterval configures how often the index is saved and a
+	// checkpoint snapshot containing the parts of the backup which are done
+	// is written while a snapshot is created. If it's set to zero, no
+	// checkpoints are written.
+	CheckpointInterval time.Duration
 }
 
 // ApplyDefaults returns a copy of o with the default options set for all unset
@@ -131,10 +147,12 @@ func New(repo restic.Repository, fs fs.FS, opts Options) *Archiver {
 		FS:           fs,
 		Options:      opts.ApplyDefaults(),
 
-		CompleteItem: func(string, *restic.Node, *restic.Node, ItemStats, time.Duration) {},
-		StartFile:    func(string) {},
-		CompleteBlob: func(string, uint64) {},
-		IgnoreInode:  false,
+		BeforeSnapshot:  func() error { return nil },
+		CheckpointError: func(error) {},
+		CompleteItem:    func(string, *restic.Node, *restic.Node, ItemStats, time.Duration) {},
+		StartFile:       func(string) {},
+		CompleteBlob:    func(string, uint64) {},
+		IgnoreInode:     false,
 	}
 
 	return arch
@@ -157,6 +175,12 @@ func (arch *Archiver) error(item string, fi os.FileInfo, err error) error {
 	return errf
 }
 
+// completeItem updates the summary and calls arch.CompleteItem.
+func (arch *Archiver) completeItem(item string, previous, current *restic.Node, s ItemStats, d time.Duration) {
+	arch.summary.complete(previous, current, s)
+	arch.CompleteItem(item, previous, current, s, d)
+}
+
 // saveTree stores a tree in the repo. It checks the index and the known blobs
 // before saving anything.
 func (arch *Archiver) saveTree(ctx context.Context, t *restic.Tree) (restic.ID, ItemStats, error) {
@@ -215,6 +239,7 @@ func (arch *Archiver) SaveDir(ctx context.Context, snPath string, fi os.FileInfo
 	if err != nil {
 		return FutureTree{}, err
 	}
+	arch.checkpoint.startDir(snPath, treeNode)
 
 	names, err := readdirnames(arch.FS, dir, fs.O_NOFOLLOW)
 	if err != nil {
@@ -401,7 +426,7 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		if previous != nil && !fileChanged(fi, previous, arch.IgnoreInode) {
 			if arch.allBlobsPresent(previous) {
 				debug.Log("%v hasn't changed, using old list of blobs", target)
-				arch.CompleteItem(snPath, previous, previous, ItemStats{}, time.Since(start))
+				arch.completeItem(snPath, previous, previous, ItemStats{}, time.Since(start))
 				arch.CompleteBlob(snPath, previous.Size)
 				fn.node, err = arch.nodeFromFileInfo(target, fi)
 				if err != nil {
@@ -410,6 +435,8 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 
 				// copy list of blobs
 				fn.node.Content = previous.Content
+				fn.node.Sparse = previous.Sparse
+				arch.checkpoint.complete(snPath, fn.node)
 
 				_ = file.Close()
 				return fn, false, nil
@@ -426,7 +453,8 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		fn.file = arch.fileSaver.Save(ctx, snPath, file, fi, func() {
 			arch.StartFile(snPath)
 		}, func(node *restic.Node, stats ItemStats) {
-			arch.CompleteItem(snPath, previous, node, stats, time.Since(start))
+			arch.checkpoint.complete(snPath, node)
+			arch.completeItem(snPath, previous, node, stats, time.Since(start))
 		})
 
 	case fi.IsDir():
@@ -439,7 +467,8 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		fn.isTree = true
 		fn.tree, err = arch.SaveDir(ctx, snPath, fi, target, oldSubtree,
 			func(node *restic.Node, stats ItemStats) {
-				arch.CompleteItem(snItem, previous, node, stats, time.Since(start))
+				arch.checkpoint.complete(snPath, node)
+				arch.completeItem(snItem, previous, node, stats, time.Since(start))
 			})
 		if err != nil {
 			debug.Log("SaveDir for %v returned error: %v", snPath, err)
@@ -457,6 +486,7 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		if err != nil {
 			return FutureNode{}, false, err
 		}
+		arch.checkpoint.complete(snPath, fn.node)
 	}
 
 	debug.Log("return after %.3f", time.Since(start).Seconds())
@@ -611,7 +641,8 @@ func (arch *Archiver) SaveTree(ctx context.Context, snPath string, atree *Tree,
 			return nil, err
 		}
 
-		arch.CompleteItem(snItem, oldNode, node, nodeStats, time.Since(start))
+		arch.checkpoint.complete(join(snPath, name), node)
+		arch.completeItem(snItem, oldNode, node, nodeStats, time.Since(start))
 	}
 
 	debug.Log("waiting on %d nodes", len(futureNodes))
@@ -742,6 +773,7 @@ func (arch *Archiver) runWorkers(ctx context.Context, t *tomb.Tomb) {
 	arch.fileSaver = NewFileSaver(ctx, t,
 		arch.blobSaver.Save,
 		arch.Repo.Config().ChunkerPolynomial,
+		arch.Repo.Config().ChunkerSizes(),
 		arch.Options.FileReadConcurrency, arch.Options.SaveBlobConcurrency)
 	arch.fileSaver.CompleteBlob = arch.CompleteBlob
 	arch.fileSaver.NodeFromFileInfo = arch.nodeFromFileInfo
@@ -749,7 +781,26 @@ func (arch *Archiver) runWorkers(ctx context.Context, t *tomb.Tomb) {
 	arch.treeSaver = NewTreeSaver(ctx, t, arch.Options.SaveTreeConcurrency, arch.saveTree, arch.Error)
 }
 
-// Snapshot saves several targets and returns a snapshot.
+// newSnapshot returns a new snapshot for the targets with the given tree.
+func (arch *Archiver) newSnapshot(targets []string, opts SnapshotOptions, tree restic.ID) (*restic.Snapshot, error) {
+	sn, err := restic.NewSnapshot(targets, opts.Tags, opts.Hostname, opts.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	sn.Excludes = opts.Excludes
+	if !opts.ParentSnapshot.IsNull() {
+		id := opts.ParentSnapshot
+		sn.Parent = &id
+	}
+	sn.Tree = &tree
+
+	return sn, nil
+}
+
+// Snapshot saves several targets and returns a snapshot. When a checkpoint
+// interval is configured, checkpoint snapshots are written regularly while
+// the snapshot is created. They are removed when the snapshot is complete.
 func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts SnapshotOptions) (*restic.Snapshot, restic.ID, error) {
 	cleanTargets, err := resolveRelativeTargets(arch.FS, targets)
 	if err != nil {
@@ -764,8 +815,24 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 	var t tomb.Tomb
 	wctx := t.Context(ctx)
 
+	arch.summary = &summary{}
+	arch.summary.BackupStart = time.Now()
+
 	arch.runWorkers(wctx, &t)
 
+	var (
+		stopCheckpoints chan struct{}
+		lastCheckpoint  chan restic.ID
+	)
+	if arch.Options.CheckpointInterval > 0 {
+		arch.checkpoint = newCheckpoint()
+		stopCheckpoints = make(chan struct{})
+		lastCheckpoint = make(chan restic.ID, 1)
+		t.Go(func() error {
+			return arch.runCheckpoints(wctx, stopCheckpoints, targets, opts, lastCheckpoint)
+		})
+	}
+
 	start := time.Now()
 
 	debug.Log("starting snapshot")
@@ -783,6 +850,14 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 	}()
 	debug.Log("saved tree, error: %v", err)
 
+	// wait until a checkpoint which is currently being written is done
+	var checkpointID restic.ID
+	if stopCheckpoints != nil {
+		close(stopCheckpoints)
+		checkpointID = <-lastCheckpoint
+		arch.checkpoint = nil
+	}
+
 	t.Kill(nil)
 	werr := t.Wait()
 	debug.Log("err is %v, werr is %v", err, werr)
@@ -795,29 +870,39 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, err
 	}
 
-	arch.CompleteItem("/", nil, nil, stats, time.Since(start))
+	arch.completeItem("/", nil, nil, stats, time.Since(start))
 
 	err = arch.Repo.Flush(ctx)
 	if err != nil {
 		return nil, restic.ID{}, err
 	}
 
-	sn, err := restic.NewSnapshot(targets, opts.Tags, opts.Hostname, opts.Time)
+	err = arch.BeforeSnapshot()
 	if err != nil {
 		return nil, restic.ID{}, err
 	}
 
-	sn.Excludes = opts.Excludes
-	if !opts.ParentSnapshot.IsNull() {
-		id := opts.ParentSnapshot
-		sn.Parent = &id
+	sn, err := arch.newSnapshot(targets, opts, rootTreeID)
+	if err != nil {
+		return nil, restic.ID{}, err
 	}
-	sn.Tree = &rootTreeID
+
+	sn.Summary = &arch.summary.SnapshotSummary
+	sn.Summary.BackupEnd = time.Now()
 
 	id, err := arch.Repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
 	if err != nil {
 		return nil, restic.ID{}, err
 	}
 
+	// the checkpoints written by this and earlier, interrupted runs are not
+	// needed any more
+	if !checkpointID.IsNull() || arch.isCheckpoint(ctx, opts.ParentSnapshot) {
+		err = arch.removeCheckpoints(ctx, sn)
+		if err != nil {
+			return nil, restic.ID{}, err
+		}
+	}
+
 	return sn, id, nil
 }
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/checker"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
@@ -153,6 +154,65 @@ func TestArchiverSaveFile(t *testing.T) {
 	}
 }
 
+func TestArchiverSaveFileSparse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, TestDir{})
+	defer cleanup()
+
+	// data, a large hole, more data and a hole at the end
+	data1 := restictest.Random(23, 3*1024*1024+123)
+	data2 := restictest.Random(42, 1024*1024)
+	offset2 := int64(len(data1)) + 20*1024*1024
+	size := offset2 + int64(len(data2)) + 9*1024*1024
+
+	filename := filepath.Join(tempdir, "sparse")
+	f, err := os.Create(filename)
+	restictest.OK(t, err)
+	restictest.OK(t, f.Truncate(size))
+	_, err = f.WriteAt(data1, 0)
+	restictest.OK(t, err)
+	_, err = f.WriteAt(data2, offset2)
+	restictest.OK(t, err)
+	restictest.OK(t, f.Close())
+
+	f, err = os.Open(filename)
+	restictest.OK(t, err)
+	holes, err := fs.Holes(f, size)
+	restictest.OK(t, f.Close())
+	restictest.OK(t, err)
+	if len(holes) == 0 {
+		t.Skip("file system does not support holes")
+	}
+
+	node, stats := saveFile(t, repo, filename, fs.Track{FS: fs.Local{}})
+
+	if !node.Sparse {
+		t.Errorf("node is not marked as sparse")
+	}
+
+	content := make([]byte, size)
+	copy(content, data1)
+	copy(content[offset2:], data2)
+	TestEnsureFileContent(ctx, t, repo, "sparse", node, TestFile{Content: string(content)})
+
+	// the zeros are only stored once
+	if stats.DataSize > uint64(len(data1)+len(data2))+2*chunker.MaxSize {
+		t.Errorf("too much data saved: %v", stats.DataSize)
+	}
+
+	// a copy without holes is stored with the same blobs
+	filename = filepath.Join(tempdir, "copy")
+	restictest.OK(t, ioutil.WriteFile(filename, content, 0600))
+
+	node2, _ := saveFile(t, repo, filename, fs.Track{FS: fs.Local{}})
+	if node2.Sparse {
+		t.Errorf("copy is marked as sparse")
+	}
+	restictest.Equals(t, node2.Content, node.Content)
+}
+
 func TestArchiverSaveFileReaderFS(t *testing.T) {
 	var tests = []struct {
 		Data string
@@ -1678,6 +1738,120 @@ func TestArchiverParent(t *testing.T) {
 	}
 }
 
+func TestArchiverSnapshotSummary(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := TestDir{
+		"dir": TestDir{
+			"foo": TestFile{Content: "foo"},
+			"bar": TestFile{Content: "bar"},
+		},
+		"baz": TestFile{Content: "bazbaz"},
+	}
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+
+	start := time.Now()
+	sn, firstSnapshotID, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sn.Summary == nil {
+		t.Fatal("snapshot has no summary")
+	}
+	if sn.Summary.BackupStart.Before(start) || sn.Summary.BackupEnd.Before(sn.Summary.BackupStart) {
+		t.Errorf("invalid backup start %v or end %v", sn.Summary.BackupStart, sn.Summary.BackupEnd)
+	}
+
+	want := restic.SnapshotSummary{
+		BackupStart:         sn.Summary.BackupStart,
+		BackupEnd:           sn.Summary.BackupEnd,
+		FilesNew:            3,
+		DirsNew:             1,
+		DataBlobs:           3,
+		TreeBlobs:           2,
+		DataAdded:           sn.Summary.DataAdded,
+		TotalFilesProcessed: 3,
+		TotalBytesProcessed: 12,
+	}
+	if !cmp.Equal(want, *sn.Summary) {
+		t.Error(cmp.Diff(want, *sn.Summary))
+	}
+	if sn.Summary.DataAdded <= 12 {
+		t.Errorf("wrong DataAdded %d", sn.Summary.DataAdded)
+	}
+
+	// the summary is saved in the repository
+	loaded, err := restic.LoadSnapshot(ctx, repo, firstSnapshotID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Summary == nil || loaded.Summary.FilesNew != 3 {
+		t.Errorf("wrong summary loaded: %+v", loaded.Summary)
+	}
+
+	// modify a file and save a second snapshot
+	restictest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "baz"), []byte("changed"), 0644))
+
+	sn, _, err = arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now(), ParentSnapshot: firstSnapshotID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = restic.SnapshotSummary{
+		BackupStart:         sn.Summary.BackupStart,
+		BackupEnd:           sn.Summary.BackupEnd,
+		FilesChanged:        1,
+		FilesUnmodified:     2,
+		DirsUnmodified:      1,
+		DataBlobs:           1,
+		TreeBlobs:           1,
+		DataAdded:           sn.Summary.DataAdded,
+		TotalFilesProcessed: 3,
+		TotalBytesProcessed: 13,
+	}
+	if !cmp.Equal(want, *sn.Summary) {
+		t.Error(cmp.Diff(want, *sn.Summary))
+	}
+}
+
+func TestArchiverBeforeSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, TestDir{"foo": TestFile{Content: "foo"}})
+	defer cleanup()
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.BeforeSnapshot = func() error {
+		return errors.New("before snapshot failed")
+	}
+
+	_, _, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	if err == nil {
+		t.Fatal("expected error not returned")
+	}
+
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 0 {
+		t.Fatalf("expected no snapshots, got %d", len(snapshots))
+	}
+}
+
 func TestArchiverErrorReporting(t *testing.T) {
 	ignoreErrorForBasename := func(basename string) ErrorFunc {
 		return func(item string, fi os.FileInfo, err error) error {
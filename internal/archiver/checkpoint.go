@@ -0,0 +1,352 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// checkpoint collects the nodes of all files and directories which have been
+// saved completely, so that a snapshot of the parts of the backup which are
+// already done can be written while the backup is still running.
+type checkpoint struct {
+	m    sync.Mutex
+	root *checkpointDir
+}
+
+// checkpointDir is a directory which is still being processed.
+type checkpointDir struct {
+	// node is the metadata of the directory, it is nil if not known yet
+	node *restic.Node
+	// nodes contains all completed files and directories
+	nodes map[string]*restic.Node
+	// dirs contains the subdirectories which are still being processed
+	dirs map[string]*checkpointDir
+}
+
+func newCheckpointDir() *checkpointDir {
+	return &checkpointDir{
+		nodes: make(map[string]*restic.Node),
+		dirs:  make(map[string]*checkpointDir),
+	}
+}
+
+func newCheckpoint() *checkpoint {
+	return &checkpoint{root: newCheckpointDir()}
+}
+
+// dir returns the directory for the path components, it is created if it
+// does not exist yet.
+func (c *checkpoint) dir(components []string) *checkpointDir {
+	dir := c.root
+	for _, name := range components {
+		sub, ok := dir.dirs[name]
+		if !ok {
+			sub = newCheckpointDir()
+			dir.dirs[name] = sub
+		}
+		dir = sub
+	}
+	return dir
+}
+
+func splitSnPath(snPath string) []string {
+	snPath = strings.Trim(path.Clean(snPath), "/")
+	if snPath == "" {
+		return nil
+	}
+	return strings.Split(snPath, "/")
+}
+
+// startDir records the metadata for the directory at snPath, which is about
+// to be processed.
+func (c *checkpoint) startDir(snPath string, node *restic.Node) {
+	if c == nil {
+		return
+	}
+
+	n := *node
+	n.Name = path.Base(snPath)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.dir(splitSnPath(snPath)).node = &n
+}
+
+// complete records that the file or directory at snPath has been saved.
+func (c *checkpoint) complete(snPath string, node *restic.Node) {
+	if c == nil || node == nil {
+		return
+	}
+
+	components := splitSnPath(snPath)
+	if len(components) == 0 {
+		return
+	}
+
+	// the node is modified later on, so store a copy
+	n := *node
+	n.Name = components[len(components)-1]
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	parent := c.dir(components[:len(components)-1])
+	delete(parent.dirs, n.Name)
+	parent.nodes[n.Name] = &n
+}
+
+// copy returns a copy of the directory d and all subdirectories.
+func (d *checkpointDir) copy() *checkpointDir {
+	res := newCheckpointDir()
+	res.node = d.node
+	for name, node := range d.nodes {
+		res.nodes[name] = node
+	}
+	for name, dir := range d.dirs {
+		res.dirs[name] = dir.copy()
+	}
+	return res
+}
+
+// empty returns true if no file or directory within d has been completed.
+func (d *checkpointDir) empty() bool {
+	if len(d.nodes) > 0 {
+		return false
+	}
+	for _, dir := range d.dirs {
+		if !dir.empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// state returns a copy of the nodes collected so far.
+func (c *checkpoint) state() *checkpointDir {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.root.copy()
+}
+
+// indexed returns true if the blobs referenced by node have already been
+// saved to the repository. Pack files which are still being uploaded are not
+// contained in the index yet.
+func (arch *Archiver) indexed(node *restic.Node) bool {
+	idx := arch.Repo.Index()
+
+	if node.Type == "dir" {
+		return node.Subtree != nil && len(idx.Lookup(*node.Subtree, restic.TreeBlob)) > 0
+	}
+
+	for _, id := range node.Content {
+		if len(idx.Lookup(id, restic.DataBlob)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// saveCheckpointTree saves the tree for the directory d together with all
+// subdirectories and returns the ID of the tree. Nodes which reference data
+// which has not been saved yet are left out.
+func (arch *Archiver) saveCheckpointTree(ctx context.Context, d *checkpointDir, now time.Time) (restic.ID, error) {
+	tree := restic.NewTree()
+
+	for _, node := range d.nodes {
+		if !arch.indexed(node) {
+			debug.Log("leaving out %v, data has not been saved yet", node.Name)
+			continue
+		}
+
+		err := tree.Insert(node)
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	for name, dir := range d.dirs {
+		id, err := arch.saveCheckpointTree(ctx, dir, now)
+		if err != nil {
+			return restic.ID{}, err
+		}
+
+		var node restic.Node
+		if dir.node != nil {
+			node = *dir.node
+		} else {
+			// the metadata for directories which were not read from a
+			// directory listing is only known after they are complete
+			node = restic.Node{
+				Type:       "dir",
+				Mode:       os.ModeDir | 0755,
+				ModTime:    now,
+				AccessTime: now,
+				ChangeTime: now,
+			}
+		}
+		node.Name = name
+		node.Subtree = &id
+
+		err = tree.Insert(&node)
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	id, _, err := arch.saveTree(ctx, tree)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	if ctx.Err() != nil {
+		return restic.ID{}, ctx.Err()
+	}
+
+	return id, nil
+}
+
+// saveCheckpoint writes a snapshot containing all files and directories which
+// have been saved so far. The index is saved beforehand, so that the data
+// does not need to be uploaded again when the backup is restarted.
+func (arch *Archiver) saveCheckpoint(ctx context.Context, targets []string, opts SnapshotOptions) (restic.ID, error) {
+	state := arch.checkpoint.state()
+	if state.empty() {
+		debug.Log("nothing has been saved yet")
+		return restic.ID{}, nil
+	}
+
+	// save all data collected so far
+	err := arch.Repo.Flush(ctx)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	rootTreeID, err := arch.saveCheckpointTree(ctx, state, time.Now())
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	err = arch.Repo.Flush(ctx)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	sn, err := arch.newSnapshot(targets, opts, rootTreeID)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	sn.Checkpoint = true
+
+	id, err := arch.Repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	debug.Log("saved checkpoint %v", id)
+	return id, nil
+}
+
+// runCheckpoints periodically writes a checkpoint until stop is closed or ctx
+// is cancelled. Each checkpoint replaces the previous one, a checkpoint which
+// cannot be saved is reported to arch.CheckpointError and does not abort the
+// backup. The ID of the last checkpoint is sent to ch when the function
+// returns.
+func (arch *Archiver) runCheckpoints(ctx context.Context, stop <-chan struct{}, targets []string, opts SnapshotOptions, ch chan<- restic.ID) error {
+	var last restic.ID
+	defer func() {
+		ch <- last
+	}()
+
+	ticker := time.NewTicker(arch.Options.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		id, err := arch.saveCheckpoint(ctx, targets, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				// the backup has been aborted
+				return nil
+			}
+			debug.Log("saving checkpoint failed: %v", err)
+			arch.CheckpointError(err)
+			continue
+		}
+
+		if id.IsNull() {
+			continue
+		}
+
+		arch.removeSnapshot(ctx, last)
+		last = id
+	}
+}
+
+// removeSnapshot removes the snapshot with the given ID, errors are only
+// logged, a snapshot which could not be removed is removed by the next
+// successful backup.
+func (arch *Archiver) removeSnapshot(ctx context.Context, id restic.ID) {
+	if id.IsNull() {
+		return
+	}
+
+	h := restic.Handle{Type: restic.SnapshotFile, Name: id.String()}
+	err := arch.Repo.Backend().Remove(ctx, h)
+	debug.Log("removed snapshot %v: %v", id, err)
+}
+
+// isCheckpoint returns true if the snapshot with the given ID is a checkpoint.
+func (arch *Archiver) isCheckpoint(ctx context.Context, id restic.ID) bool {
+	if id.IsNull() {
+		return false
+	}
+
+	sn, err := restic.LoadSnapshot(ctx, arch.Repo, id)
+	if err != nil {
+		debug.Log("unable to load snapshot %v: %v", id, err)
+		return false
+	}
+
+	return sn.Checkpoint
+}
+
+// removeCheckpoints removes all checkpoints which are superseded by the
+// snapshot sn, which is the case when they were created for the same host
+// and paths.
+func (arch *Archiver) removeCheckpoints(ctx context.Context, sn *restic.Snapshot) error {
+	return arch.Repo.List(ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
+		cp, err := restic.LoadSnapshot(ctx, arch.Repo, id)
+		if err != nil {
+			debug.Log("unable to load snapshot %v: %v", id, err)
+			return nil
+		}
+
+		if !cp.Checkpoint || cp.Hostname != sn.Hostname {
+			return nil
+		}
+
+		if len(cp.Paths) != len(sn.Paths) || !cp.HasPaths(sn.Paths) {
+			return nil
+		}
+
+		debug.Log("remove checkpoint %v", id)
+		h := restic.Handle{Type: restic.SnapshotFile, Name: id.String()}
+		return arch.Repo.Backend().Remove(ctx, h)
+	})
+}
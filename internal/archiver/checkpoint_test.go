@@ -0,0 +1,227 @@
+package archiver
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+	tomb "gopkg.in/tomb.v2"
+)
+
+func TestArchiverCheckpoint(t *testing.T) {
+	src := TestDir{
+		"dir": TestDir{
+			"done": TestDir{
+				"file1": TestFile{Content: "foo"},
+				"file2": TestFile{Content: string(restictest.Random(23, 3*1024*1024+7))},
+			},
+			"partial": TestDir{
+				"file3": TestFile{Content: "bar"},
+				"file4": TestFile{Content: "baz"},
+			},
+		},
+		"other": TestFile{Content: "other"},
+	}
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	var tmb tomb.Tomb
+	ctx := tmb.Context(context.Background())
+	defer func() {
+		tmb.Kill(nil)
+		_ = tmb.Wait()
+	}()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.runWorkers(ctx, &tmb)
+	arch.checkpoint = newCheckpoint()
+
+	id, err := arch.saveCheckpoint(ctx, []string{tempdir}, SnapshotOptions{Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.IsNull() {
+		t.Fatalf("checkpoint %v written although nothing has been saved yet", id.Str())
+	}
+
+	// save a complete directory and a single file of another directory
+	for _, item := range []string{"/dir/done", "/dir/partial/file3"} {
+		fn, excluded, err := arch.Save(ctx, item, filepath.Join(tempdir, filepath.FromSlash(item)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if excluded {
+			t.Fatalf("%v was excluded", item)
+		}
+		fn.wait(ctx)
+		if fn.err != nil {
+			t.Fatal(fn.err)
+		}
+	}
+
+	id, err = arch.saveCheckpoint(ctx, []string{tempdir}, SnapshotOptions{Hostname: "host", Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.IsNull() {
+		t.Fatal("no checkpoint written")
+	}
+
+	TestEnsureSnapshot(t, repo, id, TestDir{
+		"dir": TestDir{
+			"done": src["dir"].(TestDir)["done"],
+			"partial": TestDir{
+				"file3": TestFile{Content: "bar"},
+			},
+		},
+	})
+
+	sn, err := restic.LoadSnapshot(ctx, repo, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sn.Checkpoint {
+		t.Fatal("snapshot is not marked as a checkpoint")
+	}
+	if !arch.isCheckpoint(ctx, id) {
+		t.Fatal("isCheckpoint returned false")
+	}
+
+	// a complete snapshot for another host keeps the checkpoint
+	other := *sn
+	other.Hostname = "other"
+	err = arch.removeCheckpoints(ctx, &other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restic.LoadSnapshot(ctx, repo, id); err != nil {
+		t.Fatalf("checkpoint was removed: %v", err)
+	}
+
+	err = arch.removeCheckpoints(ctx, sn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restic.LoadSnapshot(ctx, repo, id); err == nil {
+		t.Fatal("checkpoint was not removed")
+	}
+}
+
+func TestArchiverSnapshotCheckpoints(t *testing.T) {
+	src := TestDir{
+		"dir": TestDir{
+			"file1": TestFile{Content: string(restictest.Random(42, 5*1024*1024))},
+			"file2": TestFile{Content: string(restictest.Random(43, 5*1024*1024))},
+			"subdir": TestDir{
+				"file3": TestFile{Content: "foo"},
+			},
+		},
+		"file4": TestFile{Content: string(restictest.Random(44, 5*1024*1024))},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{CheckpointInterval: time.Millisecond})
+
+	for i := 0; i < 2; i++ {
+		_, id, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		TestEnsureSnapshot(t, repo, id, src)
+
+		// all checkpoints have been removed
+		snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshots) != i+1 {
+			t.Fatalf("expected %d snapshots, got %d", i+1, len(snapshots))
+		}
+	}
+}
+
+// failCheckpointRepo fails to save checkpoint snapshots.
+type failCheckpointRepo struct {
+	restic.Repository
+}
+
+func (r failCheckpointRepo) SaveJSONUnpacked(ctx context.Context, t restic.FileType, item interface{}) (restic.ID, error) {
+	if sn, ok := item.(*restic.Snapshot); ok && sn.Checkpoint {
+		return restic.ID{}, errors.New("checkpoint failed")
+	}
+	return r.Repository.SaveJSONUnpacked(ctx, t, item)
+}
+
+func TestArchiverCheckpointError(t *testing.T) {
+	src := TestDir{
+		"file": TestFile{Content: "foo"},
+	}
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	var tmb tomb.Tomb
+	ctx := tmb.Context(context.Background())
+	defer func() {
+		tmb.Kill(nil)
+		_ = tmb.Wait()
+	}()
+
+	arch := New(failCheckpointRepo{repo}, fs.Track{FS: fs.Local{}}, Options{CheckpointInterval: time.Millisecond})
+	arch.runWorkers(ctx, &tmb)
+	arch.checkpoint = newCheckpoint()
+
+	fn, _, err := arch.Save(ctx, "/file", filepath.Join(tempdir, "file"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn.wait(ctx)
+	if fn.err != nil {
+		t.Fatal(fn.err)
+	}
+
+	// the checkpoints are retried after an error
+	errs := make(chan error, 2)
+	arch.CheckpointError = func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+
+	stop := make(chan struct{})
+	last := make(chan restic.ID, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- arch.runCheckpoints(ctx, stop, []string{tempdir}, SnapshotOptions{Time: time.Now()}, last)
+	}()
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Fatal("CheckpointError called without an error")
+		}
+	}
+	close(stop)
+
+	if err := <-done; err != nil {
+		t.Fatalf("failed checkpoint aborted the backup: %v", err)
+	}
+	if id := <-last; !id.IsNull() {
+		t.Fatalf("unexpected checkpoint %v", id.Str())
+	}
+}
@@ -56,7 +56,8 @@ type FileSaver struct {
 	saveFilePool *BufferPool
 	saveBlob     SaveBlobFn
 
-	pol chunker.Pol
+	pol   chunker.Pol
+	sizes restic.ChunkerSizes
 
 	ch chan<- saveFileJob
 
@@ -65,9 +66,10 @@ type FileSaver struct {
 	NodeFromFileInfo func(filename string, fi os.FileInfo) (*restic.Node, error)
 }
 
-// NewFileSaver returns a new file saver. A worker pool with fileWorkers is
+// NewFileSaver returns a new file saver. Files are split into chunks using the
+// polynomial pol and the chunk sizes. A worker pool with fileWorkers is
 // started, it is stopped when ctx is cancelled.
-func NewFileSaver(ctx context.Context, t *tomb.Tomb, save SaveBlobFn, pol chunker.Pol, fileWorkers, blobWorkers uint) *FileSaver {
+func NewFileSaver(ctx context.Context, t *tomb.Tomb, save SaveBlobFn, pol chunker.Pol, sizes restic.ChunkerSizes, fileWorkers, blobWorkers uint) *FileSaver {
 	ch := make(chan saveFileJob)
 
 	debug.Log("new file saver with %v file workers and %v blob workers", fileWorkers, blobWorkers)
@@ -76,8 +78,9 @@ func NewFileSaver(ctx context.Context, t *tomb.Tomb, save SaveBlobFn, pol chunke
 
 	s := &FileSaver{
 		saveBlob:     save,
-		saveFilePool: NewBufferPool(ctx, int(poolSize), chunker.MaxSize),
+		saveFilePool: NewBufferPool(ctx, int(poolSize), int(sizes.Max)),
 		pol:          pol,
+		sizes:        sizes,
 		ch:           ch,
 
 		CompleteBlob: func(string, uint64) {},
@@ -155,13 +158,129 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		return saveFileResponse{err: errors.Errorf("node type %q is wrong", node.Type)}
 	}
 
-	// reuse the chunker
-	chnker.Reset(f, s.pol)
+	holes, err := s.findHoles(f, fi.Size())
+	if err != nil {
+		_ = f.Close()
+		return saveFileResponse{err: err}
+	}
 
-	var results []FutureBlob
+	// the holes are split into chunks like the data, so a sparse file is
+	// stored with the same blobs as a copy without holes
+	var rd io.Reader = f
+	if len(holes) > 0 {
+		rd = &holeReader{f: f, holes: holes}
+	}
 
 	node.Content = []restic.ID{}
-	var size uint64
+
+	results, size, err := s.saveChunks(ctx, chnker, f.Name(), rd)
+	if err != nil {
+		_ = f.Close()
+		return saveFileResponse{err: err}
+	}
+
+	err = f.Close()
+	if err != nil {
+		return saveFileResponse{err: err}
+	}
+
+	for _, res := range results {
+		res.Wait(ctx)
+		if !res.Known() {
+			stats.DataBlobs++
+			stats.DataSize += uint64(res.Length())
+		}
+
+		node.Content = append(node.Content, res.ID())
+	}
+
+	node.Size = size
+	node.Sparse = len(holes) > 0
+
+	return saveFileResponse{
+		node:  node,
+		stats: stats,
+	}
+}
+
+// holeReader reads a file with holes. The holes are not read from the file,
+// zeros are returned instead, so the data is split into the same chunks as the
+// data of a file without holes.
+type holeReader struct {
+	f      fs.File
+	holes  []fs.Hole
+	offset int64
+}
+
+func (r *holeReader) Read(p []byte) (int, error) {
+	if len(r.holes) == 0 {
+		n, err := r.f.Read(p)
+		r.offset += int64(n)
+		return n, err
+	}
+
+	hole := r.holes[0]
+	if r.offset < hole.Offset {
+		// read the data up to the next hole
+		if int64(len(p)) > hole.Offset-r.offset {
+			p = p[:hole.Offset-r.offset]
+		}
+		n, err := r.f.Read(p)
+		r.offset += int64(n)
+		return n, err
+	}
+
+	end := hole.Offset + hole.Length
+	if int64(len(p)) > end-r.offset {
+		p = p[:end-r.offset]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	r.offset += int64(len(p))
+
+	if r.offset == end {
+		r.holes = r.holes[1:]
+		_, err := r.f.Seek(end, io.SeekStart)
+		if err != nil {
+			return len(p), errors.Wrap(err, "Seek")
+		}
+	}
+
+	return len(p), nil
+}
+
+// findHoles returns the holes in the file f which are large enough to be
+// saved without reading them. Holes smaller than the minimal chunk size are
+// read like data.
+func (s *FileSaver) findHoles(f fs.File, size int64) ([]fs.Hole, error) {
+	minHoleSize := int64(s.sizes.Min)
+	if size < minHoleSize {
+		return nil, nil
+	}
+
+	holes, err := fs.Holes(f, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []fs.Hole
+	for _, hole := range holes {
+		if hole.Length >= minHoleSize {
+			res = append(res, hole)
+		}
+	}
+
+	debug.Log("%v: %d holes", f.Name(), len(res))
+	return res, nil
+}
+
+// saveChunks splits the data read from rd into chunks and saves them.
+func (s *FileSaver) saveChunks(ctx context.Context, chnker *chunker.Chunker, filename string, rd io.Reader) (results []FutureBlob, size uint64, err error) {
+	// reuse the chunker, resetting it also resets the average size
+	chnker.ResetWithBoundaries(rd, s.pol, s.sizes.Min, s.sizes.Max)
+	chnker.SetAverageBits(s.sizes.AverageBits())
+
 	for {
 		buf := s.saveFilePool.Get()
 		chunk, err := chnker.Next(buf.Data)
@@ -175,14 +294,12 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		size += uint64(chunk.Length)
 
 		if err != nil {
-			_ = f.Close()
-			return saveFileResponse{err: err}
+			return results, size, err
 		}
 
 		// test if the context has been cancelled, return the error
 		if ctx.Err() != nil {
-			_ = f.Close()
-			return saveFileResponse{err: ctx.Err()}
+			return results, size, ctx.Err()
 		}
 
 		res := s.saveBlob(ctx, restic.DataBlob, buf)
@@ -190,39 +307,18 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 
 		// test if the context has been cancelled, return the error
 		if ctx.Err() != nil {
-			_ = f.Close()
-			return saveFileResponse{err: ctx.Err()}
+			return results, size, ctx.Err()
 		}
 
-		s.CompleteBlob(f.Name(), uint64(len(chunk.Data)))
+		s.CompleteBlob(filename, uint64(len(chunk.Data)))
 	}
 
-	err = f.Close()
-	if err != nil {
-		return saveFileResponse{err: err}
-	}
-
-	for _, res := range results {
-		res.Wait(ctx)
-		if !res.Known() {
-			stats.DataBlobs++
-			stats.DataSize += uint64(res.Length())
-		}
-
-		node.Content = append(node.Content, res.ID())
-	}
-
-	node.Size = size
-
-	return saveFileResponse{
-		node:  node,
-		stats: stats,
-	}
+	return results, size, nil
 }
 
 func (s *FileSaver) worker(ctx context.Context, jobs <-chan saveFileJob) {
 	// a worker has one chunker which is reused for each file (because it contains a rather large buffer)
-	chnker := chunker.New(nil, s.pol)
+	chnker := chunker.NewWithBoundaries(nil, s.pol, s.sizes.Min, s.sizes.Max)
 
 	for {
 		var job saveFileJob
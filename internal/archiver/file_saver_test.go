@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"testing"
 
 	"github.com/restic/chunker"
@@ -30,13 +31,15 @@ func createTestFiles(t testing.TB, num int) (files []string, cleanup func()) {
 	return files, cleanup
 }
 
-func startFileSaver(ctx context.Context, t testing.TB) (*FileSaver, context.Context, *tomb.Tomb) {
+func startFileSaver(ctx context.Context, t testing.TB, sizes restic.ChunkerSizes, saveBlob SaveBlobFn) (*FileSaver, context.Context, *tomb.Tomb) {
 	tmb, ctx := tomb.WithContext(ctx)
 
-	saveBlob := func(ctx context.Context, tpe restic.BlobType, buf *Buffer) FutureBlob {
-		ch := make(chan saveBlobResponse)
-		close(ch)
-		return FutureBlob{ch: ch}
+	if saveBlob == nil {
+		saveBlob = func(ctx context.Context, tpe restic.BlobType, buf *Buffer) FutureBlob {
+			ch := make(chan saveBlobResponse)
+			close(ch)
+			return FutureBlob{ch: ch}
+		}
 	}
 
 	workers := uint(runtime.NumCPU())
@@ -45,7 +48,7 @@ func startFileSaver(ctx context.Context, t testing.TB) (*FileSaver, context.Cont
 		t.Fatal(err)
 	}
 
-	s := NewFileSaver(ctx, tmb, saveBlob, pol, workers, workers)
+	s := NewFileSaver(ctx, tmb, saveBlob, pol, sizes, workers, workers)
 	s.NodeFromFileInfo = restic.NodeFromFileInfo
 
 	return s, ctx, tmb
@@ -62,7 +65,7 @@ func TestFileSaver(t *testing.T) {
 	completeFn := func(*restic.Node, ItemStats) {}
 
 	testFs := fs.Local{}
-	s, ctx, tmb := startFileSaver(ctx, t)
+	s, ctx, tmb := startFileSaver(ctx, t, restic.DefaultChunkerSizes, nil)
 
 	var results []FutureFile
 
@@ -95,3 +98,74 @@ func TestFileSaver(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestFileSaverChunkerSizes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, "file")
+	data := test.Random(23, 2*1024*1024)
+	err := ioutil.WriteFile(filename, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		m       sync.Mutex
+		lengths []int
+	)
+	saveBlob := func(ctx context.Context, tpe restic.BlobType, buf *Buffer) FutureBlob {
+		m.Lock()
+		lengths = append(lengths, len(buf.Data))
+		m.Unlock()
+
+		ch := make(chan saveBlobResponse)
+		close(ch)
+		return FutureBlob{ch: ch}
+	}
+
+	sizes := restic.ChunkerSizesFromAvg(16 * 1024)
+	s, ctx, tmb := startFileSaver(ctx, t, sizes, saveBlob)
+
+	f, err := fs.Local{}.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ff := s.Save(ctx, filename, f, fi, func() {}, nil)
+	ff.Wait(ctx)
+	if ff.Err() != nil {
+		t.Fatal(ff.Err())
+	}
+
+	tmb.Kill(nil)
+	err = tmb.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with the default sizes, the file would be split into a few chunks only
+	if len(lengths) < 32 {
+		t.Fatalf("file was split into %d chunks only", len(lengths))
+	}
+
+	total := 0
+	for i, l := range lengths {
+		total += l
+		if l > int(sizes.Max) || (l < int(sizes.Min) && i != len(lengths)-1) {
+			t.Errorf("chunk %d has invalid size %d", i, l)
+		}
+	}
+
+	if total != len(data) {
+		t.Errorf("chunks have wrong total size, want %d, got %d", len(data), total)
+	}
+}
@@ -0,0 +1,56 @@
+package archiver
+
+import (
+	"sync"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// summary collects the statistics which are stored in the snapshot.
+type summary struct {
+	m sync.Mutex
+	restic.SnapshotSummary
+}
+
+// complete adds the statistics for a completed file or directory.
+func (s *summary) complete(previous, current *restic.Node, stats ItemStats) {
+	if s == nil {
+		return
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.DataBlobs += stats.DataBlobs
+	s.TreeBlobs += stats.TreeBlobs
+	s.DataAdded += stats.DataSize + stats.TreeSize
+
+	// for the last item "/", current is nil
+	if current == nil {
+		return
+	}
+
+	switch current.Type {
+	case "file":
+		s.TotalFilesProcessed++
+		s.TotalBytesProcessed += current.Size
+
+		switch {
+		case previous == nil:
+			s.FilesNew++
+		case previous.Equals(*current):
+			s.FilesUnmodified++
+		default:
+			s.FilesChanged++
+		}
+	case "dir":
+		switch {
+		case previous == nil:
+			s.DirsNew++
+		case previous.Equals(*current):
+			s.DirsUnmodified++
+		default:
+			s.DirsChanged++
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// DryRunBackend discards all modifications of the wrapped backend, files are
+// neither saved nor removed. Lock files are passed through, so that a dry run
+// holds a lock like any other operation.
+type DryRunBackend struct {
+	restic.Backend
+}
+
+// statically ensure that DryRunBackend implements restic.Backend.
+var _ restic.Backend = &DryRunBackend{}
+
+// NewDryRunBackend wraps be with a backend that does not modify be.
+func NewDryRunBackend(be restic.Backend) *DryRunBackend {
+	return &DryRunBackend{Backend: be}
+}
+
+// Save pretends to store the data in the backend.
+func (be *DryRunBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if h.Type == restic.LockFile {
+		return be.Backend.Save(ctx, h, rd)
+	}
+
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	debug.Log("dry run: not saving %v", h)
+	return nil
+}
+
+// Remove pretends to remove the file from the backend.
+func (be *DryRunBackend) Remove(ctx context.Context, h restic.Handle) error {
+	if h.Type == restic.LockFile {
+		return be.Backend.Remove(ctx, h)
+	}
+
+	debug.Log("dry run: not removing %v", h)
+	return nil
+}
+
+// Delete pretends to remove all data from the backend.
+func (be *DryRunBackend) Delete(ctx context.Context) error {
+	debug.Log("dry run: not deleting the repository")
+	return nil
+}
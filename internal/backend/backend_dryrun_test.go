@@ -0,0 +1,48 @@
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/test"
+)
+
+func TestDryRunBackend(t *testing.T) {
+	ctx := context.TODO()
+	mem := mem.New()
+	be := backend.NewDryRunBackend(mem)
+
+	data := []byte("foo")
+	existing := restic.Handle{Type: restic.SnapshotFile, Name: restic.Hash(data).String()}
+	test.OK(t, mem.Save(ctx, existing, restic.NewByteReader(data)))
+
+	// nothing is saved or removed
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash([]byte("bar")).String()}
+	test.OK(t, be.Save(ctx, h, restic.NewByteReader([]byte("bar"))))
+	test.OK(t, be.Remove(ctx, existing))
+	test.OK(t, be.Delete(ctx))
+
+	found, err := mem.Test(ctx, h)
+	test.OK(t, err)
+	test.Assert(t, !found, "file was saved in dry-run mode")
+
+	buf, err := backend.LoadAll(ctx, nil, be, existing)
+	test.OK(t, err)
+	test.Assert(t, bytes.Equal(data, buf), "wrong data loaded: %q", buf)
+
+	// lock files are handled by the wrapped backend
+	lock := restic.Handle{Type: restic.LockFile, Name: restic.Hash([]byte("lock")).String()}
+	test.OK(t, be.Save(ctx, lock, restic.NewByteReader([]byte("lock"))))
+	found, err = mem.Test(ctx, lock)
+	test.OK(t, err)
+	test.Assert(t, found, "lock file was not saved")
+
+	test.OK(t, be.Remove(ctx, lock))
+	found, err = mem.Test(ctx, lock)
+	test.OK(t, err)
+	test.Assert(t, !found, "lock file was not removed")
+}
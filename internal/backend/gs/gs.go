@@ -414,7 +414,11 @@ func (be *Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.PruneStateFile,
+		restic.PendingFile,
+		restic.ParityFile,
+		restic.RepairFile}
 
 	for _, t := range alltypes {
 		err := be.removeKeys(ctx, t)
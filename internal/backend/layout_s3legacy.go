@@ -16,6 +16,11 @@ var s3LayoutPaths = map[restic.FileType]string{
 	restic.IndexFile:    "index",
 	restic.LockFile:     "lock",
 	restic.KeyFile:      "key",
+
+	restic.PruneStateFile: "prune",
+	restic.PendingFile:    "pending",
+	restic.ParityFile:     "parity",
+	restic.RepairFile:     "repair",
 }
 
 func (l *S3LegacyLayout) String() string {
@@ -28,6 +28,12 @@ func TestDefaultLayout(t *testing.T) {
 			restic.Handle{Type: restic.PackFile, Name: "0123456"},
 			filepath.Join(tempdir, "data", "01", "0123456"),
 		},
+		{
+			tempdir,
+			filepath.Join,
+			restic.Handle{Type: restic.ParityFile, Name: "0123456"},
+			filepath.Join(tempdir, "parity", "01", "0123456"),
+		},
 		{
 			tempdir,
 			filepath.Join,
@@ -110,10 +116,15 @@ func TestDefaultLayout(t *testing.T) {
 			filepath.Join(tempdir, "index"),
 			filepath.Join(tempdir, "locks"),
 			filepath.Join(tempdir, "keys"),
+			filepath.Join(tempdir, "prune"),
+			filepath.Join(tempdir, "pending"),
+			filepath.Join(tempdir, "parity"),
+			filepath.Join(tempdir, "repair"),
 		}
 
 		for i := 0; i < 256; i++ {
 			want = append(want, filepath.Join(tempdir, "data", fmt.Sprintf("%02x", i)))
+			want = append(want, filepath.Join(tempdir, "parity", fmt.Sprintf("%02x", i)))
 		}
 
 		sort.Strings(want)
@@ -187,6 +198,10 @@ func TestRESTLayout(t *testing.T) {
 			filepath.Join(path, "index"),
 			filepath.Join(path, "locks"),
 			filepath.Join(path, "keys"),
+			filepath.Join(path, "prune"),
+			filepath.Join(path, "pending"),
+			filepath.Join(path, "parity"),
+			filepath.Join(path, "repair"),
 		}
 
 		sort.Strings(want)
@@ -333,6 +348,10 @@ func TestS3LegacyLayout(t *testing.T) {
 			filepath.Join(path, "index"),
 			filepath.Join(path, "lock"),
 			filepath.Join(path, "key"),
+			filepath.Join(path, "prune"),
+			filepath.Join(path, "pending"),
+			filepath.Join(path, "parity"),
+			filepath.Join(path, "repair"),
 		}
 
 		sort.Strings(want)
@@ -446,7 +446,11 @@ func (b *Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.PruneStateFile,
+		restic.PendingFile,
+		restic.ParityFile,
+		restic.RepairFile}
 
 	for _, t := range alltypes {
 		err := b.removeKeys(ctx, t)
@@ -699,7 +699,8 @@ func (s *Suite) TestBackend(t *testing.T) {
 
 	for _, tpe := range []restic.FileType{
 		restic.PackFile, restic.KeyFile, restic.LockFile,
-		restic.SnapshotFile, restic.IndexFile,
+		restic.SnapshotFile, restic.IndexFile, restic.PruneStateFile, restic.PendingFile,
+		restic.ParityFile, restic.RepairFile,
 	} {
 		// detect non-existing files
 		for _, ts := range testStrings {
@@ -31,6 +31,10 @@ type Checker struct {
 	masterIndex *repository.MasterIndex
 
 	repo restic.Repository
+
+	// NoRepair disables repairing packs and parity files using parity data,
+	// which must only be done while the repository is locked exclusively.
+	NoRepair bool
 }
 
 type blobStatus uint8
@@ -173,6 +177,7 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 			}
 
 			debug.Log("%d blobs processed", cnt)
+			c.masterIndex.MergeFinalIndexes()
 		}
 		return nil
 	})
@@ -193,8 +198,6 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 		}
 	}
 
-	c.masterIndex.MergeFinalIndexes()
-
 	err = c.repo.SetIndex(c.masterIndex)
 	if err != nil {
 		debug.Log("SetIndex returned error: %v", err)
@@ -208,6 +211,7 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 type PackError struct {
 	ID       restic.ID
 	Orphaned bool
+	Repaired bool
 	Err      error
 }
 
@@ -225,6 +229,16 @@ func IsOrphanedPack(err error) bool {
 	return false
 }
 
+// IsRepairedPack returns true if the error describes a pack or parity file
+// which was damaged and has been repaired.
+func IsRepairedPack(err error) bool {
+	if e, ok := errors.Cause(err).(PackError); ok && e.Repaired {
+		return true
+	}
+
+	return false
+}
+
 // Packs checks that all packs referenced in the index are still available and
 // there are no packs that aren't in an index. errChan is closed after all
 // packs have been checked.
@@ -756,6 +770,13 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 			continue
 		}
 
+		plaintext, err = repository.DecompressBlob(blob, plaintext)
+		if err != nil {
+			debug.Log("  error decompressing blob %v: %v", blob.ID, err)
+			errs = append(errs, errors.Errorf("blob %v: %v", i, err))
+			continue
+		}
+
 		hash := restic.Hash(plaintext)
 		if !hash.Equal(blob.ID) {
 			debug.Log("  Blob ID does not match, want %v, got %v", blob.ID, hash)
@@ -771,6 +792,43 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 	return nil
 }
 
+// checkParity uses the parity file to repair a pack for which checkPack
+// returned err. If the pack is intact, a missing or damaged parity file is
+// computed again. Repairs are reported as a PackError with Repaired set.
+func (c *Checker) checkParity(ctx context.Context, id restic.ID, err error) error {
+	if err != nil {
+		rerr := repository.RepairPack(ctx, c.repo, id)
+		if rerr != nil {
+			debug.Log("repairing pack %v failed: %v", id, rerr)
+			return errors.Errorf("%v (repair using parity data failed: %v)", err, rerr)
+		}
+
+		// make sure the repaired pack is really intact
+		rerr = checkPack(ctx, c.repo, id)
+		if rerr != nil {
+			return rerr
+		}
+
+		return PackError{ID: id, Repaired: true, Err: errors.Errorf("repaired using parity data: %v", err)}
+	}
+
+	fi, err := c.repo.Backend().Stat(ctx, restic.Handle{Type: restic.PackFile, Name: id.String()})
+	if err != nil {
+		return PackError{ID: id, Err: err}
+	}
+
+	repaired, err := repository.CheckParity(ctx, c.repo, id, fi.Size)
+	if err != nil {
+		return PackError{ID: id, Err: errors.Wrap(err, "checking parity")}
+	}
+
+	if repaired {
+		return PackError{ID: id, Repaired: true, Err: errors.New("missing or damaged parity file was created again")}
+	}
+
+	return nil
+}
+
 // ReadData loads all data from the repository and checks the integrity.
 func (c *Checker) ReadData(ctx context.Context, p *restic.Progress, errChan chan<- error) {
 	c.ReadPacks(ctx, c.packs, p, errChan)
@@ -803,6 +861,9 @@ func (c *Checker) ReadPacks(ctx context.Context, packs restic.IDSet, p *restic.P
 				}
 
 				err := checkPack(ctx, c.repo, id)
+				if c.repo.Config().Parity != 0 && !c.NoRepair {
+					err = c.checkParity(ctx, id, err)
+				}
 				p.Report(restic.Stat{Blobs: 1})
 				if err == nil {
 					continue
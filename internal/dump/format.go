@@ -0,0 +1,90 @@
+package dump
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Format is the archive format used to dump a tree.
+type Format int
+
+const (
+	// FormatTar writes an uncompressed tar archive.
+	FormatTar Format = iota
+	// FormatTarGzip writes a gzip compressed tar archive.
+	FormatTarGzip
+	// FormatTarZstd writes a zstd compressed tar archive.
+	FormatTarZstd
+	// FormatZip writes a zip archive.
+	FormatZip
+)
+
+var formatNames = map[Format]string{
+	FormatTar:     "tar",
+	FormatTarGzip: "tar.gz",
+	FormatTarZstd: "tar.zst",
+	FormatZip:     "zip",
+}
+
+func (f Format) String() string {
+	if name, ok := formatNames[f]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Set parses s and updates f.
+func (f *Format) Set(s string) error {
+	for format, name := range formatNames {
+		if name == s {
+			*f = format
+			return nil
+		}
+	}
+	return errors.Errorf("invalid archive format %q, must be one of tar, tar.gz, tar.zst or zip", s)
+}
+
+// Type returns the type of Format, usable within github.com/spf13/pflag and
+// in help texts.
+func (f Format) Type() string {
+	return "format"
+}
+
+// Write writes the contents of the given tree as an archive in the given
+// format to dst.
+func Write(ctx context.Context, repo restic.Repository, tree *restic.Tree, rootPath string, format Format, dst io.Writer) error {
+	switch format {
+	case FormatTar:
+		return WriteTar(ctx, repo, tree, rootPath, dst)
+	case FormatZip:
+		return WriteZip(ctx, repo, tree, rootPath, dst)
+	}
+
+	var zw io.WriteCloser
+	switch format {
+	case FormatTarGzip:
+		zw = gzip.NewWriter(dst)
+	case FormatTarZstd:
+		enc, err := zstd.NewWriter(dst)
+		if err != nil {
+			return errors.Wrap(err, "zstd.NewWriter")
+		}
+		zw = enc
+	default:
+		return errors.Errorf("unknown archive format %v", format)
+	}
+
+	err := WriteTar(ctx, repo, tree, rootPath, zw)
+	if err != nil {
+		_ = zw.Close()
+		return err
+	}
+
+	return errors.Wrap(zw.Close(), "Close")
+}
@@ -0,0 +1,64 @@
+package dump
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+func TestWriteFormat(t *testing.T) {
+	decompress := map[Format]func(io.Reader) (io.Reader, error){
+		FormatTarGzip: func(rd io.Reader) (io.Reader, error) {
+			return gzip.NewReader(rd)
+		},
+		FormatTarZstd: func(rd io.Reader) (io.Reader, error) {
+			return zstd.NewReader(rd)
+		},
+	}
+
+	for format, fn := range decompress {
+		format, fn := format, fn
+		t.Run(format.String(), func(t *testing.T) {
+			write := func(ctx context.Context, repo restic.Repository, tree *restic.Tree, rootPath string, dst io.Writer) error {
+				return Write(ctx, repo, tree, rootPath, format, dst)
+			}
+
+			check := func(t *testing.T, testDir string, dst *bytes.Buffer) error {
+				rd, err := fn(dst)
+				if err != nil {
+					return err
+				}
+				buf := &bytes.Buffer{}
+				if _, err := io.Copy(buf, rd); err != nil {
+					return err
+				}
+				return checkTar(t, testDir, buf)
+			}
+
+			writeTest(t, write, check)
+		})
+	}
+}
+
+func TestFormatSet(t *testing.T) {
+	for format, name := range formatNames {
+		var f Format
+		if err := f.Set(name); err != nil {
+			t.Fatal(err)
+		}
+		if f != format {
+			t.Errorf("Set(%q) = %v, want %v", name, f, format)
+		}
+	}
+
+	var f Format
+	if err := f.Set("rar"); err == nil {
+		t.Error("Set(\"rar\") did not return an error")
+	}
+}
@@ -0,0 +1,65 @@
+package dump
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+type zipDumper struct {
+	w *zip.Writer
+}
+
+// Statically ensure that zipDumper implements dumper.
+var _ dumper = zipDumper{}
+
+// WriteZip will write the contents of the given tree, encoded as a zip to the given destination.
+// It will loop over all nodes in the tree and dump them recursively.
+func WriteZip(ctx context.Context, repo restic.Repository, tree *restic.Tree, rootPath string, dst io.Writer) error {
+	dmp := zipDumper{w: zip.NewWriter(dst)}
+
+	return writeDump(ctx, repo, tree, rootPath, dmp)
+}
+
+func (dmp zipDumper) Close() error {
+	return dmp.w.Close()
+}
+
+func (dmp zipDumper) dumpNode(ctx context.Context, node *restic.Node, repo restic.Repository) error {
+	relPath, err := filepath.Rel("/", node.Path)
+	if err != nil {
+		return err
+	}
+
+	header := &zip.FileHeader{
+		Name:               filepath.ToSlash(relPath),
+		UncompressedSize64: node.Size,
+		Modified:           node.ModTime,
+	}
+	header.SetMode(node.Mode)
+
+	// Files are compressed, directories and symlinks are stored. The target
+	// of a symlink is stored as its content, the same way Info-ZIP does it.
+	switch {
+	case IsDir(node):
+		header.Name += "/"
+	case IsFile(node):
+		header.Method = zip.Deflate
+	}
+
+	w, err := dmp.w.CreateHeader(header)
+	if err != nil {
+		return errors.Wrap(err, "ZipHeader")
+	}
+
+	if IsLink(node) {
+		_, err = w.Write([]byte(node.LinkTarget))
+		return errors.Wrap(err, "Write")
+	}
+
+	return GetNodeData(ctx, w, repo, node)
+}
@@ -0,0 +1,116 @@
+package dump
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteZip(t *testing.T) {
+	writeTest(t, WriteZip, checkZip)
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rd, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := ioutil.ReadAll(rd)
+	if err != nil {
+		_ = rd.Close()
+		return nil, err
+	}
+
+	return buf, rd.Close()
+}
+
+func checkZip(t *testing.T, testDir string, srcZip *bytes.Buffer) error {
+	z, err := zip.NewReader(bytes.NewReader(srcZip.Bytes()), int64(srcZip.Len()))
+	if err != nil {
+		return err
+	}
+
+	fileNumber := 0
+	zipFiles := len(z.File)
+
+	err = filepath.Walk(testDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() != filepath.Base(testDir) {
+			fileNumber++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range z.File {
+		matchPath := filepath.Join(testDir, f.Name)
+		match, err := os.Lstat(matchPath)
+		if err != nil {
+			return err
+		}
+
+		// check metadata, zip header contains time truncated to seconds
+		fileTime := match.ModTime().Truncate(time.Second)
+		zipTime := f.Modified
+		if !fileTime.Equal(zipTime) {
+			return fmt.Errorf("modTime does not match, got: %s, want: %s", zipTime, fileTime)
+		}
+		if f.Mode() != match.Mode() {
+			return fmt.Errorf("mode does not match, got: %v, want: %v", f.Mode(), match.Mode())
+		}
+
+		switch {
+		case f.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(matchPath)
+			if err != nil {
+				return err
+			}
+			linkName, err := readZipFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(linkName) != target {
+				return fmt.Errorf("symlink target does not match, got %s want %s", linkName, target)
+			}
+		case f.FileInfo().IsDir():
+			if !strings.HasSuffix(f.Name, "/") {
+				return fmt.Errorf("foldername %v does not end with a slash", f.Name)
+			}
+			if filepath.Base(f.Name) != match.Name() {
+				return fmt.Errorf("foldernames don't match got %v want %v", filepath.Base(f.Name), match.Name())
+			}
+		default:
+			if uint64(match.Size()) != f.UncompressedSize64 {
+				return fmt.Errorf("size does not match got %v want %v", f.UncompressedSize64, match.Size())
+			}
+			contentsFile, err := ioutil.ReadFile(matchPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contentsZip, err := readZipFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(contentsZip, contentsFile) {
+				return fmt.Errorf("contents does not match, got %s want %s", contentsZip, contentsFile)
+			}
+		}
+	}
+
+	if zipFiles != fileNumber {
+		return fmt.Errorf("not the same amount of files got %v want %v", zipFiles, fileNumber)
+	}
+
+	return nil
+}
@@ -2,4 +2,5 @@
 // in contrast to filepath.Glob a pattern may specify directories.
 //
 // For a list of valid patterns please see the documentation on filepath.Glob.
+// Lists of patterns may contain negated patterns starting with '!', see List.
 package filter
@@ -0,0 +1,520 @@
+package filter
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// Attributes are the properties of a file which can be used in an expression.
+type Attributes struct {
+	// Path is the path of the file, it is matched with '/' as the separator.
+	Path string
+	// Type is one of "file", "dir", "symlink", "dev", "chardev", "fifo" and
+	// "socket".
+	Type    string
+	Mode    os.FileMode
+	Size    uint64
+	ModTime time.Time
+	UID     uint32
+	GID     uint32
+	User    string
+	Group   string
+}
+
+// Expr is a compiled expression which selects files based on their
+// attributes. An expression consists of conditions of the form
+// "field operator value", which can be combined with "and", "or" and "not"
+// and grouped with parentheses. "and" binds stronger than "or". Values
+// containing spaces, parentheses or operators must be quoted with double
+// quotes, within quotes \" and \\ stand for a quote and a backslash.
+//
+// The following fields are supported:
+//
+//   - path, name: the path and the base name of the file. "==" and "!="
+//     match a pattern like --exclude, "=~" and "!~" match a regular
+//     expression.
+//   - type: the file type, "==" and "!=" are supported.
+//   - size: the file size, a suffix k, m, g or t selects the unit.
+//   - age: the time since the last modification, a suffix s, m, h, d, w or y
+//     selects the unit, the default unit is days.
+//   - mtime: the time of the last modification, e.g. 2020-01-31 or
+//     "2020-01-31 12:00:00".
+//   - uid, gid: the numeric owner and group.
+//   - user, group: the name of the owner and group, "==" and "!=" are
+//     supported.
+//   - perm: the permission bits in octal, including the setuid (4000),
+//     setgid (2000) and sticky (1000) bits. "has" matches if all given bits
+//     are set.
+//
+// Numbers, sizes and times support the operators "==", "!=", "<", "<=", ">"
+// and ">=". For example, the following expression matches files below
+// /scratch which have not been modified for two years and are owned by a user
+// with a uid greater than 10000:
+//
+//	path == /scratch and age > 2y and uid > 10000
+type Expr struct {
+	src       string
+	match     func(*Attributes) bool
+	testsType bool
+}
+
+// ParseExpr compiles the expression s. Conditions on the age of a file are
+// evaluated relative to now.
+func ParseExpr(s string, now time.Time) (*Expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, errors.Errorf("invalid expression %q: %v", s, err)
+	}
+
+	p := &exprParser{tokens: tokens, now: now}
+	match, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = errors.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, errors.Errorf("invalid expression %q: %v", s, err)
+	}
+
+	return &Expr{src: s, match: match, testsType: p.testsType}, nil
+}
+
+// Match returns true if the file with the attributes a is selected by the
+// expression.
+func (e *Expr) Match(a *Attributes) bool {
+	return e.match(a)
+}
+
+// TestsType returns true if the expression contains a condition on the type
+// of a file.
+func (e *Expr) TestsType() bool {
+	return e.testsType
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOperator
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+const operatorChars = "=!<>~"
+
+var operators = []string{"==", "!=", "<=", ">=", "=~", "!~", "=", "<", ">"}
+
+// tokenize splits the expression s into tokens.
+func tokenize(s string) (tokens []token, err error) {
+	for len(s) > 0 {
+		c := rune(s[0])
+		switch {
+		case unicode.IsSpace(c):
+			s = s[1:]
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "("})
+			s = s[1:]
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")"})
+			s = s[1:]
+		case c == '"':
+			// only \" and \\ are escape sequences, other backslashes are
+			// kept, so that regular expressions can be written as usual
+			var str strings.Builder
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' && end+1 < len(s) && (s[end+1] == '"' || s[end+1] == '\\') {
+					end++
+				}
+				str.WriteByte(s[end])
+				end++
+			}
+			if end >= len(s) {
+				return nil, errors.New("unterminated string")
+			}
+
+			tokens = append(tokens, token{kind: tokenString, text: str.String()})
+			s = s[end+1:]
+		case strings.ContainsRune(operatorChars, c):
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(s, op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op})
+					s = s[len(op):]
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, errors.Errorf("invalid operator %q", c)
+			}
+		default:
+			end := strings.IndexFunc(s, func(r rune) bool {
+				return unicode.IsSpace(r) || r == '(' || r == ')' || strings.ContainsRune(operatorChars, r)
+			})
+			if end < 0 {
+				end = len(s)
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: s[:end]})
+			s = s[end:]
+		}
+	}
+
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens    []token
+	pos       int
+	now       time.Time
+	testsType bool
+}
+
+// next returns the next token, or nil at the end of the expression.
+func (p *exprParser) next() *token {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	t := &p.tokens[p.pos]
+	p.pos++
+	return t
+}
+
+// keyword returns true and consumes the next token if it is the keyword kw.
+func (p *exprParser) keyword(kw string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenWord && strings.EqualFold(p.tokens[p.pos].text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (func(*Attributes) bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(a *Attributes) bool { return l(a) || right(a) }
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (func(*Attributes) bool, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(a *Attributes) bool { return l(a) && right(a) }
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (func(*Attributes) bool, error) {
+	if p.keyword("not") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(a *Attributes) bool { return !inner(a) }, nil
+	}
+
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOpen {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		t := p.next()
+		if t == nil || t.kind != tokenClose {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		return inner, nil
+	}
+
+	return p.parseCondition()
+}
+
+func (p *exprParser) parseCondition() (func(*Attributes) bool, error) {
+	field := p.next()
+	if field == nil {
+		return nil, errors.New("unexpected end of expression")
+	}
+	if field.kind != tokenWord {
+		return nil, errors.Errorf("expected field name, got %q", field.text)
+	}
+
+	op := p.next()
+	switch {
+	case op == nil:
+		return nil, errors.Errorf("missing operator after %q", field.text)
+	case op.kind == tokenWord && strings.EqualFold(op.text, "has"):
+		op.text = "has"
+	case op.kind != tokenOperator:
+		return nil, errors.Errorf("expected operator after %q, got %q", field.text, op.text)
+	case op.text == "=":
+		op.text = "=="
+	}
+
+	value := p.next()
+	if value == nil || (value.kind != tokenWord && value.kind != tokenString) {
+		return nil, errors.Errorf("missing value for %q", field.text)
+	}
+
+	name := strings.ToLower(field.text)
+	if name == "type" {
+		p.testsType = true
+	}
+
+	return compileCondition(name, op.text, value.text, p.now)
+}
+
+// compileCondition returns a function which evaluates the condition.
+func compileCondition(field, op, value string, now time.Time) (func(*Attributes) bool, error) {
+	switch field {
+	case "path", "name":
+		get := func(a *Attributes) string { return filepath.ToSlash(a.Path) }
+		if field == "name" {
+			get = func(a *Attributes) string { return path.Base(filepath.ToSlash(a.Path)) }
+		}
+		return compileStringMatch(field, op, value, get)
+
+	case "type":
+		switch value {
+		case "file", "dir", "symlink", "dev", "chardev", "fifo", "socket":
+		default:
+			return nil, errors.Errorf("invalid file type %q", value)
+		}
+		return compileEquality(field, op, func(a *Attributes) bool { return a.Type == value })
+
+	case "user":
+		return compileEquality(field, op, func(a *Attributes) bool { return a.User == value })
+
+	case "group":
+		return compileEquality(field, op, func(a *Attributes) bool { return a.Group == value })
+
+	case "uid", "gid":
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, errors.Errorf("invalid %v %q", field, value)
+		}
+		get := func(a *Attributes) int64 { return int64(a.UID) }
+		if field == "gid" {
+			get = func(a *Attributes) int64 { return int64(a.GID) }
+		}
+		return compileCompare(field, op, int64(n), get)
+
+	case "size":
+		size, err := parseExprSize(value)
+		if err != nil {
+			return nil, err
+		}
+		return compileCompare(field, op, size, func(a *Attributes) int64 { return int64(a.Size) })
+
+	case "age":
+		d, err := parseExprAge(value)
+		if err != nil {
+			return nil, err
+		}
+		return compileCompare(field, op, int64(d), func(a *Attributes) int64 { return int64(now.Sub(a.ModTime)) })
+
+	case "mtime":
+		t, err := parseExprTime(value)
+		if err != nil {
+			return nil, err
+		}
+		return compileCompare(field, op, t.UnixNano(), func(a *Attributes) int64 { return a.ModTime.UnixNano() })
+
+	case "perm":
+		perm, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || perm > 07777 {
+			return nil, errors.Errorf("invalid permissions %q, must be octal", value)
+		}
+		if op == "has" {
+			return func(a *Attributes) bool { return unixPerm(a.Mode)&uint32(perm) == uint32(perm) }, nil
+		}
+		return compileCompare(field, op, int64(perm), func(a *Attributes) int64 { return int64(unixPerm(a.Mode)) })
+	}
+
+	return nil, errors.Errorf("unknown field %q", field)
+}
+
+// compileStringMatch returns a function which matches the string returned by
+// get against a pattern or a regular expression.
+func compileStringMatch(field, op, value string, get func(*Attributes) string) (func(*Attributes) bool, error) {
+	switch op {
+	case "==", "!=":
+		// check the pattern once, errors are ignored afterwards
+		if _, err := Match(value, "x"); err != nil {
+			return nil, errors.Errorf("invalid pattern %q: %v", value, err)
+		}
+		return compileEquality(field, op, func(a *Attributes) bool {
+			matched, _ := Match(value, get(a))
+			return matched
+		})
+
+	case "=~", "!~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, errors.Errorf("invalid regular expression %q: %v", value, err)
+		}
+		negate := op == "!~"
+		return func(a *Attributes) bool { return re.MatchString(get(a)) != negate }, nil
+	}
+
+	return nil, errors.Errorf("operator %q is not supported for %v", op, field)
+}
+
+// compileEquality returns a function for the operators "==" and "!=".
+func compileEquality(field, op string, equal func(*Attributes) bool) (func(*Attributes) bool, error) {
+	switch op {
+	case "==":
+		return equal, nil
+	case "!=":
+		return func(a *Attributes) bool { return !equal(a) }, nil
+	}
+
+	return nil, errors.Errorf("operator %q is not supported for %v", op, field)
+}
+
+// compileCompare returns a function which compares the value returned by get
+// with value.
+func compileCompare(field, op string, value int64, get func(*Attributes) int64) (func(*Attributes) bool, error) {
+	switch op {
+	case "==":
+		return func(a *Attributes) bool { return get(a) == value }, nil
+	case "!=":
+		return func(a *Attributes) bool { return get(a) != value }, nil
+	case "<":
+		return func(a *Attributes) bool { return get(a) < value }, nil
+	case "<=":
+		return func(a *Attributes) bool { return get(a) <= value }, nil
+	case ">":
+		return func(a *Attributes) bool { return get(a) > value }, nil
+	case ">=":
+		return func(a *Attributes) bool { return get(a) >= value }, nil
+	}
+
+	return nil, errors.Errorf("operator %q is not supported for %v", op, field)
+}
+
+// unixPerm returns the permission bits of mode as used by chmod.
+func unixPerm(mode os.FileMode) uint32 {
+	perm := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		perm |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		perm |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		perm |= 01000
+	}
+	return perm
+}
+
+// parseExprNumber splits s into a number and a unit suffix.
+func parseExprNumber(s string) (int64, string, error) {
+	end := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		end = len(s)
+	}
+
+	n, err := strconv.ParseInt(s[:end], 10, 64)
+	if err != nil {
+		return 0, "", errors.Errorf("invalid number %q", s)
+	}
+	return n, strings.ToLower(s[end:]), nil
+}
+
+func parseExprSize(s string) (int64, error) {
+	n, unit, err := parseExprNumber(s)
+	if err != nil {
+		return 0, err
+	}
+
+	switch unit {
+	case "", "b":
+		return n, nil
+	case "k":
+		return n << 10, nil
+	case "m":
+		return n << 20, nil
+	case "g":
+		return n << 30, nil
+	case "t":
+		return n << 40, nil
+	}
+
+	return 0, errors.Errorf("invalid size %q", s)
+}
+
+func parseExprAge(s string) (time.Duration, error) {
+	n, unit, err := parseExprNumber(s)
+	if err != nil {
+		return 0, err
+	}
+
+	const day = 24 * time.Hour
+	units := map[string]time.Duration{
+		"s": time.Second,
+		"m": time.Minute,
+		"h": time.Hour,
+		"":  day,
+		"d": day,
+		"w": 7 * day,
+		"y": 365 * day,
+	}
+
+	d, ok := units[unit]
+	if !ok {
+		return 0, errors.Errorf("invalid age %q", s)
+	}
+	return time.Duration(n) * d, nil
+}
+
+func parseExprTime(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("invalid time %q", s)
+}
@@ -0,0 +1,134 @@
+package filter
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExpr(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.Local)
+
+	old := &Attributes{
+		Path:    "/scratch/data/results.csv",
+		Type:    "file",
+		Mode:    0644,
+		Size:    3 * 1024 * 1024,
+		ModTime: now.AddDate(-3, 0, 0),
+		UID:     10042,
+		GID:     100,
+		User:    "alice",
+		Group:   "users",
+	}
+
+	recent := &Attributes{
+		Path:    "/scratch/tmp",
+		Type:    "dir",
+		Mode:    os.ModeDir | os.ModeSticky | 0777,
+		ModTime: now.Add(-time.Hour),
+		UID:     0,
+		GID:     0,
+		User:    "root",
+		Group:   "root",
+	}
+
+	var tests = []struct {
+		expr   string
+		old    bool
+		recent bool
+	}{
+		{`path == /scratch and age > 2y and uid > 10000`, true, false},
+		{`path == /home and age > 2y`, false, false},
+		{`path =~ "^/scratch/.*\.csv$"`, true, false},
+		{`path !~ csv`, false, true},
+		{`name == "*.csv"`, true, false},
+		{`name = tmp`, false, true},
+		{`type == dir`, false, true},
+		{`type != dir`, true, false},
+		{`size >= 3m`, true, false},
+		{`size < 3145728`, false, true},
+		{`age < 2h`, false, true},
+		{`age > 30`, true, false},
+		{`mtime < 2020-01-01`, true, false},
+		{`mtime >= "2020-06-01 10:30"`, false, true},
+		{`uid == 0 and gid == 0`, false, true},
+		{`user == alice or group == root`, true, true},
+		{`user != alice`, false, true},
+		{`perm == 644`, true, false},
+		{`perm has 1002`, false, true},
+		{`perm has 0600`, true, true},
+		{`not type == dir`, true, false},
+		{`NOT (type == dir or size > 1m)`, false, false},
+		{`type == file and (uid < 100 or size > 1m)`, true, false},
+		{`type == dir or type == file and size == 0`, false, true},
+		{`not not age < 1d`, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			expr, err := ParseExpr(test.expr, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res := expr.Match(old); res != test.old {
+				t.Errorf("wrong result for %v: want %v, got %v", old.Path, test.old, res)
+			}
+			if res := expr.Match(recent); res != test.recent {
+				t.Errorf("wrong result for %v: want %v, got %v", recent.Path, test.recent, res)
+			}
+		})
+	}
+}
+
+func TestExprInvalid(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`size`,
+		`size >`,
+		`size > 10x`,
+		`foo == bar`,
+		`type == folder`,
+		`type < file`,
+		`user > alice`,
+		`uid == -1`,
+		`perm == 999`,
+		`perm has 10000`,
+		`age > 2 years`,
+		`mtime < yesterday`,
+		`path =~ "("`,
+		`path == "[a"`,
+		`name == "unterminated`,
+		`(type == dir`,
+		`type == dir)`,
+		`type == dir and`,
+		`type == dir size > 0`,
+		`size ~ 10`,
+	} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := ParseExpr(expr, time.Now())
+			if err == nil {
+				t.Errorf("expression %q parsed without error", expr)
+			}
+		})
+	}
+}
+
+func TestExprTestsType(t *testing.T) {
+	for expr, want := range map[string]bool{
+		`age > 2y`:                          false,
+		`path == /scratch and uid > 10`:     false,
+		`type == file`:                      true,
+		`size > 1m or TYPE != dir`:          true,
+		`not (name == foo and type == dir)`: true,
+	} {
+		e, err := ParseExpr(expr, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.TestsType() != want {
+			t.Errorf("wrong result for %q: want %v, got %v", expr, want, e.TestsType())
+		}
+	}
+}
@@ -161,8 +161,20 @@ func match(patterns, strs []string) (matched bool, err error) {
 
 // List returns true if str matches one of the patterns. Empty patterns are
 // ignored.
+//
+// A pattern starting with '!' is negated: a path matched by it is not matched
+// by the list, even if an earlier pattern matches it. The last matching
+// pattern decides, so a path matched by a negated pattern can be matched again
+// by a later pattern. A leading '!' which is part of the pattern itself must
+// be escaped as '\!'.
+//
+// childMayMatch is true if children of str may be matched by the list. As a
+// pattern which matches a directory also matches all its children, this is
+// only the case for patterns following the last negated pattern which matches
+// str.
 func List(patterns []string, str string) (matched bool, childMayMatch bool, err error) {
 	for _, pat := range patterns {
+		pat, negated := parseNegation(pat)
 		if pat == "" {
 			continue
 		}
@@ -172,6 +184,14 @@ func List(patterns []string, str string) (matched bool, childMayMatch bool, err
 			return false, false, err
 		}
 
+		if negated {
+			if m {
+				matched = false
+				childMayMatch = false
+			}
+			continue
+		}
+
 		c, err := ChildMatch(pat, str)
 		if err != nil {
 			return false, false, err
@@ -179,11 +199,20 @@ func List(patterns []string, str string) (matched bool, childMayMatch bool, err
 
 		matched = matched || m
 		childMayMatch = childMayMatch || c
-
-		if matched && childMayMatch {
-			return true, true, nil
-		}
 	}
 
 	return matched, childMayMatch, nil
 }
+
+// parseNegation removes a leading '!' from the pattern and reports whether the
+// pattern was negated. An escaped '\!' is replaced by '!'.
+func parseNegation(pattern string) (string, bool) {
+	switch {
+	case strings.HasPrefix(pattern, "!"):
+		return pattern[1:], true
+	case strings.HasPrefix(pattern, `\!`):
+		return pattern[1:], false
+	}
+
+	return pattern, false
+}
@@ -254,6 +254,17 @@ var filterListTests = []struct {
 	{[]string{"/*/*/bar/test.*"}, "/foo/bar/test.go", false},
 	{[]string{"/*/*/bar/test.*", "*.go"}, "/foo/bar/test.go", true},
 	{[]string{"", "*.c"}, "/foo/bar/test.go", false},
+	{[]string{"*.go", "!test.go"}, "/foo/bar/test.go", false},
+	{[]string{"*.go", "!test.go"}, "/foo/bar/main.go", true},
+	{[]string{"!test.go", "*.go"}, "/foo/bar/test.go", true},
+	{[]string{"/home/*/.cache/*", "!/home/alice/.cache/important"}, "/home/bob/.cache/foo", true},
+	{[]string{"/home/*/.cache/*", "!/home/alice/.cache/important"}, "/home/alice/.cache/foo", true},
+	{[]string{"/home/*/.cache/*", "!/home/alice/.cache/important"}, "/home/alice/.cache/important", false},
+	{[]string{"/home/*/.cache/*", "!/home/alice/.cache/important"}, "/home/alice/.cache/important/file", false},
+	{[]string{"/home", "!/home/bob", "/home/bob/keep"}, "/home/bob/keep", true},
+	{[]string{"!"}, "/foo/bar/test.go", false},
+	{[]string{`\!test.go`}, "/foo/bar/!test.go", true},
+	{[]string{`\!test.go`}, "/foo/bar/test.go", false},
 }
 
 func TestList(t *testing.T) {
@@ -272,6 +283,33 @@ func TestList(t *testing.T) {
 	}
 }
 
+func TestListChildMayMatch(t *testing.T) {
+	var tests = []struct {
+		patterns      []string
+		path          string
+		childMayMatch bool
+	}{
+		{[]string{"/home/alice"}, "/home", true},
+		{[]string{"/home/alice"}, "/usr", false},
+		{[]string{"/home", "!/home/bob"}, "/home/bob", false},
+		{[]string{"/home", "!/home/bob"}, "/home/alice", true},
+		{[]string{"/home", "!/home/bob", "/home/bob/keep"}, "/home/bob", true},
+		{[]string{"!/home/bob", "/home"}, "/home/bob", true},
+	}
+
+	for i, test := range tests {
+		_, childMayMatch, err := filter.List(test.patterns, test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if childMayMatch != test.childMayMatch {
+			t.Errorf("test %d: filter.List(%q, %q): expected childMayMatch %v, got %v",
+				i, test.patterns, test.path, test.childMayMatch, childMayMatch)
+		}
+	}
+}
+
 func ExampleList() {
 	match, _, _ := filter.List([]string{"*.c", "*.go"}, "/home/user/file.go")
 	fmt.Printf("match: %v\n", match)
@@ -0,0 +1,147 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// ignorePattern is a single pattern from an ignore file.
+type ignorePattern struct {
+	pattern []string
+	negate  bool
+	dirOnly bool
+}
+
+// IgnoreList contains the patterns from an ignore file, which are interpreted
+// like the patterns in a .gitignore file:
+//
+//   - Empty lines and lines starting with '#' are ignored.
+//   - A pattern starting with '!' re-includes files excluded by an earlier
+//     pattern, the last matching pattern decides.
+//   - A pattern ending with '/' only matches directories.
+//   - A pattern containing a '/' (except at the end) is relative to the
+//     directory the ignore file was read from, otherwise it matches files with
+//     that name in the directory and all subdirectories.
+//   - '**' matches an arbitrary number of intermediate directories.
+type IgnoreList struct {
+	dir      string
+	patterns []ignorePattern
+}
+
+// ParseIgnoreList parses the contents of an ignore file read from the
+// directory dir.
+func ParseIgnoreList(dir string, data []byte) (*IgnoreList, error) {
+	list := &IgnoreList{dir: dir}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		// trailing spaces are removed unless they are escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var pat ignorePattern
+		if strings.HasPrefix(line, "!") {
+			pat.negate = true
+			line = line[1:]
+		}
+
+		// a leading backslash escapes '#' and '!'
+		if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			pat.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+
+		if line == "" {
+			continue
+		}
+
+		anchored := strings.Contains(line, "/")
+		pat.pattern = strings.Split(strings.TrimLeft(line, "/"), "/")
+		if !anchored {
+			pat.pattern = append([]string{"**"}, pat.pattern...)
+		}
+
+		for _, p := range pat.pattern {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return nil, errors.Errorf("invalid pattern %q: %v", line, err)
+			}
+		}
+
+		list.patterns = append(list.patterns, pat)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Match returns whether the file or directory at path is matched by one of the
+// patterns, and if so, whether it is ignored. Paths outside of the directory
+// of the ignore file never match.
+func (l *IgnoreList) Match(path string, isDir bool) (matched, ignored bool) {
+	rel, err := filepath.Rel(l.dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false, false
+	}
+	strs := strings.Split(filepath.ToSlash(rel), "/")
+
+	// later patterns override earlier ones
+	for i := len(l.patterns) - 1; i >= 0; i-- {
+		pat := l.patterns[i]
+		if pat.dirOnly && !isDir {
+			continue
+		}
+
+		if matchSegments(pat.pattern, strs) {
+			return true, !pat.negate
+		}
+	}
+
+	return false, false
+}
+
+// matchSegments returns true if all path segments in strs are matched by the
+// pattern segments, '**' matches zero or more segments.
+func matchSegments(patterns, strs []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			for i := 0; i <= len(strs); i++ {
+				if matchSegments(patterns[1:], strs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(strs) == 0 {
+			return false
+		}
+
+		// the patterns have been checked in ParseIgnoreList
+		ok, _ := filepath.Match(patterns[0], strs[0])
+		if !ok {
+			return false
+		}
+
+		patterns, strs = patterns[1:], strs[1:]
+	}
+
+	return len(strs) == 0
+}
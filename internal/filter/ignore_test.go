@@ -0,0 +1,80 @@
+package filter
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreList(t *testing.T) {
+	const ignoreFile = `
+# comment
+*.o
+!keep.o
+build/
+/root-only
+doc/*.html
+a/**/z
+\#literal
+\!bang
+trailing   
+`
+
+	var tests = []struct {
+		path    string
+		isDir   bool
+		matched bool
+		ignored bool
+	}{
+		{"foo.o", false, true, true},
+		{"sub/dir/foo.o", false, true, true},
+		{"keep.o", false, true, false},
+		{"sub/keep.o", false, true, false},
+		{"foo.c", false, false, false},
+		{"build", true, true, true},
+		{"sub/build", true, true, true},
+		{"build", false, false, false},
+		{"root-only", false, true, true},
+		{"sub/root-only", false, false, false},
+		{"doc/index.html", false, true, true},
+		{"doc/sub/index.html", false, false, false},
+		{"sub/doc/index.html", false, false, false},
+		{"a/z", false, true, true},
+		{"a/b/c/z", false, true, true},
+		{"b/a/z", false, false, false},
+		{"#literal", false, true, true},
+		{"!bang", false, true, true},
+		{"trailing", false, true, true},
+		{"comment", false, false, false},
+	}
+
+	base := filepath.FromSlash("/home/user/project")
+	list, err := ParseIgnoreList(base, []byte(ignoreFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			matched, ignored := list.Match(filepath.Join(base, filepath.FromSlash(test.path)), test.isDir)
+			if matched != test.matched || ignored != test.ignored {
+				t.Errorf("wrong result for %v: want (%v, %v), got (%v, %v)",
+					test.path, test.matched, test.ignored, matched, ignored)
+			}
+		})
+	}
+
+	// paths outside of the directory never match
+	for _, path := range []string{"/home/user/foo.o", "/home/user/project", "/other/foo.o"} {
+		matched, _ := list.Match(filepath.FromSlash(path), false)
+		if matched {
+			t.Errorf("path %v outside of the directory matched", path)
+		}
+	}
+}
+
+func TestIgnoreListInvalidPattern(t *testing.T) {
+	_, err := ParseIgnoreList("/", []byte("foo\n[bar\n"))
+	if err == nil {
+		t.Fatal("invalid pattern was accepted")
+	}
+}
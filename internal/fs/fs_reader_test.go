@@ -443,3 +443,59 @@ func TestFSReaderMinFileSize(t *testing.T) {
 		})
 	}
 }
+
+func TestFSMultiReader(t *testing.T) {
+	now := time.Now()
+	files := map[string][]byte{
+		"/foo":         test.Random(23, 1000),
+		"/sub/dir/bar": test.Random(42, 2000),
+	}
+
+	fs := &MultiReader{}
+	for name, data := range files {
+		fs.Readers = append(fs.Readers, &Reader{
+			Name:       name,
+			ReadCloser: ioutil.NopCloser(bytes.NewReader(data)),
+			Mode:       0644,
+			Size:       int64(len(data)),
+			ModTime:    now,
+		})
+	}
+
+	for _, dir := range []string{"/", "/sub", "/sub/dir"} {
+		fi, err := fs.Lstat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkFileInfo(t, fi, dir, time.Time{}, os.ModeDir|0755, true)
+	}
+
+	for name := range files {
+		fi, err := fs.Lstat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.IsDir() || fi.Mode() != 0644 || fi.Size() != int64(len(files[name])) {
+			t.Errorf("Lstat(%v) returned wrong FileInfo %#v", name, fi)
+		}
+	}
+
+	_, err := fs.Lstat("/other")
+	if !os.IsNotExist(err) {
+		t.Fatalf("Lstat() for missing file returned unexpected error %v", err)
+	}
+
+	verifyFileContentOpen(t, fs, "/foo", files["/foo"])
+	verifyFileContentOpenFile(t, fs, "/sub/dir/bar", files["/sub/dir/bar"])
+
+	// files can only be opened once
+	_, err = fs.Open("/foo")
+	if err == nil {
+		t.Fatal("second Open() for the same file did not return an error")
+	}
+
+	_, err = fs.Open("/other")
+	if err == nil {
+		t.Fatal("Open() for missing file did not return an error")
+	}
+}
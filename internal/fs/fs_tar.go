@@ -0,0 +1,393 @@
+package fs
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// Tar is a file system which provides the files contained in a tar archive.
+// All headers are read from the archive when the file system is created, the
+// content of a file is read from the archive when the file is opened.
+// Directories which are not contained in the archive are created implicitly.
+type Tar struct {
+	rd    io.ReaderAt
+	size  int64
+	files map[string]*tarFile
+	links map[uint64]uint64
+}
+
+// statically ensure that Tar implements FS.
+var _ FS = &Tar{}
+
+// TarHeader is returned by the Sys() method of the os.FileInfo for files
+// contained in the Tar file system.
+type TarHeader struct {
+	*tar.Header
+
+	// Inode is a number which is unique for each file in the archive, it is
+	// shared by all hardlinks to the same file. Links is the number of
+	// hardlinks to the file.
+	Inode uint64
+	Links uint64
+}
+
+type tarFile struct {
+	// header is nil for implicitly created directories
+	header *tar.Header
+	// modtime is the newest modification time of the contents of an
+	// implicitly created directory
+	modtime time.Time
+	// offset is the start of the header for the file within the archive
+	offset  int64
+	inode   uint64
+	entries map[string]struct{}
+}
+
+// countingReader counts the number of bytes read from the underlying reader.
+type countingReader struct {
+	rd io.Reader
+	n  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// tarBlockSize is the size of the blocks of a tar archive, each file (and
+// therefore each header) starts at a multiple of it.
+const tarBlockSize = 512
+
+// NewTar reads all headers from the tar archive rd of the given size.
+func NewTar(rd io.ReaderAt, size int64) (*Tar, error) {
+	fs := &Tar{
+		rd:    rd,
+		size:  size,
+		files: make(map[string]*tarFile),
+		links: make(map[uint64]uint64),
+	}
+
+	cr := &countingReader{rd: io.NewSectionReader(rd, 0, size)}
+	tr := tar.NewReader(cr)
+
+	var offset int64
+	for inode := uint64(1); ; inode++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "tar.Next")
+		}
+
+		err = fs.add(hdr, offset, inode)
+		if err != nil {
+			return nil, err
+		}
+
+		// read the content so that the end of the file is known
+		_, err = io.Copy(ioutil.Discard, tr)
+		if err != nil {
+			return nil, errors.Wrap(err, "Read")
+		}
+
+		offset = (cr.n + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+	}
+
+	return fs, nil
+}
+
+func (fs *Tar) add(hdr *tar.Header, offset int64, inode uint64) error {
+	switch hdr.Typeflag {
+	case tar.TypeXGlobalHeader:
+		return nil
+	case tar.TypeLink:
+		target, ok := fs.files[path.Join("/", hdr.Linkname)]
+		if !ok || target.header == nil || target.header.Typeflag == tar.TypeLink || !target.header.FileInfo().Mode().IsRegular() {
+			return errors.Errorf("target %q of hardlink %q is not a file in the archive", hdr.Linkname, hdr.Name)
+		}
+
+		// use the content of the target
+		h := *hdr
+		h.Size = target.header.Size
+		hdr = &h
+		offset = target.offset
+		inode = target.inode
+	}
+
+	name := path.Join("/", hdr.Name)
+	if name == "/" {
+		fs.dir(name).header = hdr
+		return nil
+	}
+
+	parent := fs.dir(path.Dir(name))
+	parent.entries[path.Base(name)] = struct{}{}
+
+	file := &tarFile{
+		header: hdr,
+		offset: offset,
+		inode:  inode,
+	}
+	if old, ok := fs.files[name]; ok {
+		// a file stored several times in the archive is replaced by the
+		// last copy, like extracting the archive would do
+		fs.links[old.inode]--
+		if hdr.FileInfo().IsDir() {
+			file.entries = old.entries
+		}
+	}
+	if hdr.FileInfo().IsDir() && file.entries == nil {
+		file.entries = make(map[string]struct{})
+	}
+
+	fs.files[name] = file
+	fs.links[inode]++
+
+	// Implicitly created directories use the newest modification time of
+	// their contents, so that they are the same for each backup of the
+	// same archive.
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if d := fs.files[dir]; hdr.ModTime.After(d.modtime) {
+			d.modtime = hdr.ModTime
+		}
+		if dir == "/" {
+			break
+		}
+	}
+
+	return nil
+}
+
+// dir returns the directory with the given name, it is created implicitly
+// together with its parent directories if it does not exist yet.
+func (fs *Tar) dir(name string) *tarFile {
+	dir, ok := fs.files[name]
+	if !ok {
+		dir = &tarFile{entries: make(map[string]struct{})}
+		fs.files[name] = dir
+
+		if name != "/" {
+			fs.dir(path.Dir(name)).entries[path.Base(name)] = struct{}{}
+		}
+	}
+
+	if dir.entries == nil {
+		// a file is used as a directory by later entries in the archive, it
+		// is replaced by an implicit directory
+		fs.links[dir.inode]--
+		dir.header = nil
+		dir.entries = make(map[string]struct{})
+	}
+
+	return dir
+}
+
+// tarFileInfo is the os.FileInfo for files contained in the Tar file system.
+type tarFileInfo struct {
+	os.FileInfo
+	name string
+	sys  *TarHeader
+}
+
+func (fi tarFileInfo) Name() string {
+	return fi.name
+}
+
+func (fi tarFileInfo) Sys() interface{} {
+	return fi.sys
+}
+
+func (fs *Tar) fi(name string, file *tarFile) os.FileInfo {
+	if file.header == nil {
+		return fakeFileInfo{
+			name:    path.Base(name),
+			mode:    os.ModeDir | 0755,
+			modtime: file.modtime,
+		}
+	}
+
+	return tarFileInfo{
+		FileInfo: file.header.FileInfo(),
+		name:     path.Base(name),
+		sys: &TarHeader{
+			Header: file.header,
+			Inode:  file.inode,
+			Links:  fs.links[file.inode],
+		},
+	}
+}
+
+// extendedTarStat returns the ExtendedFileInfo for a file contained in a tar
+// archive. Missing access and change times are replaced by the modification
+// time.
+func extendedTarStat(fi os.FileInfo, hdr *TarHeader) ExtendedFileInfo {
+	extFI := ExtendedFileInfo{
+		FileInfo: fi,
+		Inode:    hdr.Inode,
+		Links:    hdr.Links,
+		UID:      uint32(hdr.Uid),
+		GID:      uint32(hdr.Gid),
+		Size:     fi.Size(),
+
+		AccessTime: hdr.AccessTime,
+		ModTime:    hdr.ModTime,
+		ChangeTime: hdr.ChangeTime,
+	}
+
+	if extFI.AccessTime.IsZero() {
+		extFI.AccessTime = extFI.ModTime
+	}
+	if extFI.ChangeTime.IsZero() {
+		extFI.ChangeTime = extFI.ModTime
+	}
+
+	return extFI
+}
+
+// VolumeName returns leading volume name, for the Tar file system it's always
+// the empty string.
+func (fs *Tar) VolumeName(path string) string {
+	return ""
+}
+
+// Open opens a file for reading.
+func (fs *Tar) Open(name string) (File, error) {
+	name = path.Join("/", name)
+	file, ok := fs.files[name]
+	if !ok {
+		if name != "/" {
+			return nil, syscall.ENOENT
+		}
+		file = &tarFile{}
+	}
+
+	fi := fs.fi(name, file)
+	if fi.IsDir() {
+		names := make([]string, 0, len(file.entries))
+		for entry := range file.entries {
+			names = append(names, entry)
+		}
+		sort.Strings(names)
+
+		entries := make([]os.FileInfo, 0, len(names))
+		for _, entry := range names {
+			child := path.Join(name, entry)
+			entries = append(entries, fs.fi(child, fs.files[child]))
+		}
+
+		return fakeDir{
+			entries:  entries,
+			fakeFile: fakeFile{FileInfo: fi, name: name},
+		}, nil
+	}
+
+	if !fi.Mode().IsRegular() {
+		return fakeFile{FileInfo: fi, name: name}, nil
+	}
+
+	tr := tar.NewReader(io.NewSectionReader(fs.rd, file.offset, fs.size-file.offset))
+	_, err := tr.Next()
+	if err != nil {
+		return nil, errors.Wrap(err, "tar.Next")
+	}
+
+	return newReaderFile(ioutil.NopCloser(tr), fi, true), nil
+}
+
+// OpenFile is the generalized open call; most users will use Open
+// or Create instead.  It opens the named file with specified flag
+// (O_RDONLY etc.) and perm, (0666 etc.) if applicable.  If successful,
+// methods on the returned File can be used for I/O.
+// If there is an error, it will be of type *PathError.
+func (fs *Tar) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag & ^(O_RDONLY|O_NOFOLLOW) != 0 {
+		return nil, errors.Errorf("invalid combination of flags 0x%x", flag)
+	}
+
+	return fs.Open(name)
+}
+
+// Stat returns a FileInfo describing the named file. If there is an error, it
+// will be of type *PathError.
+func (fs *Tar) Stat(name string) (os.FileInfo, error) {
+	return fs.Lstat(name)
+}
+
+// Lstat returns the FileInfo structure describing the named file.
+// If the file is a symbolic link, the returned FileInfo
+// describes the symbolic link.  Lstat makes no attempt to follow the link.
+// If there is an error, it will be of type *PathError.
+func (fs *Tar) Lstat(name string) (os.FileInfo, error) {
+	name = path.Join("/", name)
+	file, ok := fs.files[name]
+	if !ok {
+		if name != "/" {
+			return nil, os.ErrNotExist
+		}
+		file = &tarFile{}
+	}
+
+	return fs.fi(name, file), nil
+}
+
+// Join joins any number of path elements into a single path, adding a
+// Separator if necessary. Join calls Clean on the result; in particular, all
+// empty strings are ignored. On Windows, the result is a UNC path if and only
+// if the first path element is a UNC path.
+func (fs *Tar) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Separator returns the OS and FS dependent separator for dirs/subdirs/files.
+func (fs *Tar) Separator() string {
+	return "/"
+}
+
+// IsAbs reports whether the path is absolute. For the Tar file system, this
+// is always the case.
+func (fs *Tar) IsAbs(p string) bool {
+	return true
+}
+
+// Abs returns an absolute representation of path. For the Tar file system,
+// all paths are absolute.
+func (fs *Tar) Abs(p string) (string, error) {
+	return path.Join("/", p), nil
+}
+
+// Clean returns the cleaned path. For details, see filepath.Clean.
+func (fs *Tar) Clean(p string) string {
+	return path.Clean(p)
+}
+
+// Base returns the last element of p.
+func (fs *Tar) Base(p string) string {
+	return path.Base(p)
+}
+
+// Dir returns p without the last element.
+func (fs *Tar) Dir(p string) string {
+	return path.Dir(p)
+}
+
+// Names returns the names of all files and directories in the root directory
+// of the archive.
+func (fs *Tar) Names() []string {
+	dir := fs.dir("/")
+	names := make([]string, 0, len(dir.entries))
+	for name := range dir.entries {
+		names = append(names, path.Join("/", name))
+	}
+	sort.Strings(names)
+	return names
+}
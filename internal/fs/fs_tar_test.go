@@ -0,0 +1,109 @@
+package fs
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/test"
+)
+
+type tarTestFile struct {
+	hdr  tar.Header
+	data []byte
+}
+
+func writeTestTar(t testing.TB, files []tarTestFile) []byte {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, file := range files {
+		hdr := file.hdr
+		hdr.Size = int64(len(file.data))
+		test.OK(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write(file.data)
+		test.OK(t, err)
+	}
+	test.OK(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestFSTar(t *testing.T) {
+	modtime := time.Unix(1600000000, 0)
+	newer := modtime.Add(time.Hour)
+	longName := "dir/" + string(bytes.Repeat([]byte("x"), 200))
+	files := []tarTestFile{
+		{hdr: tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0700, ModTime: modtime}},
+		{hdr: tar.Header{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0640, ModTime: modtime, Uid: 1000, Uname: "user"}, data: test.Random(23, 2000)},
+		{hdr: tar.Header{Name: longName, Typeflag: tar.TypeReg, Mode: 0644, ModTime: modtime}, data: test.Random(42, 1234)},
+		{hdr: tar.Header{Name: "implicit/sub/empty", Typeflag: tar.TypeReg, Mode: 0644, ModTime: modtime}},
+		{hdr: tar.Header{Name: "implicit/newer", Typeflag: tar.TypeReg, Mode: 0644, ModTime: newer}},
+		{hdr: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir/file", Mode: 0777, ModTime: modtime}},
+		{hdr: tar.Header{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "dir/file", Mode: 0640, ModTime: modtime}},
+		{hdr: tar.Header{Name: "replaced", Typeflag: tar.TypeReg, Mode: 0644, ModTime: modtime}, data: []byte("old")},
+		{hdr: tar.Header{Name: "replaced", Typeflag: tar.TypeReg, Mode: 0644, ModTime: modtime}, data: []byte("new")},
+	}
+	archive := writeTestTar(t, files)
+
+	fs, err := NewTar(bytes.NewReader(archive), int64(len(archive)))
+	test.OK(t, err)
+
+	test.Equals(t, []string{"/dir", "/hardlink", "/implicit", "/link", "/replaced"}, fs.Names())
+	verifyDirectoryContents(t, fs, "/", []string{"dir", "hardlink", "implicit", "link", "replaced"})
+	verifyDirectoryContents(t, fs, "/dir", []string{"file", longName[4:]})
+	verifyDirectoryContents(t, fs, "/implicit", []string{"newer", "sub"})
+
+	verifyFileContentOpen(t, fs, "/dir/file", files[1].data)
+	verifyFileContentOpenFile(t, fs, "/"+longName, files[2].data)
+	verifyFileContentOpen(t, fs, "/implicit/sub/empty", []byte{})
+	verifyFileContentOpen(t, fs, "/hardlink", files[1].data)
+	verifyFileContentOpen(t, fs, "/replaced", []byte("new"))
+
+	fi, err := fs.Lstat("/dir")
+	test.OK(t, err)
+	checkFileInfo(t, fi, "/dir", modtime, os.ModeDir|0700, true)
+
+	fi, err = fs.Lstat("/implicit/sub")
+	test.OK(t, err)
+	checkFileInfo(t, fi, "/implicit/sub", modtime, os.ModeDir|0755, true)
+
+	// implicit directories use the newest modification time of their contents
+	fi, err = fs.Lstat("/implicit")
+	test.OK(t, err)
+	checkFileInfo(t, fi, "/implicit", newer, os.ModeDir|0755, true)
+
+	fi, err = fs.Lstat("/link")
+	test.OK(t, err)
+	checkFileInfo(t, fi, "/link", modtime, os.ModeSymlink|0777, false)
+	test.Equals(t, "dir/file", fi.Sys().(*TarHeader).Linkname)
+
+	fi, err = fs.Lstat("/dir/file")
+	test.OK(t, err)
+	checkFileInfo(t, fi, "/dir/file", modtime, 0640, false)
+	test.Equals(t, int64(2000), fi.Size())
+	hdr := fi.Sys().(*TarHeader)
+	test.Equals(t, "user", hdr.Uname)
+	test.Equals(t, uint64(2), hdr.Links)
+
+	fi, err = fs.Lstat("/hardlink")
+	test.OK(t, err)
+	test.Equals(t, int64(2000), fi.Size())
+	test.Equals(t, hdr.Inode, fi.Sys().(*TarHeader).Inode)
+
+	fi, err = fs.Lstat("/replaced")
+	test.OK(t, err)
+	test.Equals(t, uint64(1), fi.Sys().(*TarHeader).Links)
+
+	_, err = fs.Lstat("/missing")
+	test.Assert(t, os.IsNotExist(err), "unexpected error for missing file: %v", err)
+}
+
+func TestFSTarInvalidHardlink(t *testing.T) {
+	archive := writeTestTar(t, []tarTestFile{
+		{hdr: tar.Header{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "missing", Mode: 0644}},
+	})
+
+	_, err := NewTar(bytes.NewReader(archive), int64(len(archive)))
+	test.Assert(t, err != nil, "hardlink to missing file was accepted")
+}
@@ -0,0 +1,8 @@
+package fs
+
+// Hole is a region of a file which has not been allocated on disk, reading it
+// returns only zeros.
+type Hole struct {
+	Offset int64
+	Length int64
+}
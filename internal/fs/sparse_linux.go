@@ -0,0 +1,71 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// whence values for lseek() on Linux
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// Holes returns the holes of the first size bytes of the file f, found with
+// SEEK_HOLE and SEEK_DATA. If the file system does not support this, no holes
+// are returned. Afterwards, the offset of f is reset to the start of the file.
+func Holes(f File, size int64) ([]Hole, error) {
+	var holes []Hole
+
+	for offset := int64(0); offset < size; {
+		start, err := f.Seek(offset, seekHole)
+		if err != nil {
+			if offset == 0 {
+				// holes are not supported for this file
+				debug.Log("SEEK_HOLE for %v failed: %v", f.Name(), err)
+				return nil, nil
+			}
+			return nil, errors.Wrap(err, "Seek")
+		}
+
+		if start >= size {
+			break
+		}
+
+		end, err := f.Seek(start, seekData)
+		if isErrno(err, syscall.ENXIO) {
+			// the file ends with a hole
+			end = size
+		} else if err != nil {
+			return nil, errors.Wrap(err, "Seek")
+		}
+
+		if end > size {
+			end = size
+		}
+
+		holes = append(holes, Hole{Offset: start, Length: end - start})
+		offset = end
+	}
+
+	if size > 0 {
+		_, err := f.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, errors.Wrap(err, "Seek")
+		}
+	}
+
+	return holes, nil
+}
+
+// isErrno returns true if err is or wraps the errno e.
+func isErrno(err error, e syscall.Errno) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return err == e
+}
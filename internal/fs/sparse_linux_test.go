@@ -0,0 +1,50 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestHoles(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, "sparse")
+	f, err := os.Create(filename)
+	rtest.OK(t, err)
+
+	const size = 8 * 1024 * 1024
+	data := rtest.Random(23, 64*1024)
+	rtest.OK(t, f.Truncate(size))
+	_, err = f.WriteAt(data, 2*1024*1024)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+
+	f, err = os.Open(filename)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, f.Close())
+	}()
+
+	holes, err := Holes(f, size)
+	rtest.OK(t, err)
+	if len(holes) == 0 {
+		t.Skip("file system does not support holes")
+	}
+
+	// the exact boundaries depend on the block size of the file system
+	rtest.Equals(t, 2, len(holes))
+	rtest.Equals(t, int64(0), holes[0].Offset)
+	rtest.Assert(t, holes[0].Length <= 2*1024*1024, "first hole is too large: %v", holes[0])
+	rtest.Assert(t, holes[1].Offset >= 2*1024*1024+int64(len(data)), "second hole starts too early: %v", holes[1])
+	rtest.Equals(t, int64(size), holes[1].Offset+holes[1].Length)
+
+	// the file is read from the start afterwards
+	buf, err := ioutil.ReadAll(f)
+	rtest.OK(t, err)
+	rtest.Equals(t, size, len(buf))
+}
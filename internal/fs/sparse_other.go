@@ -0,0 +1,9 @@
+// +build !linux
+
+package fs
+
+// Holes returns the holes of the first size bytes of the file f. Finding
+// holes is only supported on Linux, on other systems no holes are returned.
+func Holes(f File, size int64) ([]Hole, error) {
+	return nil, nil
+}
@@ -31,5 +31,9 @@ func ExtendedStat(fi os.FileInfo) ExtendedFileInfo {
 		panic("os.FileInfo is nil")
 	}
 
+	if hdr, ok := fi.Sys().(*TarHeader); ok {
+		return extendedTarStat(fi, hdr)
+	}
+
 	return extendedStat(fi)
 }
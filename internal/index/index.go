@@ -2,6 +2,7 @@
 package index
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
@@ -10,6 +11,7 @@ import (
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/pack"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"golang.org/x/sync/errgroup"
 )
@@ -157,10 +159,11 @@ type packJSON struct {
 }
 
 type blobJSON struct {
-	ID     restic.ID       `json:"id"`
-	Type   restic.BlobType `json:"type"`
-	Offset uint            `json:"offset"`
-	Length uint            `json:"length"`
+	ID                 restic.ID       `json:"id"`
+	Type               restic.BlobType `json:"type"`
+	Offset             uint            `json:"offset"`
+	Length             uint            `json:"length"`
+	UncompressedLength uint            `json:"uncompressed_length,omitempty"`
 }
 
 type indexJSON struct {
@@ -218,10 +221,11 @@ func Load(ctx context.Context, repo ListLoader, p *restic.Progress) (*Index, err
 			entries := make([]restic.Blob, 0, len(jpack.Blobs))
 			for _, blob := range jpack.Blobs {
 				entry := restic.Blob{
-					ID:     blob.ID,
-					Type:   blob.Type,
-					Offset: blob.Offset,
-					Length: blob.Length,
+					ID:                 blob.ID,
+					Type:               blob.Type,
+					Offset:             blob.Offset,
+					Length:             blob.Length,
+					UncompressedLength: blob.UncompressedLength,
 				}
 				entries = append(entries, entry)
 			}
@@ -314,15 +318,22 @@ func (idx *Index) PacksForBlobs(blobs restic.BlobSet) (packs restic.IDSet) {
 
 const maxEntries = 3000
 
-// Saver saves structures as JSON.
+// Saver saves structures as JSON or as raw data.
 type Saver interface {
+	Config() restic.Config
 	SaveJSONUnpacked(ctx context.Context, t restic.FileType, item interface{}) (restic.ID, error)
+	SaveUnpacked(ctx context.Context, t restic.FileType, buf []byte) (restic.ID, error)
 }
 
-// Save writes the complete index to the repo.
+// Save writes the complete index to the repo. Repositories with version 3 or
+// later use the binary index format.
 func (idx *Index) Save(ctx context.Context, repo Saver, supersedes restic.IDs) (restic.IDs, error) {
 	debug.Log("pack files: %d\n", len(idx.Packs))
 
+	if repo.Config().Version >= 3 {
+		return idx.saveBinary(ctx, repo, supersedes)
+	}
+
 	var indexIDs []restic.ID
 
 	packs := 0
@@ -336,10 +347,11 @@ func (idx *Index) Save(ctx context.Context, repo Saver, supersedes restic.IDs) (
 		b := make([]blobJSON, 0, len(pack.Entries))
 		for _, blob := range pack.Entries {
 			b = append(b, blobJSON{
-				ID:     blob.ID,
-				Type:   blob.Type,
-				Offset: blob.Offset,
-				Length: blob.Length,
+				ID:                 blob.ID,
+				Type:               blob.Type,
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
 			})
 		}
 
@@ -375,3 +387,62 @@ func (idx *Index) Save(ctx context.Context, repo Saver, supersedes restic.IDs) (
 
 	return indexIDs, nil
 }
+
+// saveBinary writes the complete index to the repo in the binary format.
+func (idx *Index) saveBinary(ctx context.Context, repo Saver, supersedes restic.IDs) (restic.IDs, error) {
+	var indexIDs []restic.ID
+
+	save := func(ridx *repository.Index) error {
+		buf := bytes.NewBuffer(nil)
+		err := ridx.EncodeBinary(buf)
+		if err != nil {
+			return err
+		}
+
+		id, err := repo.SaveUnpacked(ctx, restic.IndexFile, buf.Bytes())
+		if err != nil {
+			return err
+		}
+		debug.Log("saved new index as %v", id)
+
+		indexIDs = append(indexIDs, id)
+		return nil
+	}
+
+	newIndex := func() (*repository.Index, error) {
+		ridx := repository.NewIndex()
+		return ridx, ridx.AddToSupersedes(supersedes...)
+	}
+
+	ridx, err := newIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	packs := 0
+	for packID, pack := range idx.Packs {
+		debug.Log("%04d add pack %v with %d entries", packs, packID, len(pack.Entries))
+		ridx.StorePack(packID, pack.Entries)
+
+		packs++
+		if packs == maxEntries {
+			if err := save(ridx); err != nil {
+				return nil, err
+			}
+
+			packs = 0
+			ridx, err = newIndex()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if packs > 0 {
+		if err := save(ridx); err != nil {
+			return nil, err
+		}
+	}
+
+	return indexIDs, nil
+}
@@ -1,6 +1,7 @@
 package index
 
 import (
+	"bytes"
 	"context"
 	"sync"
 	"testing"
@@ -370,6 +371,54 @@ type location struct {
 }
 
 // FindBlob returns a list of packs and positions the blob can be found in.
+func TestIndexSaveBinary(t *testing.T) {
+	r, cleanup := repository.TestRepositoryWithVersion(t, 3)
+	defer cleanup()
+
+	repo := r.(*repository.Repository)
+	for i := 0; i < 3; i++ {
+		restic.TestCreateSnapshot(t, repo, snapshotTime.Add(time.Duration(i)*time.Second), depth, 0)
+	}
+
+	idx, _, err := New(context.TODO(), repo, restic.NewIDSet(), nil)
+	if err != nil {
+		t.Fatalf("New() returned error %v", err)
+	}
+
+	ids, err := idx.Save(context.TODO(), repo, nil)
+	if err != nil {
+		t.Fatalf("unable to save new index: %v", err)
+	}
+
+	if len(ids) != 1 {
+		t.Fatalf("expected one index, got %v", len(ids))
+	}
+
+	for _, id := range ids {
+		buf, err := repo.LoadAndDecrypt(context.TODO(), nil, restic.IndexFile, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.HasPrefix(buf, []byte("RIDX")) {
+			t.Errorf("index %v was not saved in the binary format", id.Str())
+		}
+
+		ridx, err := repository.DecodeIndex(buf)
+		if err != nil {
+			t.Fatalf("unable to decode index %v: %v", id.Str(), err)
+		}
+
+		for packID, pack := range idx.Packs {
+			for _, blob := range pack.Entries {
+				if !ridx.Has(blob.ID, blob.Type) {
+					t.Errorf("blob %v of pack %v missing from saved index", blob.ID.Str(), packID.Str())
+				}
+			}
+		}
+	}
+}
+
 func (idx *Index) findBlob(h restic.BlobHandle) (result []location) {
 	for id, p := range idx.Packs {
 		for _, entry := range p.Entries {
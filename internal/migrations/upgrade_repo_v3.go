@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+func init() {
+	register(&UpgradeRepoV3{})
+}
+
+// UpgradeRepoV3 upgrades a repository to version 3 and rewrites all index
+// files in the binary index format.
+type UpgradeRepoV3 struct{}
+
+// Check tests whether the migration can be applied.
+func (m *UpgradeRepoV3) Check(ctx context.Context, repo restic.Repository) (bool, error) {
+	if _, ok := repo.(*repository.Repository); !ok {
+		debug.Log("unsupported repository type %T", repo)
+		return false, nil
+	}
+
+	return repo.Config().Version < 3, nil
+}
+
+// Apply runs the migration.
+func (m *UpgradeRepoV3) Apply(ctx context.Context, repo restic.Repository) error {
+	r, ok := repo.(*repository.Repository)
+	if !ok {
+		return errors.Errorf("unsupported repository type %T", repo)
+	}
+
+	// Both index formats can be read, so an interruption after this step
+	// leaves a repository with JSON index files, which is still valid.
+	cfg := r.Config()
+	cfg.Version = 3
+	err := r.SaveConfig(ctx, cfg)
+	if err != nil {
+		return errors.Wrap(err, "SaveConfig")
+	}
+
+	err = r.LoadIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the new index files supersede all old ones
+	obsolete, err := r.Index().Save(ctx, r, restic.NewIDSet(), nil)
+	if err != nil {
+		return err
+	}
+
+	for id := range obsolete {
+		h := restic.Handle{Type: restic.IndexFile, Name: id.String()}
+		err = r.Backend().Remove(ctx, h)
+		if err != nil {
+			return errors.Wrapf(err, "removing old index %v", id.Str())
+		}
+	}
+
+	return nil
+}
+
+// Name returns the name for this migration.
+func (m *UpgradeRepoV3) Name() string {
+	return "upgrade_repo_v3"
+}
+
+// Desc returns a short description what the migration does.
+func (m *UpgradeRepoV3) Desc() string {
+	return "upgrade a repository to version 3, which stores the index in a compact binary format"
+}
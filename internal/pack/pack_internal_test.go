@@ -75,10 +75,11 @@ func TestReadRecords(t *testing.T) {
 
 		rd := bytes.NewReader(buf.Bytes())
 
-		header, count, err := readRecords(rd, int64(rd.Len()), entryCount)
+		bufsize := entryCount*int(entrySize) + crypto.Extension + binary.Size(uint32(0))
+		header, count, err := readRecords(rd, int64(rd.Len()), bufsize)
 		rtest.OK(t, err)
 		rtest.Equals(t, expectedHeader, header)
-		rtest.Equals(t, totalRecords, count)
+		rtest.Equals(t, len(totalHeader)+binary.Size(uint32(0)), count)
 	}
 
 	// basic
@@ -38,7 +38,7 @@ func newPack(t testing.TB, k *crypto.Key, lengths []int) ([]Buf, []byte, uint) {
 	// pack blobs
 	p := pack.NewPacker(k, new(bytes.Buffer))
 	for _, b := range bufs {
-		p.Add(restic.TreeBlob, b.id, b.data)
+		p.Add(restic.TreeBlob, b.id, b.data, 0)
 	}
 
 	_, err := p.Finalize()
@@ -94,6 +94,53 @@ func TestCreatePack(t *testing.T) {
 	verifyBlobs(t, bufs, k, bytes.NewReader(packData), packSize)
 }
 
+func TestCreatePackCompressedEntries(t *testing.T) {
+	k := crypto.NewRandomKey()
+
+	type blob struct {
+		tpe                restic.BlobType
+		data               []byte
+		uncompressedLength int
+	}
+
+	blobs := []blob{
+		{restic.DataBlob, rtest.Random(1, 100), 0},
+		{restic.DataBlob, rtest.Random(2, 200), 5000},
+		{restic.TreeBlob, rtest.Random(3, 300), 0},
+		{restic.TreeBlob, rtest.Random(4, 400), 7000},
+	}
+
+	p := pack.NewPacker(k, new(bytes.Buffer))
+	for _, b := range blobs {
+		_, err := p.Add(b.tpe, restic.Hash(b.data), b.data, b.uncompressedLength)
+		rtest.OK(t, err)
+	}
+
+	_, err := p.Finalize()
+	rtest.OK(t, err)
+
+	packData := p.Writer().(*bytes.Buffer).Bytes()
+	rtest.Equals(t, uint(len(packData)), p.Size())
+
+	entries, err := pack.List(k, bytes.NewReader(packData), int64(len(packData)))
+	rtest.OK(t, err)
+	rtest.Equals(t, len(blobs), len(entries))
+
+	offset := uint(0)
+	for i, b := range blobs {
+		e := entries[i]
+		rtest.Equals(t, b.tpe, e.Type)
+		rtest.Equals(t, restic.Hash(b.data), e.ID)
+		rtest.Equals(t, uint(len(b.data)), e.Length)
+		rtest.Equals(t, uint(b.uncompressedLength), e.UncompressedLength)
+		rtest.Equals(t, b.uncompressedLength != 0, e.IsCompressed())
+		rtest.Equals(t, offset, e.Offset)
+		offset += e.Length
+	}
+
+	rtest.Equals(t, len(packData), int(offset)+pack.CalculateHeaderSize(entries))
+}
+
 var blobTypeJSON = []struct {
 	t   restic.BlobType
 	res string
@@ -0,0 +1,278 @@
+// Package parity computes Reed-Solomon parity data for pack files, which can
+// be used to repair a pack that was damaged in storage.
+//
+// A pack is split into k data shards of equal size, the last one is padded
+// with zeroes. From these, m parity shards are computed. Up to m damaged
+// shards can be reconstructed, damaged shards are detected by storing a
+// checksum for each shard in the header of the parity file.
+//
+// All integers are stored in little endian byte order:
+//
+//	magic        "RPAR", followed by the format version (1 byte)
+//	pack size    length of the pack in bytes (uint64)
+//	data shards  number of data shards k (uint16)
+//	parity       number of parity shards m (uint16)
+//	shard size   length of each shard in bytes (uint32)
+//	checksums    xxhash64 of each data and parity shard (k+m times uint64)
+//	header hash  xxhash64 of all of the above (uint64)
+//
+// The parity shards follow directly after the header.
+package parity
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/klauspost/reedsolomon"
+	"github.com/restic/restic/internal/errors"
+)
+
+var magic = []byte("RPAR")
+
+const (
+	version = 1
+
+	// headerSize is the size of the fixed part of the header.
+	headerSize = 4 + 1 + 8 + 2 + 2 + 4
+
+	// minShardSize is the shard size used for small packs, larger packs use
+	// up to maxDataShards shards.
+	minShardSize  = 4096
+	maxDataShards = 100
+)
+
+// header describes the layout of the shards.
+type header struct {
+	packSize     uint64
+	dataShards   int
+	parityShards int
+	shardSize    int
+	checksums    []uint64
+}
+
+// size returns the length of the encoded header.
+func (h header) size() int {
+	return headerSize + 8*(h.dataShards+h.parityShards) + 8
+}
+
+func (h header) encode() []byte {
+	buf := make([]byte, h.size())
+	copy(buf, magic)
+	buf[4] = version
+	binary.LittleEndian.PutUint64(buf[5:], h.packSize)
+	binary.LittleEndian.PutUint16(buf[13:], uint16(h.dataShards))
+	binary.LittleEndian.PutUint16(buf[15:], uint16(h.parityShards))
+	binary.LittleEndian.PutUint32(buf[17:], uint32(h.shardSize))
+
+	p := buf[headerSize:]
+	for _, sum := range h.checksums {
+		binary.LittleEndian.PutUint64(p, sum)
+		p = p[8:]
+	}
+	binary.LittleEndian.PutUint64(p, xxhash.Sum64(buf[:len(buf)-8]))
+
+	return buf
+}
+
+func decodeHeader(buf []byte) (h header, err error) {
+	if len(buf) < headerSize || !bytes.HasPrefix(buf, magic) {
+		return header{}, errors.New("not a parity file")
+	}
+
+	if buf[4] != version {
+		return header{}, errors.Errorf("unsupported parity file version %d", buf[4])
+	}
+
+	h.packSize = binary.LittleEndian.Uint64(buf[5:])
+	h.dataShards = int(binary.LittleEndian.Uint16(buf[13:]))
+	h.parityShards = int(binary.LittleEndian.Uint16(buf[15:]))
+	h.shardSize = int(binary.LittleEndian.Uint32(buf[17:]))
+
+	if len(buf) < h.size() {
+		return header{}, errors.New("parity file is truncated")
+	}
+
+	end := h.size() - 8
+	if xxhash.Sum64(buf[:end]) != binary.LittleEndian.Uint64(buf[end:]) {
+		return header{}, errors.New("parity file header is damaged")
+	}
+
+	if h.dataShards == 0 || h.parityShards == 0 || h.shardSize == 0 ||
+		uint64(h.dataShards)*uint64(h.shardSize) < h.packSize {
+		return header{}, errors.New("parity file header is invalid")
+	}
+
+	h.checksums = make([]uint64, h.dataShards+h.parityShards)
+	for i := range h.checksums {
+		h.checksums[i] = binary.LittleEndian.Uint64(buf[headerSize+8*i:])
+	}
+
+	return h, nil
+}
+
+// layout returns the number of data and parity shards and the shard size
+// used for a pack with size bytes.
+func layout(size int, percent uint) (dataShards, parityShards, shardSize int) {
+	dataShards = (size + minShardSize - 1) / minShardSize
+	if dataShards < 1 {
+		dataShards = 1
+	}
+	if dataShards > maxDataShards {
+		dataShards = maxDataShards
+	}
+
+	shardSize = (size + dataShards - 1) / dataShards
+	if shardSize == 0 {
+		shardSize = 1
+	}
+
+	parityShards = (dataShards*int(percent) + 99) / 100
+	if parityShards < 1 {
+		parityShards = 1
+	}
+
+	return dataShards, parityShards, shardSize
+}
+
+// split returns the data shards for pack. Each shard is a copy, the last one
+// is padded with zeroes.
+func split(pack []byte, dataShards, shardSize int) [][]byte {
+	buf := make([]byte, dataShards*shardSize)
+	copy(buf, pack)
+
+	shards := make([][]byte, dataShards)
+	for i := range shards {
+		shards[i] = buf[i*shardSize : (i+1)*shardSize : (i+1)*shardSize]
+	}
+	return shards
+}
+
+// Encode computes the parity file for pack. The amount of parity data is
+// given in percent of the size of the pack, it must be between 1 and 100.
+func Encode(pack []byte, percent uint) ([]byte, error) {
+	if percent < 1 || percent > 100 {
+		return nil, errors.Errorf("invalid parity percentage %d", percent)
+	}
+
+	dataShards, parityShards, shardSize := layout(len(pack), percent)
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, errors.Wrap(err, "reedsolomon.New")
+	}
+
+	shards := split(pack, dataShards, shardSize)
+	for i := 0; i < parityShards; i++ {
+		shards = append(shards, make([]byte, shardSize))
+	}
+
+	err = enc.Encode(shards)
+	if err != nil {
+		return nil, errors.Wrap(err, "Encode")
+	}
+
+	h := header{
+		packSize:     uint64(len(pack)),
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		shardSize:    shardSize,
+	}
+	for _, shard := range shards {
+		h.checksums = append(h.checksums, xxhash.Sum64(shard))
+	}
+
+	buf := h.encode()
+	for _, shard := range shards[dataShards:] {
+		buf = append(buf, shard...)
+	}
+
+	return buf, nil
+}
+
+// shards returns the data and parity shards contained in pack and par, which
+// must match the layout in h. Shards which do not match their checksum are
+// set to nil, the number of these shards is returned in damaged.
+func (h header) shards(pack, par []byte) (shards [][]byte, damaged int) {
+	shards = split(pack, h.dataShards, h.shardSize)
+
+	par = par[h.size():]
+	for i := 0; i < h.parityShards; i++ {
+		var shard []byte
+		if len(par) >= h.shardSize {
+			shard = par[:h.shardSize:h.shardSize]
+			par = par[h.shardSize:]
+		}
+		shards = append(shards, shard)
+	}
+
+	for i, shard := range shards {
+		if shard == nil || xxhash.Sum64(shard) != h.checksums[i] {
+			shards[i] = nil
+			damaged++
+		}
+	}
+
+	return shards, damaged
+}
+
+// Verify returns an error if the parity file par is damaged or does not
+// belong to a pack of packSize bytes. The pack itself is not checked.
+func Verify(par []byte, packSize int64) error {
+	h, err := decodeHeader(par)
+	if err != nil {
+		return err
+	}
+
+	if uint64(packSize) != h.packSize {
+		return errors.Errorf("parity file is for a pack of size %d, pack has size %d", h.packSize, packSize)
+	}
+
+	if len(par) != h.size()+h.parityShards*h.shardSize {
+		return errors.New("parity file has an invalid size")
+	}
+
+	par = par[h.size():]
+	for i := 0; i < h.parityShards; i++ {
+		if xxhash.Sum64(par[:h.shardSize]) != h.checksums[h.dataShards+i] {
+			return errors.Errorf("parity shard %d is damaged", i)
+		}
+		par = par[h.shardSize:]
+	}
+
+	return nil
+}
+
+// Reconstruct repairs pack using the parity file par and returns the repaired
+// pack. The pack may also be truncated or have trailing data. An error is
+// returned if too many shards are damaged. The caller is responsible for
+// verifying that the returned pack has the expected hash.
+func Reconstruct(pack, par []byte) ([]byte, error) {
+	h, err := decodeHeader(par)
+	if err != nil {
+		return nil, err
+	}
+
+	shards, damaged := h.shards(pack, par)
+	if damaged > h.parityShards {
+		return nil, errors.Errorf("%d shards are damaged, at most %d can be repaired", damaged, h.parityShards)
+	}
+
+	if damaged != 0 {
+		enc, err := reedsolomon.New(h.dataShards, h.parityShards)
+		if err != nil {
+			return nil, errors.Wrap(err, "reedsolomon.New")
+		}
+
+		err = enc.ReconstructData(shards)
+		if err != nil {
+			return nil, errors.Wrap(err, "ReconstructData")
+		}
+	}
+
+	buf := make([]byte, 0, h.dataShards*h.shardSize)
+	for _, shard := range shards[:h.dataShards] {
+		buf = append(buf, shard...)
+	}
+
+	return buf[:h.packSize], nil
+}
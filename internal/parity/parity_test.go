@@ -0,0 +1,111 @@
+package parity
+
+import (
+	"bytes"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+var testSizes = []int{0, 1, 23, 4096, 4097, 100000, 1<<20 + 23}
+
+func TestEncodeVerify(t *testing.T) {
+	for i, size := range testSizes {
+		pack := rtest.Random(i, size)
+
+		par, err := Encode(pack, 10)
+		rtest.OK(t, err)
+		rtest.OK(t, Verify(par, int64(len(pack))))
+		rtest.Assert(t, Verify(par, int64(len(pack))+1) != nil, "size %d: wrong pack size was not detected", size)
+
+		rebuilt, err := Reconstruct(pack, par)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(pack, rebuilt), "size %d: reconstructed pack differs", size)
+	}
+}
+
+func TestEncodeInvalidPercentage(t *testing.T) {
+	for _, percent := range []uint{0, 101} {
+		_, err := Encode([]byte("foo"), percent)
+		rtest.Assert(t, err != nil, "expected error for %d%% parity", percent)
+	}
+}
+
+func TestReconstruct(t *testing.T) {
+	pack := rtest.Random(23, 1<<20+42)
+	par, err := Encode(pack, 5)
+	rtest.OK(t, err)
+
+	h, err := decodeHeader(par)
+	rtest.OK(t, err)
+
+	var tests = []struct {
+		name   string
+		damage func(pack []byte) []byte
+	}{
+		{"flipped bit", func(pack []byte) []byte {
+			pack[4711] ^= 0x01
+			return pack
+		}},
+		{"damaged shards", func(pack []byte) []byte {
+			for i := 0; i < h.parityShards; i++ {
+				pack[i*h.shardSize*2] ^= 0xff
+			}
+			return pack
+		}},
+		{"truncated", func(pack []byte) []byte {
+			return pack[:len(pack)-h.shardSize/2]
+		}},
+		{"trailing data", func(pack []byte) []byte {
+			return append(pack, []byte("foobar")...)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			damaged := test.damage(append([]byte(nil), pack...))
+			rebuilt, err := Reconstruct(damaged, par)
+			rtest.OK(t, err)
+			rtest.Assert(t, bytes.Equal(pack, rebuilt), "reconstructed pack differs")
+		})
+	}
+}
+
+func TestReconstructTooManyErrors(t *testing.T) {
+	pack := rtest.Random(42, 1<<20)
+	par, err := Encode(pack, 5)
+	rtest.OK(t, err)
+
+	h, err := decodeHeader(par)
+	rtest.OK(t, err)
+
+	damaged := append([]byte(nil), pack...)
+	for i := 0; i <= h.parityShards; i++ {
+		damaged[i*h.shardSize] ^= 0xff
+	}
+
+	_, err = Reconstruct(damaged, par)
+	rtest.Assert(t, err != nil, "expected error for too many damaged shards")
+}
+
+func TestDamagedParity(t *testing.T) {
+	pack := rtest.Random(5, 100000)
+	par, err := Encode(pack, 20)
+	rtest.OK(t, err)
+
+	// a damaged parity shard is detected, but the pack is still fine
+	damaged := append([]byte(nil), par...)
+	damaged[len(damaged)-1] ^= 0x01
+	rtest.Assert(t, Verify(damaged, int64(len(pack))) != nil, "damaged parity shard was not detected")
+
+	rebuilt, err := Reconstruct(pack, damaged)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(pack, rebuilt), "reconstructed pack differs")
+
+	// a damaged header renders the parity file unusable
+	damaged = append([]byte(nil), par...)
+	damaged[10] ^= 0x01
+	rtest.Assert(t, Verify(damaged, int64(len(pack))) != nil, "damaged header was not detected")
+	_, err = Reconstruct(pack, damaged)
+	rtest.Assert(t, err != nil, "damaged header was not detected")
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The zstd encoder and decoder are safe for concurrent use of EncodeAll and
+// DecodeAll, so a single instance of each is shared by all repositories.
+var (
+	zstdEncoderOnce sync.Once
+	zstdEncoder     *zstd.Encoder
+
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+)
+
+func getZstdEncoder() *zstd.Encoder {
+	zstdEncoderOnce.Do(func() {
+		// the checksum is not needed, all data is authenticated by the MAC
+		enc, err := zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.SpeedDefault),
+			zstd.WithEncoderCRC(false),
+			zstd.WithEncoderConcurrency(1),
+		)
+		if err != nil {
+			panic(err)
+		}
+		zstdEncoder = enc
+	})
+	return zstdEncoder
+}
+
+func getZstdDecoder() *zstd.Decoder {
+	zstdDecoderOnce.Do(func() {
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			panic(err)
+		}
+		zstdDecoder = dec
+	})
+	return zstdDecoder
+}
+
+// compressBlob compresses data. When compression does not reduce the size
+// of the data, it is returned unchanged and uncompressedLength is zero, which
+// marks the blob as stored uncompressed.
+func compressBlob(data []byte) (buf []byte, uncompressedLength int) {
+	if len(data) == 0 {
+		return data, 0
+	}
+
+	buf = getZstdEncoder().EncodeAll(data, make([]byte, 0, len(data)))
+	if len(buf) >= len(data) {
+		return data, 0
+	}
+
+	return buf, len(data)
+}
+
+// DecompressBlob returns the uncompressed content of the decrypted data of
+// blob. Blobs which are not compressed are returned unchanged.
+func DecompressBlob(blob restic.Blob, plaintext []byte) ([]byte, error) {
+	if !blob.IsCompressed() {
+		return plaintext, nil
+	}
+
+	buf, err := getZstdDecoder().DecodeAll(plaintext, make([]byte, 0, blob.UncompressedLength))
+	if err != nil {
+		return nil, errors.Errorf("decompressing blob %v failed: %v", blob.ID.Str(), err)
+	}
+
+	if uint(len(buf)) != blob.UncompressedLength {
+		return nil, errors.Errorf("decompressing blob %v failed: wrong length, want %d, got %d",
+			blob.ID.Str(), blob.UncompressedLength, len(buf))
+	}
+
+	return buf, nil
+}
@@ -19,7 +19,7 @@ import (
 // Hence the index data structure defined here is one of the main contributions
 // to the total memory requirements of restic.
 //
-// We store the index entries in indexMaps. In these maps, entries take 56
+// We store the index entries in indexMaps. In these maps, entries take 64
 // bytes each, plus 8/4 = 2 bytes of unused pointers on average, not counting
 // malloc and header struct overhead and ignoring duplicates (those are only
 // present in edge cases and are also removed by prune runs).
@@ -33,12 +33,12 @@ import (
 // size is 1.5 MB and the minimum pack size is 4 MB)
 //
 // We have the following sizes:
-// indexEntry:  56 bytes  (on amd64)
+// indexEntry:  64 bytes  (on amd64)
 // each packID: 32 bytes
 //
 // To save N index entries, we therefore need:
-// N * (56 + 2) bytes + N * 32 bytes / BP = N * 62 bytes,
-// i.e., fewer than 64 bytes per blob in an index.
+// N * (64 + 2) bytes + N * 32 bytes / BP = N * 70 bytes,
+// i.e., fewer than 72 bytes per blob in an index.
 
 // Index holds lookup tables for id -> pack.
 type Index struct {
@@ -74,12 +74,12 @@ const maxuint32 = 1<<32 - 1
 
 func (idx *Index) store(packIndex int, blob restic.Blob) {
 	// assert that offset and length fit into uint32!
-	if blob.Offset > maxuint32 || blob.Length > maxuint32 {
+	if blob.Offset > maxuint32 || blob.Length > maxuint32 || blob.UncompressedLength > maxuint32 {
 		panic("offset or length does not fit in uint32. You have packs > 4GB!")
 	}
 
 	m := &idx.byType[blob.Type]
-	m.add(blob.ID, packIndex, uint32(blob.Offset), uint32(blob.Length))
+	m.add(blob.ID, packIndex, uint32(blob.Offset), uint32(blob.Length), uint32(blob.UncompressedLength))
 }
 
 // Final returns true iff the index is already written to the repository, it is
@@ -165,10 +165,11 @@ func (idx *Index) StorePack(id restic.ID, blobs []restic.Blob) {
 func (idx *Index) toPackedBlob(e *indexEntry, typ restic.BlobType) restic.PackedBlob {
 	return restic.PackedBlob{
 		Blob: restic.Blob{
-			ID:     e.id,
-			Type:   typ,
-			Length: uint(e.length),
-			Offset: uint(e.offset),
+			ID:                 e.id,
+			Type:               typ,
+			Length:             uint(e.length),
+			Offset:             uint(e.offset),
+			UncompressedLength: uint(e.uncompressedLength),
 		},
 		PackID: idx.packs[e.packIndex],
 	}
@@ -214,7 +215,7 @@ func (idx *Index) Has(id restic.ID, tpe restic.BlobType) bool {
 }
 
 // LookupSize returns the length of the plaintext content of the blob with the
-// given id.
+// given id. For compressed blobs, this is the length after decompression.
 func (idx *Index) LookupSize(id restic.ID, tpe restic.BlobType) (plaintextLength uint, found bool) {
 	idx.m.Lock()
 	defer idx.m.Unlock()
@@ -223,6 +224,9 @@ func (idx *Index) LookupSize(id restic.ID, tpe restic.BlobType) (plaintextLength
 	if e == nil {
 		return 0, false
 	}
+	if e.uncompressedLength != 0 {
+		return uint(e.uncompressedLength), true
+	}
 	return uint(restic.PlaintextLength(int(e.length))), true
 }
 
@@ -275,6 +279,52 @@ func (idx *Index) Each(ctx context.Context) <-chan restic.PackedBlob {
 	return ch
 }
 
+// EachByPackResult is a pack together with all blobs the index knows to be
+// contained in it.
+type EachByPackResult struct {
+	PackID restic.ID
+	Blobs  []restic.Blob
+}
+
+// EachByPack returns a channel that yields all blobs known to the index
+// grouped by pack, leaving out packs whose ID is contained in packBlacklist.
+// When the context is cancelled, the background goroutine terminates. This
+// blocks any modification of the index.
+func (idx *Index) EachByPack(ctx context.Context, packBlacklist restic.IDSet) <-chan EachByPackResult {
+	idx.m.Lock()
+
+	ch := make(chan EachByPackResult)
+
+	go func() {
+		defer idx.m.Unlock()
+		defer func() {
+			close(ch)
+		}()
+
+		byPack := make(map[restic.ID][]restic.Blob)
+		for typ := range idx.byType {
+			m := &idx.byType[typ]
+			m.foreach(func(e *indexEntry) bool {
+				packID := idx.packs[e.packIndex]
+				if !packBlacklist.Has(packID) {
+					byPack[packID] = append(byPack[packID], idx.toPackedBlob(e, restic.BlobType(typ)).Blob)
+				}
+				return true
+			})
+		}
+
+		for packID, blobs := range byPack {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- EachByPackResult{PackID: packID, Blobs: blobs}:
+			}
+		}
+	}()
+
+	return ch
+}
+
 // Packs returns all packs in this index
 func (idx *Index) Packs() restic.IDSet {
 	idx.m.Lock()
@@ -303,10 +353,11 @@ type packJSON struct {
 }
 
 type blobJSON struct {
-	ID     restic.ID       `json:"id"`
-	Type   restic.BlobType `json:"type"`
-	Offset uint            `json:"offset"`
-	Length uint            `json:"length"`
+	ID                 restic.ID       `json:"id"`
+	Type               restic.BlobType `json:"type"`
+	Offset             uint            `json:"offset"`
+	Length             uint            `json:"length"`
+	UncompressedLength uint            `json:"uncompressed_length,omitempty"`
 }
 
 // generatePackList returns a list of packs.
@@ -337,10 +388,11 @@ func (idx *Index) generatePackList() ([]*packJSON, error) {
 
 			// add blob
 			p.Blobs = append(p.Blobs, blobJSON{
-				ID:     e.id,
-				Type:   restic.BlobType(typ),
-				Offset: uint(e.offset),
-				Length: uint(e.length),
+				ID:                 e.id,
+				Type:               restic.BlobType(typ),
+				Offset:             uint(e.offset),
+				Length:             uint(e.length),
+				UncompressedLength: uint(e.uncompressedLength),
 			})
 
 			return true
@@ -458,6 +510,27 @@ func (idx *Index) Dump(w io.Writer) error {
 	return nil
 }
 
+// MemoryUsage returns an estimate of the number of bytes the index uses in
+// memory.
+func (idx *Index) MemoryUsage() uint64 {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	var size uint64
+	for typ := range idx.byType {
+		size += idx.byType[typ].memoryUsage()
+	}
+
+	idSize := uint64(len(restic.ID{}))
+	size += uint64(cap(idx.packs)+cap(idx.treePacks)+cap(idx.ids)+cap(idx.supersedes)) * idSize
+
+	// packIDToIndex is only filled for indexes which are not final, count
+	// the key and value of each entry
+	size += uint64(len(idx.packIDToIndex)) * (idSize + 8)
+
+	return size
+}
+
 // TreePacks returns a list of packs that contain only tree blobs.
 func (idx *Index) TreePacks() restic.IDs {
 	return idx.treePacks
@@ -489,7 +562,8 @@ func (idx *Index) merge(idx2 *Index) error {
 			m.foreachWithID(e2.id, func(e *indexEntry) {
 				b := idx.toPackedBlob(e, restic.BlobType(typ))
 				b2 := idx2.toPackedBlob(e2, restic.BlobType(typ))
-				if b.Length == b2.Length && b.Offset == b2.Offset && b.PackID == b2.PackID {
+				if b.Length == b2.Length && b.Offset == b2.Offset && b.PackID == b2.PackID &&
+					b.UncompressedLength == b2.UncompressedLength {
 					found = true
 				}
 			})
@@ -499,7 +573,7 @@ func (idx *Index) merge(idx2 *Index) error {
 		m2.foreach(func(e2 *indexEntry) bool {
 			if !hasIdenticalEntry(e2) {
 				// packIndex needs to be changed as idx2.pack was appended to idx.pack, see above
-				m.add(e2.id, e2.packIndex+packlen, e2.offset, e2.length)
+				m.add(e2.id, e2.packIndex+packlen, e2.offset, e2.length, e2.uncompressedLength)
 			}
 			return true
 		})
@@ -525,8 +599,13 @@ func isErrOldIndex(err error) bool {
 // ErrOldIndexFormat means an index with the old format was detected.
 var ErrOldIndexFormat = errors.New("index has old format")
 
-// DecodeIndex loads and unserializes an index from rd.
+// DecodeIndex loads and unserializes an index from rd. Both the JSON and the
+// binary format are supported.
 func DecodeIndex(buf []byte) (idx *Index, err error) {
+	if isBinaryIndex(buf) {
+		return decodeBinaryIndex(buf)
+	}
+
 	debug.Log("Start decoding index")
 	idxJSON := &jsonIndex{}
 
@@ -549,10 +628,11 @@ func DecodeIndex(buf []byte) (idx *Index, err error) {
 
 		for _, blob := range pack.Blobs {
 			idx.store(packID, restic.Blob{
-				Type:   blob.Type,
-				ID:     blob.ID,
-				Offset: blob.Offset,
-				Length: blob.Length,
+				Type:               blob.Type,
+				ID:                 blob.ID,
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
 			})
 
 			switch blob.Type {
@@ -592,10 +672,11 @@ func DecodeOldIndex(buf []byte) (idx *Index, err error) {
 
 		for _, blob := range pack.Blobs {
 			idx.store(packID, restic.Blob{
-				Type:   blob.Type,
-				ID:     blob.ID,
-				Offset: blob.Offset,
-				Length: blob.Length,
+				Type:               blob.Type,
+				ID:                 blob.ID,
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
 			})
 
 			switch blob.Type {
@@ -0,0 +1,260 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// The binary index format is used by repositories with version 3 or later.
+// Compared to the JSON format, it can be decoded without allocating
+// intermediate structures and takes roughly a third of the space.
+//
+// All integers are stored in little endian byte order:
+//
+//   magic       "RIDX", followed by the format version (1 byte)
+//   supersedes  number of IDs (uvarint), followed by the IDs (32 bytes each)
+//   packs       number of IDs (uvarint), followed by the IDs (32 bytes each),
+//               sorted in ascending order
+//
+// This is followed by one section for data blobs and one for tree blobs. Each
+// section starts with a table of 256 shards, one for each value of the first
+// byte of the blob ID, holding the number of entries in that shard (uint32).
+// Afterwards, all entries follow, sorted by blob ID:
+//
+//   id                  blob ID (32 bytes)
+//   pack                index into the list of packs (uint32)
+//   offset              offset of the blob in the pack (uint32)
+//   length              length of the blob in the pack (uint32)
+//   uncompressed length length of the blob before compression, 0 if the
+//                       blob is not compressed (uint32)
+
+var binaryIndexMagic = []byte("RIDX")
+
+const (
+	binaryIndexVersion   = 1
+	binaryIndexShards    = 256
+	binaryIndexEntrySize = len(restic.ID{}) + 4*4
+)
+
+// binaryIndexBlobTypes lists the blob types in the order of the sections.
+var binaryIndexBlobTypes = []restic.BlobType{restic.DataBlob, restic.TreeBlob}
+
+// isBinaryIndex returns true if buf contains an index in the binary format.
+func isBinaryIndex(buf []byte) bool {
+	return bytes.HasPrefix(buf, binaryIndexMagic)
+}
+
+type binaryIndexEntry struct {
+	id                 restic.ID
+	pack               restic.ID
+	offset             uint32
+	length             uint32
+	uncompressedLength uint32
+}
+
+// EncodeBinary writes the binary serialization of the index to the writer w.
+func (idx *Index) EncodeBinary(w io.Writer) error {
+	debug.Log("encoding index in binary format")
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	var entries [restic.NumBlobTypes][]binaryIndexEntry
+	packSet := restic.NewIDSet()
+	for _, typ := range binaryIndexBlobTypes {
+		m := &idx.byType[typ]
+		m.foreach(func(e *indexEntry) bool {
+			packID := idx.packs[e.packIndex]
+			if packID.IsNull() {
+				panic("null pack id")
+			}
+			packSet.Insert(packID)
+
+			entries[typ] = append(entries[typ], binaryIndexEntry{
+				id:                 e.id,
+				pack:               packID,
+				offset:             e.offset,
+				length:             e.length,
+				uncompressedLength: e.uncompressedLength,
+			})
+			return true
+		})
+	}
+
+	packs := packSet.List()
+	sort.Sort(packs)
+	packIndex := make(map[restic.ID]uint32, len(packs))
+	for i, id := range packs {
+		packIndex[id] = uint32(i)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.Write(binaryIndexMagic)
+	buf.WriteByte(binaryIndexVersion)
+
+	var tmp [binary.MaxVarintLen64]byte
+	writeIDs := func(ids restic.IDs) {
+		n := binary.PutUvarint(tmp[:], uint64(len(ids)))
+		buf.Write(tmp[:n])
+		for _, id := range ids {
+			buf.Write(id[:])
+		}
+	}
+
+	writeIDs(idx.supersedes)
+	writeIDs(packs)
+
+	for _, typ := range binaryIndexBlobTypes {
+		list := entries[typ]
+		sort.Slice(list, func(i, j int) bool {
+			if c := bytes.Compare(list[i].id[:], list[j].id[:]); c != 0 {
+				return c < 0
+			}
+			if c := bytes.Compare(list[i].pack[:], list[j].pack[:]); c != 0 {
+				return c < 0
+			}
+			return list[i].offset < list[j].offset
+		})
+
+		var shards [binaryIndexShards]uint32
+		for _, e := range list {
+			shards[e.id[0]]++
+		}
+		for _, n := range shards {
+			binary.LittleEndian.PutUint32(tmp[:4], n)
+			buf.Write(tmp[:4])
+		}
+
+		var entry [binaryIndexEntrySize]byte
+		for _, e := range list {
+			copy(entry[:], e.id[:])
+			p := entry[len(e.id):]
+			binary.LittleEndian.PutUint32(p[0:], packIndex[e.pack])
+			binary.LittleEndian.PutUint32(p[4:], e.offset)
+			binary.LittleEndian.PutUint32(p[8:], e.length)
+			binary.LittleEndian.PutUint32(p[12:], e.uncompressedLength)
+			buf.Write(entry[:])
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+var errBinaryIndexTruncated = errors.New("binary index is truncated")
+
+// decodeBinaryIndex decodes an index in the binary format from buf.
+func decodeBinaryIndex(buf []byte) (*Index, error) {
+	debug.Log("Start decoding binary index")
+
+	if !isBinaryIndex(buf) || len(buf) < len(binaryIndexMagic)+1 {
+		return nil, errors.New("not a binary index")
+	}
+	buf = buf[len(binaryIndexMagic):]
+
+	if buf[0] != binaryIndexVersion {
+		return nil, errors.Errorf("unsupported binary index version %d", buf[0])
+	}
+	buf = buf[1:]
+
+	readIDs := func() (restic.IDs, error) {
+		n, l := binary.Uvarint(buf)
+		if l <= 0 {
+			return nil, errBinaryIndexTruncated
+		}
+		buf = buf[l:]
+
+		if uint64(len(buf))/uint64(len(restic.ID{})) < n {
+			return nil, errBinaryIndexTruncated
+		}
+
+		ids := make(restic.IDs, n)
+		for i := range ids {
+			buf = buf[copy(ids[i][:], buf):]
+		}
+		return ids, nil
+	}
+
+	supersedes, err := readIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	packs, err := readIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	idx := NewIndex()
+	for _, id := range packs {
+		idx.addToPacks(id)
+	}
+
+	// remember which blob types are contained in each pack
+	var hasType [restic.NumBlobTypes][]bool
+
+	for _, typ := range binaryIndexBlobTypes {
+		hasType[typ] = make([]bool, len(packs))
+
+		if len(buf) < binaryIndexShards*4 {
+			return nil, errBinaryIndexTruncated
+		}
+
+		var shards [binaryIndexShards]uint32
+		total := 0
+		for i := range shards {
+			shards[i] = binary.LittleEndian.Uint32(buf[i*4:])
+			total += int(shards[i])
+		}
+		buf = buf[binaryIndexShards*4:]
+
+		if len(buf)/binaryIndexEntrySize < total {
+			return nil, errBinaryIndexTruncated
+		}
+
+		for shard, n := range shards {
+			for i := uint32(0); i < n; i++ {
+				var id restic.ID
+				copy(id[:], buf)
+				if int(id[0]) != shard {
+					return nil, errors.Errorf("blob %v is stored in the wrong shard %02x", id.Str(), shard)
+				}
+
+				p := buf[len(id):]
+				packIndex := binary.LittleEndian.Uint32(p[0:])
+				if int(packIndex) >= len(packs) {
+					return nil, errors.Errorf("blob %v references invalid pack %d", id.Str(), packIndex)
+				}
+
+				idx.byType[typ].add(id, int(packIndex),
+					binary.LittleEndian.Uint32(p[4:]),
+					binary.LittleEndian.Uint32(p[8:]),
+					binary.LittleEndian.Uint32(p[12:]))
+				hasType[typ][packIndex] = true
+
+				buf = buf[binaryIndexEntrySize:]
+			}
+		}
+	}
+
+	if len(buf) != 0 {
+		return nil, errors.Errorf("binary index has %d bytes of trailing data", len(buf))
+	}
+
+	for i, id := range packs {
+		if hasType[restic.TreeBlob][i] && !hasType[restic.DataBlob][i] {
+			idx.treePacks = append(idx.treePacks, id)
+		}
+	}
+
+	idx.supersedes = supersedes
+	idx.final = true
+
+	debug.Log("done")
+	return idx, nil
+}
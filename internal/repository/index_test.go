@@ -2,6 +2,7 @@ package repository_test
 
 import (
 	"bytes"
+	"context"
 	"math/rand"
 	"sync"
 	"testing"
@@ -355,6 +356,90 @@ func BenchmarkDecodeIndexParallel(b *testing.B) {
 	})
 }
 
+var (
+	benchmarkIndexBinary     []byte
+	benchmarkIndexBinaryOnce sync.Once
+)
+
+func initBenchmarkIndexBinary() {
+	idx, _ := createRandomIndex(rand.New(rand.NewSource(0)), 200000)
+	var buf bytes.Buffer
+	idx.EncodeBinary(&buf)
+	benchmarkIndexBinary = buf.Bytes()
+}
+
+func BenchmarkDecodeIndexBinary(b *testing.B) {
+	benchmarkIndexBinaryOnce.Do(initBenchmarkIndexBinary)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := repository.DecodeIndex(benchmarkIndexBinary)
+		rtest.OK(b, err)
+	}
+}
+
+func TestIndexSerializeBinary(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	idx, _ := createRandomIndex(rng, 20)
+
+	// add a pack which only contains tree blobs
+	treePack := NewRandomTestID(rng)
+	idx.StorePack(treePack, []restic.Blob{
+		{Type: restic.TreeBlob, ID: NewRandomTestID(rng), Offset: 0, Length: 23},
+		{Type: restic.TreeBlob, ID: NewRandomTestID(rng), Offset: 23, Length: 42, UncompressedLength: 100},
+	})
+
+	superseded := restic.NewRandomID()
+	rtest.OK(t, idx.AddToSupersedes(superseded))
+	idx.Finalize()
+
+	wr := bytes.NewBuffer(nil)
+	rtest.OK(t, idx.EncodeBinary(wr))
+
+	idx2, err := repository.DecodeIndex(wr.Bytes())
+	rtest.OK(t, err)
+	rtest.Assert(t, idx2.Final(), "decoded index is not final")
+	rtest.Equals(t, restic.IDs{superseded}, idx2.Supersedes())
+	rtest.Equals(t, restic.IDs{treePack}, idx2.TreePacks())
+	rtest.Assert(t, idx.Packs().Equals(idx2.Packs()), "packs in decoded index do not match")
+
+	count := 0
+	for pb := range idx.Each(context.TODO()) {
+		count++
+		list := idx2.Lookup(pb.ID, pb.Type, nil)
+		if len(list) != 1 {
+			t.Fatalf("expected one result for blob %v, got %v: %v", pb.ID.Str(), len(list), list)
+		}
+		rtest.Equals(t, pb, list[0])
+	}
+
+	n := 0
+	for range idx2.Each(context.TODO()) {
+		n++
+	}
+	rtest.Equals(t, count, n)
+}
+
+func TestIndexDecodeBinaryInvalid(t *testing.T) {
+	idx, _ := createRandomIndex(rand.New(rand.NewSource(0)), 5)
+	wr := bytes.NewBuffer(nil)
+	rtest.OK(t, idx.EncodeBinary(wr))
+	buf := wr.Bytes()
+
+	for _, l := range []int{5, 40, len(buf) / 2, len(buf) - 1} {
+		_, err := repository.DecodeIndex(buf[:l])
+		rtest.Assert(t, err != nil, "truncated index with %d of %d bytes decoded without error", l, len(buf))
+	}
+
+	_, err := repository.DecodeIndex(append(append([]byte{}, buf...), 0))
+	rtest.Assert(t, err != nil, "index with trailing data decoded without error")
+
+	invalid := append([]byte{}, buf...)
+	invalid[4] = 0xff
+	_, err = repository.DecodeIndex(invalid)
+	rtest.Assert(t, err != nil, "index with unsupported version decoded without error")
+}
+
 func TestIndexUnserializeOld(t *testing.T) {
 	idx, err := repository.DecodeOldIndex(docOldExample)
 	rtest.OK(t, err)
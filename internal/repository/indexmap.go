@@ -3,6 +3,7 @@ package repository
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"unsafe"
 
 	"github.com/restic/restic/internal/restic"
 
@@ -31,11 +32,19 @@ type indexMap struct {
 const (
 	growthFactor = 2 // Must be a power of 2.
 	maxLoad      = 4 // Max. number of entries per bucket.
+
+	// Allocating in batches means that we get closer to optimal space usage,
+	// as Go's malloc will overallocate for structures of size 64 (indexEntry
+	// on amd64).
+	//
+	// 256*64 and 256*48 both have minimal malloc overhead among reasonable sizes.
+	// See src/runtime/sizeclasses.go in the standard library.
+	entryAllocBatch = 256
 )
 
 // add inserts an indexEntry for the given arguments into the map,
 // using id as the key.
-func (m *indexMap) add(id restic.ID, packIdx int, offset, length uint32) {
+func (m *indexMap) add(id restic.ID, packIdx int, offset, length uint32, uncompressedLength uint32) {
 	switch {
 	case m.numentries == 0: // Lazy initialization.
 		m.init()
@@ -50,6 +59,7 @@ func (m *indexMap) add(id restic.ID, packIdx int, offset, length uint32) {
 	e.packIndex = packIdx
 	e.offset = offset
 	e.length = length
+	e.uncompressedLength = uncompressedLength
 
 	m.buckets[h] = e
 	m.numentries++
@@ -136,15 +146,20 @@ func (m *indexMap) init() {
 
 func (m *indexMap) len() uint { return m.numentries }
 
-func (m *indexMap) newEntry() *indexEntry {
-	// Allocating in batches means that we get closer to optimal space usage,
-	// as Go's malloc will overallocate for structures of size 56 (indexEntry
-	// on amd64).
-	//
-	// 256*56 and 256*48 both have minimal malloc overhead among reasonable sizes.
-	// See src/runtime/sizeclasses.go in the standard library.
-	const entryAllocBatch = 256
+// memoryUsage returns the number of bytes allocated for the buckets and
+// entries of the map. As entries are never deleted, all but the last batch
+// of entries are in use.
+func (m *indexMap) memoryUsage() uint64 {
+	const (
+		pointerSize = uint64(unsafe.Sizeof(&indexEntry{}))
+		entrySize   = uint64(unsafe.Sizeof(indexEntry{}))
+	)
+
+	batches := (uint64(m.numentries) + entryAllocBatch - 1) / entryAllocBatch
+	return uint64(len(m.buckets))*pointerSize + batches*entryAllocBatch*entrySize
+}
 
+func (m *indexMap) newEntry() *indexEntry {
 	if m.free == nil {
 		free := new([entryAllocBatch]indexEntry)
 		for i := range free[:len(free)-1] {
@@ -160,9 +175,10 @@ func (m *indexMap) newEntry() *indexEntry {
 }
 
 type indexEntry struct {
-	id        restic.ID
-	next      *indexEntry
-	packIndex int // Position in containing Index's packs field.
-	offset    uint32
-	length    uint32
+	id                 restic.ID
+	next               *indexEntry
+	packIndex          int // Position in containing Index's packs field.
+	offset             uint32
+	length             uint32
+	uncompressedLength uint32 // Zero for uncompressed blobs.
 }
@@ -4,6 +4,7 @@ import (
 	"math/rand"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -22,7 +23,7 @@ func TestIndexMapBasic(t *testing.T) {
 		r.Read(id[:])
 		rtest.Assert(t, m.get(id) == nil, "%v retrieved but not added", id)
 
-		m.add(id, 0, 0, 0)
+		m.add(id, 0, 0, 0, 0)
 		rtest.Assert(t, m.get(id) != nil, "%v added but not retrieved", id)
 		rtest.Equals(t, uint(i), m.len())
 	}
@@ -41,7 +42,7 @@ func TestIndexMapForeach(t *testing.T) {
 	for i := 0; i < N; i++ {
 		var id restic.ID
 		id[0] = byte(i)
-		m.add(id, i, uint32(i), uint32(i))
+		m.add(id, i, uint32(i), uint32(i), 0)
 	}
 
 	seen := make(map[int]struct{})
@@ -85,13 +86,13 @@ func TestIndexMapForeachWithID(t *testing.T) {
 
 	// Test insertion and retrieval of duplicates.
 	for i := 0; i < ndups; i++ {
-		m.add(id, i, 0, 0)
+		m.add(id, i, 0, 0, 0)
 	}
 
 	for i := 0; i < 100; i++ {
 		var otherid restic.ID
 		r.Read(otherid[:])
-		m.add(otherid, -1, 0, 0)
+		m.add(otherid, -1, 0, 0, 0)
 	}
 
 	n = 0
@@ -114,8 +115,8 @@ func TestIndexMapHash(t *testing.T) {
 
 	id := restic.NewRandomID()
 	// Add to both maps to initialize them.
-	m1.add(id, 0, 0, 0)
-	m2.add(id, 0, 0, 0)
+	m1.add(id, 0, 0, 0, 0)
+	m2.add(id, 0, 0, 0, 0)
 
 	h1 := m1.hash(id)
 	h2 := m2.hash(id)
@@ -133,9 +134,32 @@ func TestIndexMapHash(t *testing.T) {
 	}
 }
 
+func TestIndexMapMemoryUsage(t *testing.T) {
+	t.Parallel()
+
+	var m indexMap
+	rtest.Equals(t, uint64(0), m.memoryUsage())
+
+	var (
+		pointerSize = uint64(unsafe.Sizeof(&indexEntry{}))
+		batchSize   = entryAllocBatch * uint64(unsafe.Sizeof(indexEntry{}))
+		r           = rand.New(rand.NewSource(42))
+	)
+
+	for i := 1; i <= 3*entryAllocBatch+1; i++ {
+		var id restic.ID
+		r.Read(id[:])
+		m.add(id, 0, 0, 0, 0)
+
+		batches := uint64((i + entryAllocBatch - 1) / entryAllocBatch)
+		want := uint64(len(m.buckets))*pointerSize + batches*batchSize
+		rtest.Equals(t, want, m.memoryUsage())
+	}
+}
+
 func BenchmarkIndexMapHash(b *testing.B) {
 	var m indexMap
-	m.add(restic.ID{}, 0, 0, 0) // Trigger lazy initialization.
+	m.add(restic.ID{}, 0, 0, 0, 0) // Trigger lazy initialization.
 
 	ids := make([]restic.ID, 128) // 4 KiB.
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/restic/restic/internal/restic"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/restic/restic/internal/debug"
 )
@@ -126,6 +127,20 @@ func (mi *MasterIndex) Count(t restic.BlobType) (n uint) {
 	return sum
 }
 
+// MemoryUsage returns an estimate of the number of bytes used by all indexes
+// in memory.
+func (mi *MasterIndex) MemoryUsage() uint64 {
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
+
+	var size uint64
+	for _, idx := range mi.idx {
+		size += idx.MemoryUsage()
+	}
+
+	return size
+}
+
 // Insert adds a new index to the MasterIndex.
 func (mi *MasterIndex) Insert(idx *Index) {
 	mi.idxMutex.Lock()
@@ -241,10 +256,11 @@ func (mi *MasterIndex) Each(ctx context.Context) <-chan restic.PackedBlob {
 
 // MergeFinalIndexes merges all final indexes together.
 // After calling, there will be only one big final index in MasterIndex
-// containing all final index contents.
-// Indexes that are not final are left untouched.
-// This merging can only be called after all index files are loaded - as
-// removing of superseded index contents is only possible for unmerged indexes.
+// containing all final index contents, such that lookups only need to query
+// a single hash table per blob type. Indexes that are not final are left
+// untouched. The merged indexes are no longer referenced by the MasterIndex,
+// so calling this after inserting each loaded index file allows the memory
+// of the individual indexes to be freed right away.
 func (mi *MasterIndex) MergeFinalIndexes() {
 	mi.idxMutex.Lock()
 	defer mi.idxMutex.Unlock()
@@ -264,49 +280,90 @@ func (mi *MasterIndex) MergeFinalIndexes() {
 	mi.idx = newIdx
 }
 
-// RebuildIndex combines all known indexes to a new index, leaving out any
-// packs whose ID is contained in packBlacklist. The new index contains the IDs
-// of all known indexes in the "supersedes" field.
-func (mi *MasterIndex) RebuildIndex(packBlacklist restic.IDSet) (*Index, error) {
-	mi.idxMutex.Lock()
-	defer mi.idxMutex.Unlock()
+const saveIndexParallelism = 4
+
+// Save saves all known indexes to index files, leaving out any packs whose ID
+// is contained in packBlacklist. The new index files contain the IDs of all
+// known indexes in the "supersedes" field. The IDs are also returned in the
+// IDSet obsolete. After calling this function, you should remove the obsolete
+// index files.
+func (mi *MasterIndex) Save(ctx context.Context, repo restic.Repository, packBlacklist restic.IDSet, p *restic.Progress) (obsolete restic.IDSet, err error) {
+	p.Start()
+	defer p.Done()
+
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
 
 	debug.Log("start rebuilding index of %d indexes, pack blacklist: %v", len(mi.idx), packBlacklist)
 
 	newIndex := NewIndex()
+	obsolete = restic.NewIDSet()
+
+	// track spawned goroutines using wg, create a new context which is
+	// cancelled as soon as an error occurs.
+	wg, ctx := errgroup.WithContext(ctx)
+
+	ch := make(chan *Index)
+
+	wg.Go(func() error {
+		defer close(ch)
+		for i, idx := range mi.idx {
+			if idx.Final() {
+				ids, err := idx.IDs()
+				if err != nil {
+					debug.Log("index %d does not have an ID: %v", err)
+					return err
+				}
 
-	ctx, cancel := context.WithCancel(context.TODO())
-	defer cancel()
-
-	for i, idx := range mi.idx {
-		debug.Log("adding index %d", i)
+				debug.Log("adding index ids %v to supersedes field", ids)
 
-		for pb := range idx.Each(ctx) {
-			if packBlacklist.Has(pb.PackID) {
-				continue
+				err = newIndex.AddToSupersedes(ids...)
+				if err != nil {
+					return err
+				}
+				obsolete.Merge(restic.NewIDSet(ids...))
+			} else {
+				debug.Log("index %d isn't final, don't add to supersedes field", i)
 			}
 
-			newIndex.Store(pb)
+			for pbs := range idx.EachByPack(ctx, packBlacklist) {
+				newIndex.StorePack(pbs.PackID, pbs.Blobs)
+				p.Report(restic.Stat{Blobs: 1})
+				if IndexFull(newIndex) {
+					select {
+					case ch <- newIndex:
+					case <-ctx.Done():
+						return nil
+					}
+					newIndex = NewIndex()
+				}
+			}
 		}
 
-		if !idx.Final() {
-			debug.Log("index %d isn't final, don't add to supersedes field", i)
-			continue
+		select {
+		case ch <- newIndex:
+		case <-ctx.Done():
 		}
+		return nil
+	})
 
-		ids, err := idx.IDs()
-		if err != nil {
-			debug.Log("index %d does not have an ID: %v", err)
-			return nil, err
+	// a worker receives an index from ch, and saves the index
+	worker := func() error {
+		for idx := range ch {
+			idx.Finalize()
+			if _, err := SaveIndex(ctx, repo, idx); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
 
-		debug.Log("adding index ids %v to supersedes field", ids)
+	// run workers on ch
+	wg.Go(func() error {
+		return RunWorkers(saveIndexParallelism, worker, func() {})
+	})
 
-		err = newIndex.AddToSupersedes(ids...)
-		if err != nil {
-			return nil, err
-		}
-	}
+	err = wg.Wait()
 
-	return newIndex, nil
+	return obsolete, err
 }
@@ -213,6 +213,50 @@ func TestMasterMergeFinalIndexes(t *testing.T) {
 	rtest.Equals(t, 2, blobCount)
 }
 
+func TestMasterIndexSave(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	createRandomBlobs(t, repo, 100, 0.5)
+	reloadIndex(t, repo)
+	packs := listPacks(t, repo)
+	rtest.Assert(t, len(packs) > 1, "expected more than one pack, got %d", len(packs))
+
+	// leave out a single pack
+	var removedPack restic.ID
+	for id := range packs {
+		removedPack = id
+		break
+	}
+	removedBlobs := repo.Index().(*repository.MasterIndex).ListPack(removedPack)
+
+	var oldIndexes restic.IDs
+	rtest.OK(t, repo.List(context.TODO(), restic.IndexFile, func(id restic.ID, size int64) error {
+		oldIndexes = append(oldIndexes, id)
+		return nil
+	}))
+
+	obsolete, err := repo.Index().Save(context.TODO(), repo, restic.NewIDSet(removedPack), nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.NewIDSet(oldIndexes...), obsolete)
+
+	for id := range obsolete {
+		rtest.OK(t, repo.Backend().Remove(context.TODO(), restic.Handle{Type: restic.IndexFile, Name: id.String()}))
+	}
+
+	reloadIndex(t, repo)
+
+	for _, pb := range removedBlobs {
+		rtest.Assert(t, !repo.Index().Has(pb.ID, pb.Type), "blob %v from removed pack still in index", pb.ID.Str())
+	}
+	for id := range packs {
+		if id == removedPack {
+			continue
+		}
+		rtest.Assert(t, len(repo.Index().(*repository.MasterIndex).ListPack(id)) > 0, "pack %v missing in index", id.Str())
+	}
+}
+
 func createRandomMasterIndex(rng *rand.Rand, num, size int) (*repository.MasterIndex, restic.ID) {
 	mIdx := repository.NewMasterIndex()
 	for i := 0; i < num-1; i++ {
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"io/ioutil"
 	"os"
 	"sync"
 
@@ -31,20 +32,20 @@ type Packer struct {
 
 // packerManager keeps a list of open packs and creates new on demand.
 type packerManager struct {
-	be      Saver
-	key     *crypto.Key
-	pm      sync.Mutex
-	packers []*Packer
+	be       Saver
+	key      *crypto.Key
+	packSize uint // packs are written to the backend once they reach this size
+	pm       sync.Mutex
+	packers  []*Packer
 }
 
-const minPackSize = 4 * 1024 * 1024
-
 // newPackerManager returns an new packer manager which writes temporary files
 // to a temporary directory
-func newPackerManager(be Saver, key *crypto.Key) *packerManager {
+func newPackerManager(be Saver, key *crypto.Key, packSize uint) *packerManager {
 	return &packerManager{
-		be:  be,
-		key: key,
+		be:       be,
+		key:      key,
+		packSize: packSize,
 	}
 }
 
@@ -112,7 +113,26 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 
 	debug.Log("saved as %v", h)
 
-	if t == restic.TreeBlob && r.Cache != nil {
+	// the parity file is saved after the pack, so that a parity file without
+	// its pack is always left over from a deleted pack
+	if r.cfg.Parity != 0 {
+		_, err = p.tmpfile.Seek(0, 0)
+		if err != nil {
+			return errors.Wrap(err, "Seek")
+		}
+
+		buf, err := ioutil.ReadAll(p.tmpfile)
+		if err != nil {
+			return errors.Wrap(err, "ReadAll")
+		}
+
+		err = saveParity(ctx, r.be, id, buf, r.cfg.Parity)
+		if err != nil {
+			return err
+		}
+	}
+
+	if t == restic.TreeBlob && r.Cache != nil && !r.dryRun {
 		debug.Log("saving tree pack file in cache")
 
 		_, err = p.tmpfile.Seek(0, 0)
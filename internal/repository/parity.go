@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/parity"
+	"github.com/restic/restic/internal/restic"
+)
+
+// saveParity computes the parity data for the pack with the given ID and
+// stores it in the backend.
+func saveParity(ctx context.Context, be restic.Backend, id restic.ID, pack []byte, percent uint) error {
+	buf, err := parity.Encode(pack, percent)
+	if err != nil {
+		return err
+	}
+
+	h := restic.Handle{Type: restic.ParityFile, Name: id.String()}
+	err = be.Save(ctx, h, restic.NewByteReader(buf))
+	if err != nil {
+		debug.Log("Save(%v) error: %v", h, err)
+		return err
+	}
+
+	debug.Log("saved parity for pack %v (%d bytes)", id, len(buf))
+	return nil
+}
+
+// loadRepairedPack loads the pack with the given ID and repairs it using its
+// parity file. The repaired pack is verified against the ID.
+func loadRepairedPack(ctx context.Context, be restic.Backend, id restic.ID) ([]byte, error) {
+	// the backend retries loading missing files, so check for them first
+	var files [2][]byte
+	for i, tpe := range []restic.FileType{restic.PackFile, restic.ParityFile} {
+		h := restic.Handle{Type: tpe, Name: id.String()}
+		ok, err := be.Test(ctx, h)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errors.Errorf("%v does not exist", h)
+		}
+
+		files[i], err = backend.LoadAll(ctx, nil, be, h)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pack, par := files[0], files[1]
+
+	buf, err := parity.Reconstruct(pack, par)
+	if err != nil {
+		return nil, errors.Wrapf(err, "repairing pack %v", id.Str())
+	}
+
+	if !restic.Hash(buf).Equal(id) {
+		return nil, errors.Errorf("repaired pack %v does not match its ID", id.Str())
+	}
+
+	debug.Log("reconstructed pack %v from parity data", id)
+	return buf, nil
+}
+
+// loadVerified loads the file h and returns its content if it matches id. For
+// a missing or damaged file, buf is nil and exists reports whether the file is
+// present in the backend.
+func loadVerified(ctx context.Context, be restic.Backend, h restic.Handle, id restic.ID) (buf []byte, exists bool, err error) {
+	exists, err = be.Test(ctx, h)
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+
+	buf, err = backend.LoadAll(ctx, nil, be, h)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if !restic.Hash(buf).Equal(id) {
+		debug.Log("%v does not match its ID", h)
+		return nil, true, nil
+	}
+
+	return buf, true, nil
+}
+
+// replaceVerified stores buf as the file h, an existing file is removed first.
+// The file is loaded again afterwards and verified against id.
+func replaceVerified(ctx context.Context, be restic.Backend, h restic.Handle, id restic.ID, buf []byte) error {
+	// backends do not overwrite existing files
+	exists, err := be.Test(ctx, h)
+	if err != nil {
+		return err
+	}
+	if exists {
+		err = be.Remove(ctx, h)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = be.Save(ctx, h, restic.NewByteReader(buf))
+	if err != nil {
+		return err
+	}
+
+	saved, _, err := loadVerified(ctx, be, h, id)
+	if err != nil {
+		return err
+	}
+	if saved == nil {
+		return errors.Errorf("%v does not match its ID after saving", h)
+	}
+
+	return nil
+}
+
+// RepairPack repairs the pack with the given ID using its parity file and
+// replaces the damaged pack in the backend.
+//
+// Backends cannot overwrite files, so the damaged pack has to be removed
+// before the repaired one is saved. To not lose the pack should this fail,
+// the repaired pack is first stored and verified as a repair file, which is
+// only removed once the replacement has been verified. A repair file left over
+// by an interrupted run is used to finish the repair, even if the pack is
+// missing by then.
+func RepairPack(ctx context.Context, repo restic.Repository, id restic.ID) error {
+	be := repo.Backend()
+	rh := restic.Handle{Type: restic.RepairFile, Name: id.String()}
+
+	buf, _, err := loadVerified(ctx, be, rh, id)
+	if err != nil {
+		return err
+	}
+
+	if buf == nil {
+		buf, err = loadRepairedPack(ctx, be, id)
+		if err != nil {
+			return err
+		}
+
+		err = replaceVerified(ctx, be, rh, id, buf)
+		if err != nil {
+			return errors.Wrap(err, "saving repair file")
+		}
+	} else {
+		debug.Log("using repair file left over for pack %v", id)
+	}
+
+	h := restic.Handle{Type: restic.PackFile, Name: id.String()}
+	err = replaceVerified(ctx, be, h, id, buf)
+	if err != nil {
+		return errors.Wrapf(err, "replacing pack, the repaired pack is kept in %v", rh)
+	}
+
+	return be.Remove(ctx, rh)
+}
+
+// CheckParity checks the parity file for the pack with the given ID and size.
+// A missing or damaged parity file is computed again from the pack, repaired
+// is then true. The pack is verified against its ID before it is used.
+func CheckParity(ctx context.Context, repo restic.Repository, id restic.ID, size int64) (repaired bool, err error) {
+	be := repo.Backend()
+	h := restic.Handle{Type: restic.ParityFile, Name: id.String()}
+
+	exists, err := be.Test(ctx, h)
+	if err != nil {
+		return false, err
+	}
+
+	if exists {
+		par, err := backend.LoadAll(ctx, nil, be, h)
+		if err != nil {
+			return false, err
+		}
+
+		err = parity.Verify(par, size)
+		if err == nil {
+			return false, nil
+		}
+		debug.Log("parity for pack %v is damaged: %v", id, err)
+	}
+
+	pack, err := backend.LoadAll(ctx, nil, be, restic.Handle{Type: restic.PackFile, Name: id.String()})
+	if err != nil {
+		return false, err
+	}
+
+	if !restic.Hash(pack).Equal(id) {
+		return false, errors.Errorf("pack %v does not match its ID", id.Str())
+	}
+
+	buf, err := parity.Encode(pack, repo.Config().Parity)
+	if err != nil {
+		return false, err
+	}
+
+	// Backends do not overwrite existing files, so the damaged parity file is
+	// removed once the new parity data has been computed. Should saving it
+	// fail, the parity file is missing and is computed again from the intact
+	// pack by the next check.
+	if exists {
+		err = be.Remove(ctx, h)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = be.Save(ctx, h, restic.NewByteReader(buf))
+	if err != nil {
+		return false, err
+	}
+
+	debug.Log("saved parity for pack %v (%d bytes)", id, len(buf))
+	return true, nil
+}
@@ -0,0 +1,105 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func listIDs(t testing.TB, repo restic.Repository, tpe restic.FileType) (ids restic.IDs) {
+	rtest.OK(t, repo.List(context.TODO(), tpe, func(id restic.ID, size int64) error {
+		ids = append(ids, id)
+		return nil
+	}))
+	return ids
+}
+
+func loadFile(t testing.TB, be restic.Backend, h restic.Handle) (buf []byte) {
+	rtest.OK(t, be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) (err error) {
+		buf, err = ioutil.ReadAll(rd)
+		return err
+	}))
+	return buf
+}
+
+func replaceFile(t testing.TB, be restic.Backend, h restic.Handle, buf []byte) {
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(buf)))
+}
+
+func TestRepositoryParity(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx := context.TODO()
+	repo := r.(*repository.Repository)
+	cfg := repo.Config()
+	cfg.Parity = 10
+	rtest.OK(t, repo.SaveConfig(ctx, cfg))
+
+	data := rtest.Random(23, 1<<20)
+	id, _, err := repo.SaveBlob(ctx, restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(ctx))
+
+	packs := listIDs(t, repo, restic.PackFile)
+	rtest.Equals(t, 1, len(packs))
+	rtest.Equals(t, packs, listIDs(t, repo, restic.ParityFile))
+	packID := packs[0]
+
+	// damage the pack
+	be := repo.Backend()
+	h := restic.Handle{Type: restic.PackFile, Name: packID.String()}
+	pack := loadFile(t, be, h)
+	damaged := append([]byte(nil), pack...)
+	damaged[len(damaged)/2] ^= 0xff
+	replaceFile(t, be, h, damaged)
+
+	// the blob is reconstructed on the fly
+	buf, err := repo.LoadBlob(ctx, restic.DataBlob, id, nil)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(data, buf), "loaded blob differs")
+
+	rtest.OK(t, repository.RepairPack(ctx, repo, packID))
+	rtest.Assert(t, bytes.Equal(pack, loadFile(t, be, h)), "repaired pack differs")
+	rtest.Equals(t, 0, len(listIDs(t, repo, restic.RepairFile)))
+
+	// a repair which was interrupted after the damaged pack was removed is
+	// finished using the repair file
+	rh := restic.Handle{Type: restic.RepairFile, Name: packID.String()}
+	rtest.OK(t, be.Remove(ctx, h))
+	rtest.OK(t, be.Save(ctx, rh, restic.NewByteReader(pack)))
+	rtest.OK(t, repository.RepairPack(ctx, repo, packID))
+	rtest.Assert(t, bytes.Equal(pack, loadFile(t, be, h)), "pack restored from repair file differs")
+	rtest.Equals(t, 0, len(listIDs(t, repo, restic.RepairFile)))
+
+	repaired, err := repository.CheckParity(ctx, repo, packID, int64(len(pack)))
+	rtest.OK(t, err)
+	rtest.Assert(t, !repaired, "intact parity file was repaired")
+
+	// a missing parity file is created again
+	ph := restic.Handle{Type: restic.ParityFile, Name: packID.String()}
+	rtest.OK(t, be.Remove(ctx, ph))
+	repaired, err = repository.CheckParity(ctx, repo, packID, int64(len(pack)))
+	rtest.OK(t, err)
+	rtest.Assert(t, repaired, "missing parity file was not repaired")
+
+	// a pack which cannot be reconstructed is reported
+	damaged = append([]byte(nil), pack...)
+	for i := range damaged {
+		damaged[i] ^= 0xff
+	}
+	replaceFile(t, be, h, damaged)
+
+	_, err = repo.LoadBlob(ctx, restic.DataBlob, id, nil)
+	rtest.Assert(t, err != nil, "blob from destroyed pack loaded without error")
+	rtest.Assert(t, repository.RepairPack(ctx, repo, packID) != nil, "destroyed pack was repaired")
+	rtest.Equals(t, 0, len(listIDs(t, repo, restic.RepairFile)))
+	rtest.Assert(t, bytes.Equal(damaged, loadFile(t, be, h)), "destroyed pack was modified")
+}
@@ -12,14 +12,11 @@ import (
 
 // Repack takes a list of packs together with a list of blobs contained in
 // these packs. Each pack is loaded and the blobs listed in keepBlobs is saved
-// into a new pack. Returned is the list of obsolete packs which can then
-// be removed.
+// into a new pack. Blobs which have been saved are removed from keepBlobs.
+// Returned is the list of obsolete packs which can then be removed.
+//
+// Each processed pack is reported to p, which must already be running.
 func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, keepBlobs restic.BlobSet, p *restic.Progress) (obsoletePacks restic.IDSet, err error) {
-	if p != nil {
-		p.Start()
-		defer p.Done()
-	}
-
 	debug.Log("repacking %d packs while keeping %d blobs", len(packs), len(keepBlobs))
 
 	for packID := range packs {
@@ -78,6 +75,11 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 				return nil, err
 			}
 
+			plaintext, err = DecompressBlob(entry, plaintext)
+			if err != nil {
+				return nil, err
+			}
+
 			id := restic.Hash(plaintext)
 			if !id.Equal(entry.ID) {
 				debug.Log("read blob %v/%v from %v: wrong data returned, hash is %v",
@@ -104,9 +106,7 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 		if err = fs.RemoveIfExists(tempfile.Name()); err != nil {
 			return nil, errors.Wrap(err, "Remove")
 		}
-		if p != nil {
-			p.Report(restic.Stat{Blobs: 1})
-		}
+		p.Report(restic.Stat{Blobs: 1})
 	}
 
 	if err := repo.Flush(ctx); err != nil {
@@ -9,12 +9,14 @@ import (
 	"os"
 
 	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/hashing"
+	"github.com/restic/restic/internal/options"
 	"github.com/restic/restic/internal/pack"
 	"github.com/restic/restic/internal/restic"
 
@@ -31,7 +33,15 @@ type Repository struct {
 	idx     *MasterIndex
 	Cache   *cache.Cache
 
+	// Warnf is called for problems which do not prevent an operation from
+	// succeeding. It may be nil.
+	Warnf func(format string, args ...interface{})
+
 	noAutoIndexUpdate bool
+	dryRun            bool
+
+	// packSize overrides the pack size from the config if it is not zero
+	packSize uint
 
 	treePM *packerManager
 	dataPM *packerManager
@@ -42,13 +52,39 @@ func New(be restic.Backend) *Repository {
 	repo := &Repository{
 		be:     be,
 		idx:    NewMasterIndex(),
-		dataPM: newPackerManager(be, nil),
-		treePM: newPackerManager(be, nil),
+		dataPM: newPackerManager(be, nil, restic.DefaultPackSize),
+		treePM: newPackerManager(be, nil, restic.DefaultPackSize),
 	}
 
 	return repo
 }
 
+// Options holds the repository options which can be set with -o.
+type Options struct {
+	PackSize uint `option:"pack-size" help:"target size of pack files in MiB, overrides the size stored in the repository config"`
+}
+
+func init() {
+	options.Register("repository", Options{})
+}
+
+// ApplyOptions configures the repository according to opts.
+func (r *Repository) ApplyOptions(opts Options) error {
+	if opts.PackSize != 0 {
+		size, err := restic.PackSizeFromMiB(opts.PackSize)
+		if err != nil {
+			return err
+		}
+
+		err = r.SetPackSize(size)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // DisableAutoIndexUpdate deactives the automatic finalization and upload of new
 // indexes once these are full
 func (r *Repository) DisableAutoIndexUpdate() {
@@ -60,6 +96,188 @@ func (r *Repository) Config() restic.Config {
 	return r.cfg
 }
 
+// PackSize returns the target size of pack files. It is taken from the
+// repository config unless it was overridden with SetPackSize.
+func (r *Repository) PackSize() uint {
+	switch {
+	case r.packSize != 0:
+		return r.packSize
+	case r.cfg.PackSize != 0:
+		return r.cfg.PackSize
+	default:
+		return restic.DefaultPackSize
+	}
+}
+
+// SetPackSize overrides the target size of pack files stored in the
+// repository config. The config itself is not modified.
+func (r *Repository) SetPackSize(size uint) error {
+	if err := restic.CheckPackSize(size); err != nil {
+		return err
+	}
+
+	r.packSize = size
+	r.updatePackSize()
+	return nil
+}
+
+// updatePackSize passes the current target pack size on to the packer
+// managers.
+func (r *Repository) updatePackSize() {
+	debug.Log("using pack size %d", r.PackSize())
+	r.dataPM.packSize = r.PackSize()
+	r.treePM.packSize = r.PackSize()
+}
+
+// configCopy is the handle of the copy of the old config which SaveConfig
+// keeps while the config is replaced.
+var configCopy = restic.Handle{Type: restic.RepairFile, Name: "config"}
+
+// SaveConfig replaces the config file in the backend with cfg and uses cfg
+// from now on. As backends do not overwrite files, the old config file is
+// removed first. If the new config cannot be saved, the old one is restored.
+//
+// Should restic be interrupted in between, the repository has no config file
+// any more. SaveConfig therefore tries to keep a copy of the old config file
+// until the new one has been saved, the copy is used instead of the missing
+// config and restored by RestoreConfig. If the backend cannot store the copy,
+// only a warning is printed. The repository must be locked exclusively.
+func (r *Repository) SaveConfig(ctx context.Context, cfg restic.Config) error {
+	h := restic.Handle{Type: restic.ConfigFile}
+	old, err := backend.LoadAll(ctx, nil, r.be, h)
+	if err != nil {
+		return err
+	}
+
+	err = r.saveConfigCopy(ctx, old)
+	if err != nil {
+		debug.Log("saving copy of the old config failed: %v", err)
+		r.warnf("unable to save a copy of the old config, an interruption now leaves the repository without config: %v\n", err)
+	}
+
+	err = r.be.Remove(ctx, h)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
+	if err != nil {
+		debug.Log("saving new config failed: %v", err)
+		if rerr := r.be.Save(ctx, h, restic.NewByteReader(old)); rerr != nil {
+			return errors.Errorf("saving config failed: %v, restoring the old config failed as well: %v", err, rerr)
+		}
+		return err
+	}
+
+	r.cfg = cfg
+	r.updatePackSize()
+
+	// the copy is only used while the config file is missing
+	if err := r.be.Remove(ctx, configCopy); err != nil {
+		debug.Log("removing copy of the old config failed: %v", err)
+	}
+
+	return nil
+}
+
+// saveConfigCopy saves buf as the copy of the config, replacing an old copy,
+// and verifies it.
+func (r *Repository) saveConfigCopy(ctx context.Context, buf []byte) error {
+	exists, err := r.be.Test(ctx, configCopy)
+	if err != nil {
+		return err
+	}
+	if exists {
+		err = r.be.Remove(ctx, configCopy)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = r.be.Save(ctx, configCopy, restic.NewByteReader(buf))
+	if err != nil {
+		return err
+	}
+
+	saved, err := backend.LoadAll(ctx, nil, r.be, configCopy)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(buf, saved) {
+		return errors.New("copy of the config differs after saving")
+	}
+
+	return nil
+}
+
+// warnf calls r.Warnf if it is set.
+func (r *Repository) warnf(format string, args ...interface{}) {
+	if r.Warnf != nil {
+		r.Warnf(format, args...)
+	}
+}
+
+// loadConfigCopy loads the copy of the old config which is kept by SaveConfig.
+func (r *Repository) loadConfigCopy(ctx context.Context) (restic.Config, error) {
+	return restic.LoadConfig(ctx, configCopyLoader{r})
+}
+
+// configCopyLoader loads the copy of the config instead of the config file.
+type configCopyLoader struct {
+	r *Repository
+}
+
+func (l configCopyLoader) LoadJSONUnpacked(ctx context.Context, t restic.FileType, id restic.ID, item interface{}) error {
+	buf, err := backend.LoadAll(ctx, nil, l.r.be, configCopy)
+	if err != nil {
+		return err
+	}
+
+	if len(buf) < l.r.key.NonceSize() {
+		return errors.New("copy of the config is too short")
+	}
+
+	nonce, ciphertext := buf[:l.r.key.NonceSize()], buf[l.r.key.NonceSize():]
+	plaintext, err := l.r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(plaintext, item)
+}
+
+// RestoreConfig restores the copy of the old config if SaveConfig was
+// interrupted after it removed the config file. The repository must be locked
+// exclusively.
+func (r *Repository) RestoreConfig(ctx context.Context) error {
+	h := restic.Handle{Type: restic.ConfigFile}
+	exists, err := r.be.Test(ctx, h)
+	if err != nil || exists {
+		return err
+	}
+
+	exists, err = r.be.Test(ctx, configCopy)
+	if err != nil || !exists {
+		return err
+	}
+
+	buf, err := backend.LoadAll(ctx, nil, r.be, configCopy)
+	if err != nil {
+		return err
+	}
+
+	debug.Log("config is missing, restoring it from %v", configCopy)
+	err = r.be.Save(ctx, h, restic.NewByteReader(buf))
+	if err != nil {
+		return err
+	}
+
+	if err := r.be.Remove(ctx, configCopy); err != nil {
+		debug.Log("removing copy of the old config failed: %v", err)
+	}
+	return nil
+}
+
 // UseCache replaces the backend with the wrapped cache.
 func (r *Repository) UseCache(c *cache.Cache) {
 	if c == nil {
@@ -70,6 +288,15 @@ func (r *Repository) UseCache(c *cache.Cache) {
 	r.be = c.Wrap(r.be)
 }
 
+// SetDryRun sets the repository into dry-run mode: blobs are processed and
+// added to the in-memory index as usual, but no files are written to or
+// removed from the backend. Lock files are not affected.
+func (r *Repository) SetDryRun() {
+	debug.Log("enable dry-run mode")
+	r.dryRun = true
+	r.be = backend.NewDryRunBackend(r.be)
+}
+
 // PrefixLength returns the number of bytes required so that all prefixes of
 // all IDs of type t are unique.
 func (r *Repository) PrefixLength(t restic.FileType) (int, error) {
@@ -177,37 +404,31 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 			buf = buf[:blob.Length]
 		}
 
-		n, err := restic.ReadAt(ctx, r.be, h, int64(blob.Offset), buf)
-		if err != nil {
-			debug.Log("error loading blob %v: %v", blob, err)
-			lastError = err
-			continue
-		}
-
-		if uint(n) != blob.Length {
-			lastError = errors.Errorf("error loading blob %v: wrong length returned, want %d, got %d",
-				id.Str(), blob.Length, uint(n))
-			debug.Log("lastError: %v", lastError)
-			continue
+		plaintext, err := r.readBlob(ctx, h, blob, buf)
+		if err != nil && r.cfg.Parity != 0 {
+			debug.Log("loading blob %v failed, trying to repair pack: %v", blob, err)
+			var rerr error
+			buf, rerr = r.readRepairedBlob(ctx, blob, buf)
+			if rerr == nil {
+				plaintext, err = r.decodeBlob(blob, buf)
+			} else {
+				debug.Log("repairing pack %v failed: %v", blob.PackID, rerr)
+			}
 		}
-
-		// decrypt
-		nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
-		plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
 		if err != nil {
-			lastError = errors.Errorf("decrypting blob %v failed: %v", id, err)
+			lastError = err
 			continue
 		}
 
-		// check hash
-		if !restic.Hash(plaintext).Equal(id) {
-			lastError = errors.Errorf("blob %v returned invalid hash", id)
-			continue
+		if cap(buf) < len(plaintext) {
+			// the decompressed data does not fit into the buffer
+			return plaintext, nil
 		}
 
 		// move decrypted data to the start of the buffer
+		buf = buf[:len(plaintext)]
 		copy(buf, plaintext)
-		return buf[:len(plaintext)], nil
+		return buf, nil
 	}
 
 	if lastError != nil {
@@ -217,6 +438,61 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 	return nil, errors.Errorf("loading blob %v from %v packs failed", id.Str(), len(blobs))
 }
 
+// readBlob reads the blob from the pack file h into buf and decodes it. The
+// returned plaintext is stored in buf, unless it does not fit.
+func (r *Repository) readBlob(ctx context.Context, h restic.Handle, blob restic.PackedBlob, buf []byte) ([]byte, error) {
+	n, err := restic.ReadAt(ctx, r.be, h, int64(blob.Offset), buf)
+	if err != nil {
+		debug.Log("error loading blob %v: %v", blob, err)
+		return nil, err
+	}
+
+	if uint(n) != blob.Length {
+		err = errors.Errorf("error loading blob %v: wrong length returned, want %d, got %d",
+			blob.ID.Str(), blob.Length, uint(n))
+		debug.Log("error: %v", err)
+		return nil, err
+	}
+
+	return r.decodeBlob(blob, buf)
+}
+
+// readRepairedBlob reconstructs the pack containing the blob from its parity
+// data and copies the blob into buf.
+func (r *Repository) readRepairedBlob(ctx context.Context, blob restic.PackedBlob, buf []byte) ([]byte, error) {
+	pack, err := loadRepairedPack(ctx, r.be, blob.PackID)
+	if err != nil {
+		return nil, err
+	}
+
+	if uint(len(pack)) < blob.Offset+blob.Length {
+		return nil, errors.Errorf("blob %v is not contained in pack %v", blob.ID.Str(), blob.PackID.Str())
+	}
+
+	return buf[:copy(buf, pack[blob.Offset:])], nil
+}
+
+// decodeBlob decrypts and decompresses the blob stored in buf and verifies
+// its ID.
+func (r *Repository) decodeBlob(blob restic.PackedBlob, buf []byte) ([]byte, error) {
+	nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
+	plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.Errorf("decrypting blob %v failed: %v", blob.ID, err)
+	}
+
+	plaintext, err = DecompressBlob(blob.Blob, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	if !restic.Hash(plaintext).Equal(blob.ID) {
+		return nil, errors.Errorf("blob %v returned invalid hash", blob.ID)
+	}
+
+	return plaintext, nil
+}
+
 // LoadJSONUnpacked decrypts the data and afterwards calls json.Unmarshal on
 // the item.
 func (r *Repository) LoadJSONUnpacked(ctx context.Context, t restic.FileType, id restic.ID, item interface{}) (err error) {
@@ -234,11 +510,17 @@ func (r *Repository) LookupBlobSize(id restic.ID, tpe restic.BlobType) (uint, bo
 }
 
 // SaveAndEncrypt encrypts data and stores it to the backend as type t. If data
-// is small enough, it will be packed together with other small blobs.
+// is small enough, it will be packed together with other small blobs. For
+// repositories with version 2 or later, data is compressed before encryption.
 // The caller must ensure that the id matches the data.
 func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data []byte, id restic.ID) error {
 	debug.Log("save id %v (%v, %d bytes)", id, t, len(data))
 
+	uncompressedLength := 0
+	if r.cfg.Version > 1 {
+		data, uncompressedLength = compressBlob(data)
+	}
+
 	nonce := crypto.NewRandomNonce()
 
 	ciphertext := make([]byte, 0, restic.CiphertextLength(len(data)))
@@ -265,13 +547,13 @@ func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data
 	}
 
 	// save ciphertext
-	_, err = packer.Add(t, id, ciphertext)
+	_, err = packer.Add(t, id, ciphertext, uncompressedLength)
 	if err != nil {
 		return err
 	}
 
 	// if the pack is not full enough, put back to the list
-	if packer.Size() < minPackSize {
+	if packer.Size() < pm.packSize {
 		debug.Log("pack is not full enough (%d bytes)", packer.Size())
 		pm.insertPacker(packer)
 		return nil
@@ -382,11 +664,17 @@ func (r *Repository) SetIndex(i restic.MasterIndex) error {
 	return r.PrepareCache(ids)
 }
 
-// SaveIndex saves an index in the repository.
+// SaveIndex saves an index in the repository. Repositories with version 3 or
+// later use the binary index format.
 func SaveIndex(ctx context.Context, repo restic.Repository, index *Index) (restic.ID, error) {
 	buf := bytes.NewBuffer(nil)
 
-	err := index.Encode(buf)
+	var err error
+	if repo.Config().Version >= 3 {
+		err = index.EncodeBinary(buf)
+	} else {
+		err = index.Encode(buf)
+	}
 	if err != nil {
 		return restic.ID{}, err
 	}
@@ -405,6 +693,11 @@ func (r *Repository) saveIndex(ctx context.Context, indexes ...*Index) error {
 		}
 
 		debug.Log("Saved index %d as %v", i, sid)
+
+		// remember the ID, the index may be superseded later on
+		if err := idx.SetID(sid); err != nil {
+			return err
+		}
 	}
 	r.idx.MergeFinalIndexes()
 
@@ -497,9 +790,11 @@ func (r *Repository) LoadIndex(ctx context.Context) error {
 				}
 			}
 
+			// merge each index right away, so that only a single copy of
+			// the index entries is kept in memory
 			r.idx.Insert(idx)
+			r.idx.MergeFinalIndexes()
 		}
-		r.idx.MergeFinalIndexes()
 		return nil
 	})
 
@@ -508,6 +803,8 @@ func (r *Repository) LoadIndex(ctx context.Context) error {
 		return errors.Fatal(err.Error())
 	}
 
+	debug.Log("index uses %d bytes of memory", r.idx.MemoryUsage())
+
 	// remove index files from the cache which have been removed in the repo
 	err = r.PrepareCache(validIndex)
 	if err != nil {
@@ -606,16 +903,51 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 	r.dataPM.key = key.master
 	r.treePM.key = key.master
 	r.keyName = key.Name()
+
 	r.cfg, err = restic.LoadConfig(ctx, r)
 	if err != nil {
-		return errors.Fatalf("config cannot be loaded: %v", err)
+		// SaveConfig may have been interrupted after it removed the config
+		// file, the copy is used until RestoreConfig runs
+		cfg, cerr := r.loadConfigCopy(ctx)
+		if cerr != nil {
+			return errors.Fatalf("config cannot be loaded: %v", err)
+		}
+		debug.Log("config cannot be loaded, using the copy of the old config: %v", err)
+		r.cfg = cfg
 	}
+	r.updatePackSize()
 	return nil
 }
 
 // Init creates a new master key with the supplied password, initializes and
-// saves the repository config.
-func (r *Repository) Init(ctx context.Context, password string, chunkerPolynomial *chunker.Pol) error {
+// saves the repository config using the given repository format version. If
+// chunkerSizes is not zero, the sizes are stored in the config. If packSize
+// is not zero, it is stored in the config as the target size of pack
+// files. If parity is not zero, parity data of that many percent of the pack
+// size is stored for each pack.
+func (r *Repository) Init(ctx context.Context, version uint, password string, chunkerPolynomial *chunker.Pol, chunkerSizes restic.ChunkerSizes, packSize, parity uint) error {
+	if version < restic.MinRepoVersion || version > restic.MaxRepoVersion {
+		return errors.Fatalf("unsupported repository version %v", version)
+	}
+
+	if packSize != 0 {
+		if err := restic.CheckPackSize(packSize); err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
+	if parity != 0 {
+		if err := restic.CheckParity(parity); err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
+	if chunkerSizes != (restic.ChunkerSizes{}) {
+		if err := restic.CheckChunkerSizes(chunkerSizes); err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
 	has, err := r.be.Test(ctx, restic.Handle{Type: restic.ConfigFile})
 	if err != nil {
 		return err
@@ -624,13 +956,18 @@ func (r *Repository) Init(ctx context.Context, password string, chunkerPolynomia
 		return errors.New("repository master key and config already initialized")
 	}
 
-	cfg, err := restic.CreateConfig()
+	cfg, err := restic.CreateConfig(version)
 	if err != nil {
 		return err
 	}
 	if chunkerPolynomial != nil {
 		cfg.ChunkerPolynomial = *chunkerPolynomial
 	}
+	cfg.ChunkerMinSize = chunkerSizes.Min
+	cfg.ChunkerAvgSize = chunkerSizes.Avg
+	cfg.ChunkerMaxSize = chunkerSizes.Max
+	cfg.PackSize = packSize
+	cfg.Parity = parity
 
 	return r.init(ctx, password, cfg)
 }
@@ -648,6 +985,7 @@ func (r *Repository) init(ctx context.Context, password string, cfg restic.Confi
 	r.treePM.key = key.master
 	r.keyName = key.Name()
 	r.cfg = cfg
+	r.updatePackSize()
 	_, err = r.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
 	return err
 }
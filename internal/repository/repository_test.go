@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"fmt"
 	"io"
 	"math/rand"
 	"path/filepath"
@@ -90,6 +91,39 @@ func TestSaveFrom(t *testing.T) {
 	}
 }
 
+func TestSaveCompressible(t *testing.T) {
+	for _, version := range []uint{1, 2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			repo, cleanup := repository.TestRepositoryWithVersion(t, version)
+			defer cleanup()
+
+			data := bytes.Repeat([]byte("compressible data "), 10000)
+			id := restic.Hash(data)
+
+			_, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, id, false)
+			rtest.OK(t, err)
+			rtest.OK(t, repo.Flush(context.Background()))
+
+			blobs := repo.Index().Lookup(id, restic.DataBlob)
+			rtest.Equals(t, 1, len(blobs))
+			rtest.Equals(t, version > 1, blobs[0].IsCompressed())
+			if version > 1 {
+				rtest.Assert(t, blobs[0].Length < uint(len(data)),
+					"blob was not compressed, length %d", blobs[0].Length)
+			}
+			rtest.Equals(t, uint(len(data)), blobs[0].DataLength())
+
+			size, found := repo.LookupBlobSize(id, restic.DataBlob)
+			rtest.Assert(t, found, "blob not found in index")
+			rtest.Equals(t, uint(len(data)), size)
+
+			buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+			rtest.OK(t, err)
+			rtest.Assert(t, bytes.Equal(buf, data), "data does not match")
+		})
+	}
+}
+
 func BenchmarkSaveAndEncrypt(t *testing.B) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
@@ -389,6 +423,184 @@ func TestRepositoryIncrementalIndex(t *testing.T) {
 	}
 }
 
+func TestRepositoryLoadIndexMerged(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	repo := r.(*repository.Repository)
+
+	defer func(indexFull func(*repository.Index) bool) {
+		repository.IndexFull = indexFull
+	}(repository.IndexFull)
+	repository.IndexFull = func(*repository.Index) bool { return true }
+
+	// write five index files
+	for i := 0; i < 5; i++ {
+		saveRandomDataBlobs(t, repo, 5, 1<<15)
+		rtest.OK(t, repo.FlushPacks(context.Background()))
+		rtest.OK(t, repo.SaveFullIndex(context.TODO()))
+	}
+
+	repo2 := repository.New(repo.Backend())
+	rtest.OK(t, repo2.SearchKey(context.TODO(), rtest.TestPassword, 0, ""))
+	rtest.OK(t, repo2.LoadIndex(context.TODO()))
+
+	// all index files are merged into a single index
+	mi := repo2.Index().(*repository.MasterIndex)
+	rtest.Equals(t, 1, len(mi.All()))
+	rtest.Equals(t, repo.Index().Count(restic.DataBlob), mi.Count(restic.DataBlob))
+
+	ids, err := mi.All()[0].IDs()
+	rtest.OK(t, err)
+	rtest.Equals(t, 5, len(ids))
+
+	rtest.Assert(t, mi.MemoryUsage() > 0, "memory usage of index not reported")
+}
+
+func TestRepositoryPackSize(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	repo := r.(*repository.Repository)
+	rtest.Equals(t, uint(restic.DefaultPackSize), repo.PackSize())
+	rtest.Assert(t, repo.SetPackSize(1024) != nil, "invalid pack size accepted")
+
+	const packSize = 8 * 1024 * 1024
+	rtest.OK(t, repo.SetPackSize(packSize))
+	rtest.Equals(t, uint(packSize), repo.PackSize())
+
+	// save 10 MiB of incompressible data
+	buf := make([]byte, 512*1024)
+	for i := 0; i < 20; i++ {
+		_, err := io.ReadFull(rnd, buf)
+		rtest.OK(t, err)
+		_, _, err = repo.SaveBlob(context.TODO(), restic.DataBlob, buf, restic.ID{}, false)
+		rtest.OK(t, err)
+	}
+	rtest.OK(t, repo.Flush(context.TODO()))
+
+	var sizes []int64
+	rtest.OK(t, repo.List(context.TODO(), restic.PackFile, func(id restic.ID, size int64) error {
+		sizes = append(sizes, size)
+		return nil
+	}))
+
+	rtest.Equals(t, 2, len(sizes))
+	rtest.Assert(t, sizes[0] >= packSize || sizes[1] >= packSize,
+		"no pack reached the target size, sizes: %v", sizes)
+}
+
+func TestRepositoryBinaryIndex(t *testing.T) {
+	for _, version := range []uint{2, 3} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			r, cleanup := repository.TestRepositoryWithVersion(t, version)
+			defer cleanup()
+
+			repo := r.(*repository.Repository)
+			saveRandomDataBlobs(t, repo, 20, 1<<15)
+			rtest.OK(t, repo.Flush(context.TODO()))
+
+			var ids restic.IDs
+			rtest.OK(t, repo.List(context.TODO(), restic.IndexFile, func(id restic.ID, size int64) error {
+				ids = append(ids, id)
+				return nil
+			}))
+			rtest.Equals(t, 1, len(ids))
+
+			buf, err := repo.LoadAndDecrypt(context.TODO(), nil, restic.IndexFile, ids[0])
+			rtest.OK(t, err)
+			isBinary := bytes.HasPrefix(buf, []byte("RIDX"))
+			rtest.Assert(t, isBinary == (version >= 3),
+				"repository version %d stored index in wrong format, binary: %v", version, isBinary)
+
+			repo2 := repository.New(repo.Backend())
+			rtest.OK(t, repo2.SearchKey(context.TODO(), rtest.TestPassword, 0, ""))
+			rtest.OK(t, repo2.LoadIndex(context.TODO()))
+
+			for pb := range repo.Index().Each(context.TODO()) {
+				rtest.Assert(t, repo2.Index().Has(pb.ID, pb.Type), "blob %v missing from loaded index", pb.ID.Str())
+			}
+		})
+	}
+}
+
+func TestRepositorySaveConfig(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx := context.TODO()
+	repo := r.(*repository.Repository)
+	be := repo.Backend()
+	h := restic.Handle{Type: restic.ConfigFile}
+	copyHandle := restic.Handle{Type: restic.RepairFile, Name: "config"}
+
+	cfg := repo.Config()
+	cfg.Parity = 10
+	rtest.OK(t, repo.SaveConfig(ctx, cfg))
+	rtest.Equals(t, cfg, repo.Config())
+
+	exists, err := be.Test(ctx, copyHandle)
+	rtest.OK(t, err)
+	rtest.Assert(t, !exists, "copy of the old config was not removed")
+
+	// simulate an interrupted SaveConfig
+	buf := loadFile(t, be, h)
+	rtest.OK(t, be.Save(ctx, copyHandle, restic.NewByteReader(buf)))
+	rtest.OK(t, be.Remove(ctx, h))
+
+	// opening the repository uses the copy but does not write the config
+	repo2 := repository.New(be)
+	rtest.OK(t, repo2.SearchKey(ctx, rtest.TestPassword, 0, ""))
+	rtest.Equals(t, cfg, repo2.Config())
+
+	exists, err = be.Test(ctx, h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !exists, "config was restored when the repository was opened")
+
+	rtest.OK(t, repo2.RestoreConfig(ctx))
+	rtest.Equals(t, buf, loadFile(t, be, h))
+
+	exists, err = be.Test(ctx, copyHandle)
+	rtest.OK(t, err)
+	rtest.Assert(t, !exists, "copy of the old config was not removed")
+}
+
+// noRepairBackend refuses to save files of type RepairFile.
+type noRepairBackend struct {
+	restic.Backend
+}
+
+func (be noRepairBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if h.Type == restic.RepairFile {
+		return errors.New("invalid file type")
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func TestRepositorySaveConfigWithoutCopy(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx := context.TODO()
+	repo := repository.New(noRepairBackend{r.(*repository.Repository).Backend()})
+	rtest.OK(t, repo.SearchKey(ctx, rtest.TestPassword, 0, ""))
+
+	var warnings int
+	repo.Warnf = func(format string, args ...interface{}) {
+		warnings++
+	}
+
+	cfg := repo.Config()
+	cfg.Parity = 10
+	rtest.OK(t, repo.SaveConfig(ctx, cfg))
+	rtest.Equals(t, cfg, repo.Config())
+	rtest.Equals(t, 1, warnings)
+
+	repo2 := repository.New(repo.Backend())
+	rtest.OK(t, repo2.SearchKey(ctx, rtest.TestPassword, 0, ""))
+	rtest.Equals(t, cfg, repo2.Config())
+}
+
 type backend struct {
 	rd io.Reader
 }
@@ -2,6 +2,7 @@ package restic
 
 import (
 	"context"
+	"math/bits"
 	"testing"
 
 	"github.com/restic/restic/internal/errors"
@@ -16,11 +17,156 @@ type Config struct {
 	Version           uint        `json:"version"`
 	ID                string      `json:"id"`
 	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+
+	// ChunkerMinSize, ChunkerAvgSize and ChunkerMaxSize are the sizes of the
+	// chunks files are split into, in bytes. If they are zero, the defaults
+	// of the chunker are used.
+	ChunkerMinSize uint `json:"chunker_min_size,omitempty"`
+	ChunkerAvgSize uint `json:"chunker_avg_size,omitempty"`
+	ChunkerMaxSize uint `json:"chunker_max_size,omitempty"`
+
+	// PackSize is the target size of pack files in bytes. If it is zero,
+	// DefaultPackSize is used.
+	PackSize uint `json:"pack_size,omitempty"`
+
+	// Parity is the amount of Reed-Solomon parity data stored for each pack
+	// file, in percent of the pack size. If it is zero, no parity data is
+	// stored.
+	Parity uint `json:"parity,omitempty"`
+}
+
+const (
+	// MinRepoVersion is the oldest repository format version restic can read.
+	MinRepoVersion = 1
+	// MaxRepoVersion is the newest repository format version restic can read.
+	// Version 2 adds support for compressed blobs, version 3 stores the index
+	// in a binary format.
+	MaxRepoVersion = 3
+)
+
+// StableRepoVersion is the version that is written to the config when a
+// repository is newly created with Init() and no other version is requested.
+const StableRepoVersion = 1
+
+const (
+	// MinPackSize and MaxPackSize are the limits for the target size of pack
+	// files. Offsets within a pack are stored as 32 bit integers in the
+	// index, so packs must stay well below 4 GiB.
+	MinPackSize = 4 * 1024 * 1024
+	MaxPackSize = 128 * 1024 * 1024
+
+	// DefaultPackSize is the target size of pack files if the config does
+	// not specify one.
+	DefaultPackSize = MinPackSize
+)
+
+// CheckPackSize returns an error if size is not a valid target size for pack
+// files.
+func CheckPackSize(size uint) error {
+	if size < MinPackSize || size > MaxPackSize {
+		return errors.Errorf("invalid pack size %d, must be between %d MiB and %d MiB",
+			size, MinPackSize/(1024*1024), MaxPackSize/(1024*1024))
+	}
+
+	return nil
+}
+
+// PackSizeFromMiB converts a target size for pack files given in MiB to bytes
+// and checks that it is valid.
+func PackSizeFromMiB(size uint) (uint, error) {
+	// check the size in MiB first, converting it to bytes may overflow
+	if size < MinPackSize/(1024*1024) || size > MaxPackSize/(1024*1024) {
+		return 0, errors.Errorf("invalid pack size %d MiB, must be between %d MiB and %d MiB",
+			size, MinPackSize/(1024*1024), MaxPackSize/(1024*1024))
+	}
+
+	return size * 1024 * 1024, nil
+}
+
+// MaxParity is the largest amount of parity data in percent of the pack size.
+const MaxParity = 100
+
+// CheckParity returns an error if percent is not a valid amount of parity
+// data.
+func CheckParity(percent uint) error {
+	if percent < 1 || percent > MaxParity {
+		return errors.Errorf("invalid parity %d%%, must be between 1%% and %d%%", percent, MaxParity)
+	}
+
+	return nil
+}
+
+// ChunkerSizes are the minimal, average and maximal size of the chunks files
+// are split into.
+type ChunkerSizes struct {
+	Min, Avg, Max uint
+}
+
+// DefaultChunkerSizes are the chunk sizes used if the config does not specify
+// them.
+var DefaultChunkerSizes = ChunkerSizes{
+	Min: chunker.MinSize,
+	Avg: 1 << 20,
+	Max: chunker.MaxSize,
+}
+
+const (
+	// MinChunkSize and MaxChunkSize are the limits for the chunker sizes.
+	MinChunkSize = 4 * 1024
+	MaxChunkSize = 64 * 1024 * 1024
+)
+
+// ChunkerSizesFromAvg returns the chunker sizes for the average size avg,
+// the minimal and maximal sizes are chosen in the same ratio as for the
+// default sizes.
+func ChunkerSizesFromAvg(avg uint) ChunkerSizes {
+	return ChunkerSizes{
+		Min: avg / 2,
+		Avg: avg,
+		Max: avg * 8,
+	}
+}
+
+// CheckChunkerSizes returns an error if the chunker sizes are invalid. The
+// average size must be a power of two.
+func CheckChunkerSizes(s ChunkerSizes) error {
+	if s.Min < MinChunkSize || s.Max > MaxChunkSize {
+		return errors.Errorf("invalid chunk sizes, must be between %d KiB and %d MiB",
+			MinChunkSize/1024, MaxChunkSize/(1024*1024))
+	}
+
+	if s.Min > s.Avg || s.Avg >= s.Max {
+		return errors.Errorf("invalid chunk sizes, minimal size %d, average size %d and maximal size %d are not in ascending order",
+			s.Min, s.Avg, s.Max)
+	}
+
+	if bits.OnesCount(s.Avg) != 1 {
+		return errors.Errorf("invalid average chunk size %d, must be a power of two", s.Avg)
+	}
+
+	return nil
 }
 
-// RepoVersion is the version that is written to the config when a repository
-// is newly created with Init().
-const RepoVersion = 1
+// AverageBits returns the number of bits of the average chunk size, as used
+// by chunker.SetAverageBits.
+func (s ChunkerSizes) AverageBits() int {
+	return bits.TrailingZeros(s.Avg)
+}
+
+// ChunkerSizes returns the chunker sizes for the repository. They are taken
+// from the config, or the defaults are used for repositories created before
+// the sizes were configurable.
+func (cfg Config) ChunkerSizes() ChunkerSizes {
+	if cfg.ChunkerAvgSize == 0 {
+		return DefaultChunkerSizes
+	}
+
+	return ChunkerSizes{
+		Min: cfg.ChunkerMinSize,
+		Avg: cfg.ChunkerAvgSize,
+		Max: cfg.ChunkerMaxSize,
+	}
+}
 
 // JSONUnpackedLoader loads unpacked JSON.
 type JSONUnpackedLoader interface {
@@ -28,31 +174,35 @@ type JSONUnpackedLoader interface {
 }
 
 // CreateConfig creates a config file with a randomly selected polynomial and
-// ID.
-func CreateConfig() (Config, error) {
+// ID for the given repository version.
+func CreateConfig(version uint) (Config, error) {
 	var (
 		err error
 		cfg Config
 	)
 
+	if version < MinRepoVersion || version > MaxRepoVersion {
+		return Config{}, errors.Errorf("unsupported repository version %v", version)
+	}
+
 	cfg.ChunkerPolynomial, err = chunker.RandomPolynomial()
 	if err != nil {
 		return Config{}, errors.Wrap(err, "chunker.RandomPolynomial")
 	}
 
 	cfg.ID = NewRandomID().String()
-	cfg.Version = RepoVersion
+	cfg.Version = version
 
 	debug.Log("New config: %#v", cfg)
 	return cfg, nil
 }
 
 // TestCreateConfig creates a config for use within tests.
-func TestCreateConfig(t testing.TB, pol chunker.Pol) (cfg Config) {
+func TestCreateConfig(t testing.TB, pol chunker.Pol, version uint) (cfg Config) {
 	cfg.ChunkerPolynomial = pol
 
 	cfg.ID = NewRandomID().String()
-	cfg.Version = RepoVersion
+	cfg.Version = version
 
 	return cfg
 }
@@ -77,8 +227,26 @@ func LoadConfig(ctx context.Context, r JSONUnpackedLoader) (Config, error) {
 		return Config{}, err
 	}
 
-	if cfg.Version != RepoVersion {
-		return Config{}, errors.New("unsupported repository version")
+	if cfg.Version < MinRepoVersion || cfg.Version > MaxRepoVersion {
+		return Config{}, errors.Errorf("unsupported repository version %v", cfg.Version)
+	}
+
+	if cfg.PackSize != 0 {
+		if err := CheckPackSize(cfg.PackSize); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if cfg.Parity != 0 {
+		if err := CheckParity(cfg.Parity); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if cfg.ChunkerMinSize != 0 || cfg.ChunkerAvgSize != 0 || cfg.ChunkerMaxSize != 0 {
+		if err := CheckChunkerSizes(cfg.ChunkerSizes()); err != nil {
+			return Config{}, err
+		}
 	}
 
 	if checkPolynomial {
@@ -32,7 +32,7 @@ func TestConfig(t *testing.T) {
 		return restic.ID{}, nil
 	}
 
-	cfg1, err := restic.CreateConfig()
+	cfg1, err := restic.CreateConfig(restic.StableRepoVersion)
 	rtest.OK(t, err)
 
 	_, err = saver(save).SaveJSONUnpacked(restic.ConfigFile, cfg1)
@@ -54,3 +54,109 @@ func TestConfig(t *testing.T) {
 	rtest.Assert(t, cfg1 == cfg2,
 		"configs aren't equal: %v != %v", cfg1, cfg2)
 }
+
+func TestConfigPackSize(t *testing.T) {
+	base, err := restic.CreateConfig(restic.StableRepoVersion)
+	rtest.OK(t, err)
+
+	for _, test := range []struct {
+		packSize uint
+		valid    bool
+	}{
+		{0, true},
+		{restic.MinPackSize, true},
+		{16 * 1024 * 1024, true},
+		{restic.MaxPackSize, true},
+		{restic.MinPackSize - 1, false},
+		{restic.MaxPackSize + 1, false},
+	} {
+		load := func(ctx context.Context, tpe restic.FileType, id restic.ID, arg interface{}) error {
+			cfg := arg.(*restic.Config)
+			*cfg = base
+			cfg.PackSize = test.packSize
+			return nil
+		}
+
+		cfg, err := restic.LoadConfig(context.TODO(), loader(load))
+		if !test.valid {
+			rtest.Assert(t, err != nil, "config with pack size %d loaded without error", test.packSize)
+			continue
+		}
+		rtest.OK(t, err)
+		rtest.Equals(t, test.packSize, cfg.PackSize)
+	}
+}
+
+func TestConfigParity(t *testing.T) {
+	base, err := restic.CreateConfig(restic.StableRepoVersion)
+	rtest.OK(t, err)
+
+	for _, test := range []struct {
+		parity uint
+		valid  bool
+	}{
+		{0, true},
+		{1, true},
+		{10, true},
+		{restic.MaxParity, true},
+		{restic.MaxParity + 1, false},
+	} {
+		load := func(ctx context.Context, tpe restic.FileType, id restic.ID, arg interface{}) error {
+			cfg := arg.(*restic.Config)
+			*cfg = base
+			cfg.Parity = test.parity
+			return nil
+		}
+
+		cfg, err := restic.LoadConfig(context.TODO(), loader(load))
+		if !test.valid {
+			rtest.Assert(t, err != nil, "config with parity %d loaded without error", test.parity)
+			continue
+		}
+		rtest.OK(t, err)
+		rtest.Equals(t, test.parity, cfg.Parity)
+	}
+}
+
+func TestConfigChunkerSizes(t *testing.T) {
+	base, err := restic.CreateConfig(restic.StableRepoVersion)
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.DefaultChunkerSizes, base.ChunkerSizes())
+
+	for _, test := range []struct {
+		sizes restic.ChunkerSizes
+		valid bool
+	}{
+		{restic.ChunkerSizes{}, true},
+		{restic.DefaultChunkerSizes, true},
+		{restic.ChunkerSizesFromAvg(64 * 1024), true},
+		{restic.ChunkerSizesFromAvg(4 * 1024 * 1024), true},
+		{restic.ChunkerSizes{Min: restic.MinChunkSize, Avg: restic.MinChunkSize, Max: restic.MaxChunkSize}, true},
+		{restic.ChunkerSizesFromAvg(restic.MinChunkSize), false},
+		{restic.ChunkerSizesFromAvg(16 * 1024 * 1024), false},
+		{restic.ChunkerSizes{Min: 512 * 1024, Avg: 1000 * 1024, Max: 8 * 1024 * 1024}, false},
+		{restic.ChunkerSizes{Min: 2 * 1024 * 1024, Avg: 1024 * 1024, Max: 8 * 1024 * 1024}, false},
+		{restic.ChunkerSizes{Min: 512 * 1024, Avg: 1024 * 1024, Max: 1024 * 1024}, false},
+	} {
+		load := func(ctx context.Context, tpe restic.FileType, id restic.ID, arg interface{}) error {
+			cfg := arg.(*restic.Config)
+			*cfg = base
+			cfg.ChunkerMinSize = test.sizes.Min
+			cfg.ChunkerAvgSize = test.sizes.Avg
+			cfg.ChunkerMaxSize = test.sizes.Max
+			return nil
+		}
+
+		cfg, err := restic.LoadConfig(context.TODO(), loader(load))
+		if !test.valid {
+			rtest.Assert(t, err != nil, "config with chunker sizes %v loaded without error", test.sizes)
+			continue
+		}
+		rtest.OK(t, err)
+		if test.sizes == (restic.ChunkerSizes{}) {
+			rtest.Equals(t, restic.DefaultChunkerSizes, cfg.ChunkerSizes())
+		} else {
+			rtest.Equals(t, test.sizes, cfg.ChunkerSizes())
+		}
+	}
+}
@@ -17,6 +17,23 @@ const (
 	SnapshotFile FileType = "snapshot"
 	IndexFile    FileType = "index"
 	ConfigFile   FileType = "config"
+
+	// PruneStateFile records the progress of a prune run, so that it can be
+	// resumed after an interruption.
+	PruneStateFile FileType = "prune"
+
+	// PendingFile lists packs which have been removed from the index by a
+	// prune run and are deleted once no process can still refer to them.
+	PendingFile FileType = "pending"
+
+	// ParityFile holds Reed-Solomon parity data for the pack with the same
+	// name, which can be used to repair the pack.
+	ParityFile FileType = "parity"
+
+	// RepairFile holds a copy of a file while it is replaced, as backends
+	// cannot overwrite files: a verified copy of the pack with the same name,
+	// or the old config under the name "config".
+	RepairFile FileType = "repair"
 )
 
 // Handle is used to store and access data in a backend.
@@ -46,6 +63,10 @@ func (h Handle) Valid() error {
 	case SnapshotFile:
 	case IndexFile:
 	case ConfigFile:
+	case PruneStateFile:
+	case PendingFile:
+	case ParityFile:
+	case RepairFile:
 	default:
 		return errors.Errorf("invalid Type %q", h.Type)
 	}
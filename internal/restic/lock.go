@@ -20,13 +20,15 @@ import (
 //
 // There are two types of locks: exclusive and non-exclusive. There may be many
 // different non-exclusive locks, but at most one exclusive lock, which can
-// only be acquired while no non-exclusive lock is held.
+// only be acquired while no non-exclusive lock is held. A non-exclusive lock
+// can additionally be marked as held by prune, of which at most one may exist.
 //
 // A lock must be refreshed regularly to not be considered stale, this must be
 // triggered by regularly calling Refresh.
 type Lock struct {
 	Time      time.Time `json:"time"`
 	Exclusive bool      `json:"exclusive"`
+	Prune     bool      `json:"prune,omitempty"`
 	Hostname  string    `json:"hostname"`
 	Username  string    `json:"username"`
 	PID       int       `json:"pid"`
@@ -47,6 +49,8 @@ func (e ErrAlreadyLocked) Error() string {
 	s := ""
 	if e.otherLock.Exclusive {
 		s = "exclusively "
+	} else if e.otherLock.Prune {
+		s = "for prune "
 	}
 	return fmt.Sprintf("repository is already locked %sby %v", s, e.otherLock)
 }
@@ -64,14 +68,21 @@ func IsAlreadyLocked(err error) bool {
 // exclusive lock is already held by another process, ErrAlreadyLocked is
 // returned.
 func NewLock(ctx context.Context, repo Repository) (*Lock, error) {
-	return newLock(ctx, repo, false)
+	return newLock(ctx, repo, false, false)
 }
 
 // NewExclusiveLock returns a new, exclusive lock for the repository. If
 // another lock (normal and exclusive) is already held by another process,
 // ErrAlreadyLocked is returned.
 func NewExclusiveLock(ctx context.Context, repo Repository) (*Lock, error) {
-	return newLock(ctx, repo, true)
+	return newLock(ctx, repo, true, false)
+}
+
+// NewPruneLock returns a new, non-exclusive lock for the repository which is
+// marked as held by prune. If an exclusive lock or another prune lock is
+// already held by another process, ErrAlreadyLocked is returned.
+func NewPruneLock(ctx context.Context, repo Repository) (*Lock, error) {
+	return newLock(ctx, repo, false, true)
 }
 
 var waitBeforeLockCheck = 200 * time.Millisecond
@@ -82,11 +93,12 @@ func TestSetLockTimeout(t testing.TB, d time.Duration) {
 	waitBeforeLockCheck = d
 }
 
-func newLock(ctx context.Context, repo Repository, excl bool) (*Lock, error) {
+func newLock(ctx context.Context, repo Repository, excl, prune bool) (*Lock, error) {
 	lock := &Lock{
 		Time:      time.Now(),
 		PID:       os.Getpid(),
 		Exclusive: excl,
+		Prune:     prune,
 		repo:      repo,
 	}
 
@@ -136,7 +148,7 @@ func (l *Lock) fillUserInfo() error {
 // If an exclusive lock is to be created, checkForOtherLocks returns an error
 // if there are any other locks, regardless if exclusive or not. If a
 // non-exclusive lock is to be created, an error is only returned when an
-// exclusive lock is found.
+// exclusive lock is found, or when both locks are held by prune.
 func (l *Lock) checkForOtherLocks(ctx context.Context) error {
 	return l.repo.List(ctx, LockFile, func(id ID, size int64) error {
 		if l.lockID != nil && id.Equal(*l.lockID) {
@@ -158,6 +170,10 @@ func (l *Lock) checkForOtherLocks(ctx context.Context) error {
 			return ErrAlreadyLocked{otherLock: lock}
 		}
 
+		if l.Prune && lock.Prune {
+			return ErrAlreadyLocked{otherLock: lock}
+		}
+
 		return nil
 	})
 }
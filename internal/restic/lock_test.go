@@ -92,6 +92,27 @@ func TestExclusiveLockOnLockedRepo(t *testing.T) {
 	rtest.OK(t, elock.Unlock())
 }
 
+func TestPruneLockOnPruneLockedRepo(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	plock, err := restic.NewPruneLock(context.TODO(), repo)
+	rtest.OK(t, err)
+
+	lock, err := restic.NewLock(context.TODO(), repo)
+	rtest.OK(t, err)
+
+	lock2, err := restic.NewPruneLock(context.TODO(), repo)
+	rtest.Assert(t, err != nil,
+		"create prune lock with prune locked repo didn't return an error")
+	rtest.Assert(t, restic.IsAlreadyLocked(err),
+		"create prune lock with prune locked repo didn't return the correct error")
+
+	rtest.OK(t, lock2.Unlock())
+	rtest.OK(t, lock.Unlock())
+	rtest.OK(t, plock.Unlock())
+}
+
 func createFakeLock(repo restic.Repository, t time.Time, pid int) (restic.ID, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
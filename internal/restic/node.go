@@ -46,6 +46,7 @@ type Node struct {
 	ExtendedAttributes []ExtendedAttribute `json:"extended_attributes,omitempty"`
 	Device             uint64              `json:"device,omitempty"` // in case of Type == "dev", stat.st_rdev
 	Content            IDs                 `json:"content"`
+	Sparse             bool                `json:"sparse,omitempty"` // the file contained holes, restore it as a sparse file
 	Subtree            *ID                 `json:"subtree,omitempty"`
 
 	Error string `json:"error,omitempty"`
@@ -174,7 +175,7 @@ func (node *Node) CreateAt(ctx context.Context, path string, repo Repository) er
 
 // RestoreMetadata restores node metadata
 func (node Node) RestoreMetadata(path string) error {
-	err := node.restoreMetadata(path)
+	err := node.restoreMetadata(path, int(node.UID), int(node.GID), false)
 	if err != nil {
 		debug.Log("restoreMetadata(%s) error %v", path, err)
 	}
@@ -182,15 +183,28 @@ func (node Node) RestoreMetadata(path string) error {
 	return err
 }
 
-func (node Node) restoreMetadata(path string) error {
+// RestoreMetadataOwner restores node metadata like RestoreMetadata, but sets
+// the owner to uid and gid instead of node.UID and node.GID. An ID of -1 is
+// left unchanged. Errors changing the owner are always reported, as the owner
+// was requested explicitly.
+func (node Node) RestoreMetadataOwner(path string, uid, gid int) error {
+	err := node.restoreMetadata(path, uid, gid, true)
+	if err != nil {
+		debug.Log("restoreMetadata(%s) error %v", path, err)
+	}
+
+	return err
+}
+
+func (node Node) restoreMetadata(path string, uid, gid int, strictOwner bool) error {
 	var firsterr error
 
-	if err := lchown(path, int(node.UID), int(node.GID)); err != nil {
+	if err := lchown(path, uid, gid); err != nil {
 		// Like "cp -a" and "rsync -a" do, we only report lchown permission errors
 		// if we run as root.
 		// On Windows, Geteuid always returns -1, and we always report lchown
 		// permission errors.
-		if os.Geteuid() > 0 && os.IsPermission(err) {
+		if !strictOwner && os.Geteuid() > 0 && os.IsPermission(err) {
 			debug.Log("not running as root, ignoring lchown permission error for %v: %v",
 				path, err)
 		} else {
@@ -413,6 +427,9 @@ func (node Node) Equals(other Node) bool {
 	if node.Device != other.Device {
 		return false
 	}
+	if node.Sparse != other.Sparse {
+		return false
+	}
 	if !node.sameContent(other) {
 		return false
 	}
@@ -583,6 +600,10 @@ func lookupGroup(gid string) (string, error) {
 }
 
 func (node *Node) fillExtra(path string, fi os.FileInfo) error {
+	if hdr, ok := fi.Sys().(*fs.TarHeader); ok {
+		return node.fillExtraTar(hdr)
+	}
+
 	stat, ok := toStatT(fi.Sys())
 	if !ok {
 		// fill minimal info with current values for uid, gid
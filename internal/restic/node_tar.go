@@ -0,0 +1,64 @@
+package restic
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+)
+
+// tarXattrPrefix is the prefix of the PAX records which contain extended
+// attributes.
+const tarXattrPrefix = "SCHILY.xattr."
+
+// fillExtraTar fills the node with the metadata from the header of a file
+// contained in a tar archive.
+func (node *Node) fillExtraTar(hdr *fs.TarHeader) error {
+	node.UID = uint32(hdr.Uid)
+	node.GID = uint32(hdr.Gid)
+	node.User = hdr.Uname
+	node.Group = hdr.Gname
+	node.Inode = hdr.Inode
+
+	node.AccessTime = hdr.AccessTime
+	if node.AccessTime.IsZero() {
+		node.AccessTime = node.ModTime
+	}
+	node.ChangeTime = hdr.ChangeTime
+	if node.ChangeTime.IsZero() {
+		node.ChangeTime = node.ModTime
+	}
+
+	switch node.Type {
+	case "file":
+		node.Links = hdr.Links
+	case "dir":
+	case "symlink":
+		node.LinkTarget = hdr.Linkname
+		node.Links = hdr.Links
+	case "dev", "chardev":
+		node.Device = mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		node.Links = hdr.Links
+	case "fifo":
+	default:
+		return errors.Errorf("invalid node type %q", node.Type)
+	}
+
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, tarXattrPrefix) {
+			continue
+		}
+
+		node.ExtendedAttributes = append(node.ExtendedAttributes, ExtendedAttribute{
+			Name:  strings.TrimPrefix(key, tarXattrPrefix),
+			Value: []byte(value),
+		})
+	}
+
+	sort.Slice(node.ExtendedAttributes, func(i, j int) bool {
+		return node.ExtendedAttributes[i].Name < node.ExtendedAttributes[j].Name
+	})
+
+	return nil
+}
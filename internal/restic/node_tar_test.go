@@ -0,0 +1,66 @@
+package restic_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestNodeFromTarHeader(t *testing.T) {
+	modtime := time.Unix(1600000000, 0)
+	atime := time.Unix(1600000100, 0)
+
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "file", Typeflag: tar.TypeReg, Mode: 0640, ModTime: modtime, AccessTime: atime,
+			Uid: 1000, Gid: 100, Uname: "user", Gname: "users", Format: tar.FormatPAX,
+			PAXRecords: map[string]string{"SCHILY.xattr.user.foo": "bar", "comment": "ignored"}},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "file", Mode: 0777, ModTime: modtime},
+		{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "file", Mode: 0640, ModTime: modtime},
+		{Name: "dev", Typeflag: tar.TypeBlock, Devmajor: 8, Devminor: 1, Mode: 0660, ModTime: modtime},
+	} {
+		rtest.OK(t, tw.WriteHeader(hdr))
+	}
+	rtest.OK(t, tw.Close())
+
+	tarFS, err := fs.NewTar(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	rtest.OK(t, err)
+
+	nodeFor := func(name string) *restic.Node {
+		fi, err := tarFS.Lstat(name)
+		rtest.OK(t, err)
+		node, err := restic.NodeFromFileInfo(name, fi)
+		rtest.OK(t, err)
+		return node
+	}
+
+	file := nodeFor("/file")
+	rtest.Equals(t, "file", file.Type)
+	rtest.Equals(t, uint32(1000), file.UID)
+	rtest.Equals(t, uint32(100), file.GID)
+	rtest.Equals(t, "user", file.User)
+	rtest.Equals(t, "users", file.Group)
+	rtest.Assert(t, file.ModTime.Equal(modtime), "wrong mtime %v", file.ModTime)
+	rtest.Assert(t, file.AccessTime.Equal(atime), "wrong atime %v", file.AccessTime)
+	rtest.Assert(t, file.ChangeTime.Equal(modtime), "wrong ctime %v", file.ChangeTime)
+	rtest.Equals(t, uint64(2), file.Links)
+	rtest.Equals(t, []restic.ExtendedAttribute{{Name: "user.foo", Value: []byte("bar")}}, file.ExtendedAttributes)
+
+	hardlink := nodeFor("/hardlink")
+	rtest.Equals(t, "file", hardlink.Type)
+	rtest.Equals(t, file.Inode, hardlink.Inode)
+	rtest.Equals(t, uint64(2), hardlink.Links)
+
+	link := nodeFor("/link")
+	rtest.Equals(t, "symlink", link.Type)
+	rtest.Equals(t, "file", link.LinkTarget)
+
+	dev := nodeFor("/dev")
+	rtest.Equals(t, "dev", dev.Type)
+}
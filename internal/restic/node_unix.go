@@ -5,6 +5,8 @@ package restic
 import (
 	"os"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 var mknod = syscall.Mknod
@@ -12,6 +14,11 @@ var lchown = os.Lchown
 
 type statUnix syscall.Stat_t
 
+// mkdev returns the device number for the major and minor numbers.
+func mkdev(major, minor uint32) uint64 {
+	return unix.Mkdev(major, minor)
+}
+
 func toStatT(i interface{}) (statT, bool) {
 	if i == nil {
 		return nil, false
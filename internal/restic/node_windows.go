@@ -22,6 +22,12 @@ func (node Node) restoreSymlinkTimestamps(path string, utimes [2]syscall.Timespe
 	return nil
 }
 
+// mkdev returns the device number for the major and minor numbers. Device
+// nodes are not supported on windows.
+func mkdev(major, minor uint32) uint64 {
+	return 0
+}
+
 func (node Node) device() int {
 	return int(node.Device)
 }
@@ -24,6 +24,9 @@ type Repository interface {
 
 	Config() Config
 
+	// PackSize returns the target size of pack files.
+	PackSize() uint
+
 	LookupBlobSize(ID, BlobType) (uint, bool)
 
 	// List calls the function fn for each file of type t in the repository.
@@ -63,8 +66,17 @@ type MasterIndex interface {
 	Lookup(ID, BlobType) []PackedBlob
 	Count(BlobType) uint
 
+	// MemoryUsage returns an estimate of the number of bytes used by the
+	// index in memory.
+	MemoryUsage() uint64
+
 	// Each returns a channel that yields all blobs known to the index. When
 	// the context is cancelled, the background goroutine terminates. This
 	// blocks any modification of the index.
 	Each(ctx context.Context) <-chan PackedBlob
+
+	// Save writes the contents of the index to new index files, leaving out
+	// all packs in packBlacklist, and returns the IDs of the index files
+	// which are superseded by the new ones.
+	Save(ctx context.Context, repo Repository, packBlacklist IDSet, p *Progress) (obsolete IDSet, err error)
 }
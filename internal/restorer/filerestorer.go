@@ -11,6 +11,7 @@ import (
 	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 )
 
@@ -34,8 +35,12 @@ type fileInfo struct {
 	lock     sync.Mutex
 	flags    int
 	size     int64
-	location string      // file on local filesystem relative to restorer basedir
-	blobs    interface{} // blobs of the file
+	location string             // file on local filesystem relative to restorer basedir
+	blobs    interface{}        // blobs of the file
+	sparse   bool               // all-zero blobs are not written
+	oldSize  int64              // size of the existing file, holes are only left after it
+	present  map[int64]struct{} // offsets of blobs which are already present in the file
+	pending  int                // number of blobs which still need to be written
 }
 
 type fileBlobInfo struct {
@@ -57,6 +62,10 @@ type fileRestorer struct {
 
 	filesWriter *filesWriter
 
+	// progress callbacks, called concurrently from the download workers
+	completeBlob func(location string, bytes uint64)
+	completeFile func(location string)
+
 	dst   string
 	files []*fileInfo
 }
@@ -67,16 +76,32 @@ func newFileRestorer(dst string,
 	idx func(restic.ID, restic.BlobType) []restic.PackedBlob) *fileRestorer {
 
 	return &fileRestorer{
-		key:         key,
-		idx:         idx,
-		packLoader:  packLoader,
-		filesWriter: newFilesWriter(workerCount),
-		dst:         dst,
+		key:          key,
+		idx:          idx,
+		packLoader:   packLoader,
+		filesWriter:  newFilesWriter(workerCount),
+		completeBlob: func(string, uint64) {},
+		completeFile: func(string) {},
+		dst:          dst,
 	}
 }
 
-func (r *fileRestorer) addFile(location string, content restic.IDs, size int64) {
-	r.files = append(r.files, &fileInfo{location: location, blobs: content, size: size})
+func (r *fileRestorer) addFile(location string, content restic.IDs, size int64, sparse bool) {
+	r.files = append(r.files, &fileInfo{location: location, blobs: content, size: size, sparse: sparse})
+}
+
+// addPartialFile adds a file which already exists in the target directory with
+// oldSize bytes and has been truncated to size. Only the blobs which are not
+// present at their offset are restored. For a sparse file, all-zero blobs are
+// only written if they overwrite the existing content.
+func (r *fileRestorer) addPartialFile(location string, content restic.IDs, size int64, oldSize int64, sparse bool, present map[int64]struct{}) {
+	r.files = append(r.files, &fileInfo{location: location, blobs: content, size: size, oldSize: oldSize, sparse: sparse, present: present, flags: fileProgress})
+}
+
+// isPresent returns true if the blob at offset does not need to be restored.
+func (file *fileInfo) isPresent(offset int64) bool {
+	_, ok := file.present[offset]
+	return ok
 }
 
 func (r *fileRestorer) targetPath(location string) string {
@@ -117,9 +142,14 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 		}
 		fileOffset := int64(0)
 		err := r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob) {
+			offset := fileOffset
+			fileOffset += int64(blob.DataLength())
+			if file.isPresent(offset) {
+				return
+			}
+			file.pending++
 			if largeFile {
-				packsMap[packID] = append(packsMap[packID], fileBlobInfo{id: blob.ID, offset: fileOffset})
-				fileOffset += int64(blob.Length) - crypto.Extension
+				packsMap[packID] = append(packsMap[packID], fileBlobInfo{id: blob.ID, offset: offset})
 			}
 			pack, ok := packs[packID]
 			if !ok {
@@ -184,9 +214,10 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 	// calculate pack byte range and blob->[]files->[]offsets mappings
 	start, end := int64(math.MaxInt64), int64(0)
 	blobs := make(map[restic.ID]struct {
-		offset int64                 // offset of the blob in the pack
-		length int                   // length of the blob
-		files  map[*fileInfo][]int64 // file -> offsets (plural!) of the blob in the file
+		offset             int64                 // offset of the blob in the pack
+		length             int                   // length of the blob
+		uncompressedLength uint                  // length of the blob before compression, zero if uncompressed
+		files              map[*fileInfo][]int64 // file -> offsets (plural!) of the blob in the file
 	})
 	for file := range pack.files {
 		addBlob := func(blob restic.Blob, fileOffset int64) {
@@ -200,6 +231,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 			if !ok {
 				blobInfo.offset = int64(blob.Offset)
 				blobInfo.length = int(blob.Length)
+				blobInfo.uncompressedLength = blob.UncompressedLength
 				blobInfo.files = make(map[*fileInfo][]int64)
 				blobs[blob.ID] = blobInfo
 			}
@@ -208,10 +240,10 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 		if fileBlobs, ok := file.blobs.(restic.IDs); ok {
 			fileOffset := int64(0)
 			r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob) {
-				if packID.Equal(pack.id) {
+				if packID.Equal(pack.id) && !file.isPresent(fileOffset) {
 					addBlob(blob, fileOffset)
 				}
-				fileOffset += int64(blob.Length) - crypto.Extension
+				fileOffset += int64(blob.DataLength())
 			})
 		} else if packsMap, ok := file.blobs.(map[restic.ID][]fileBlobInfo); ok {
 			for _, blob := range packsMap[pack.id] {
@@ -258,15 +290,21 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 	rd := bytes.NewReader(packData)
 
 	for blobID, blob := range blobs {
-		blobData, err := r.loadBlob(rd, blobID, blob.offset-start, blob.length)
+		blobData, err := r.loadBlob(rd, blobID, blob.offset-start, blob.length, blob.uncompressedLength)
 		if err != nil {
 			for file := range blob.files {
 				markFileError(file, err)
 			}
 			continue
 		}
+		zero := isZero(blobData)
 		for file, offsets := range blob.files {
 			for _, offset := range offsets {
+				data := blobData
+				if file.sparse && zero && offset >= file.oldSize {
+					// leave a hole in the file
+					data = nil
+				}
 				writeToFile := func() error {
 					// this looks overly complicated and needs explanation
 					// two competing requirements:
@@ -284,19 +322,39 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 					} else {
 						file.lock.Unlock()
 					}
-					return r.filesWriter.writeToFile(r.targetPath(file.location), blobData, offset, createSize)
+					return r.filesWriter.writeToFile(r.targetPath(file.location), data, offset, createSize, file.sparse)
 				}
 				err := writeToFile()
 				if err != nil {
 					markFileError(file, err)
 					break
 				}
+
+				r.completeBlob(file.location, uint64(len(blobData)))
+
+				file.lock.Lock()
+				file.pending--
+				done := file.pending == 0
+				file.lock.Unlock()
+				if done {
+					r.completeFile(file.location)
+				}
 			}
 		}
 	}
 }
 
-func (r *fileRestorer) loadBlob(rd io.ReaderAt, blobID restic.ID, offset int64, length int) ([]byte, error) {
+// isZero returns true if buf contains only zeros.
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *fileRestorer) loadBlob(rd io.ReaderAt, blobID restic.ID, offset int64, length int, uncompressedLength uint) ([]byte, error) {
 	// TODO reconcile with Repository#loadBlob implementation
 
 	buf := make([]byte, length)
@@ -317,6 +375,12 @@ func (r *fileRestorer) loadBlob(rd io.ReaderAt, blobID restic.ID, offset int64,
 		return nil, errors.Errorf("decrypting blob %v failed: %v", blobID, err)
 	}
 
+	blob := restic.Blob{Type: restic.DataBlob, ID: blobID, UncompressedLength: uncompressedLength}
+	plaintext, err = repository.DecompressBlob(blob, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
 	// check hash
 	if !restic.Hash(plaintext).Equal(blobID) {
 		return nil, errors.Errorf("blob %v returned invalid hash", blobID)
@@ -5,6 +5,9 @@ import (
 	"context"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/restic/restic/internal/crypto"
@@ -18,8 +21,9 @@ type TestBlob struct {
 }
 
 type TestFile struct {
-	name  string
-	blobs []TestBlob
+	name   string
+	blobs  []TestBlob
+	sparse bool
 }
 
 type TestRepo struct {
@@ -123,10 +127,12 @@ func newTestRepo(content []TestFile) *TestRepo {
 	var files []*fileInfo
 	for _, file := range content {
 		content := restic.IDs{}
+		size := int64(0)
 		for _, blob := range file.blobs {
 			content = append(content, restic.Hash([]byte(blob.data)))
+			size += int64(len(blob.data))
 		}
-		files = append(files, &fileInfo{location: file.name, blobs: content})
+		files = append(files, &fileInfo{location: file.name, blobs: content, size: size, sparse: file.sparse})
 	}
 
 	repo := &TestRepo{
@@ -203,3 +209,103 @@ func TestFileRestorerBasic(t *testing.T) {
 		},
 	})
 }
+
+func TestFileRestorerSparse(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	zeros := string(make([]byte, 4096))
+
+	restoreAndVerify(t, tempdir, []TestFile{
+		TestFile{
+			name: "file1",
+			blobs: []TestBlob{
+				TestBlob{zeros, "pack1"},
+				TestBlob{"data1-2", "pack1"},
+				TestBlob{zeros, "pack1"},
+			},
+			sparse: true,
+		},
+		TestFile{
+			name: "file2",
+			blobs: []TestBlob{
+				TestBlob{"data2-1", "pack1"},
+				TestBlob{zeros, "pack1"},
+			},
+		},
+	})
+}
+
+func TestFileRestorerPartial(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	repo := newTestRepo([]TestFile{
+		TestFile{
+			name: "file1",
+			blobs: []TestBlob{
+				TestBlob{"data1-1", "pack1"},
+				TestBlob{"data1-2", "pack2"},
+				TestBlob{"data1-3", "pack1"},
+			},
+		},
+	})
+
+	// the second blob is outdated
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "file1"), []byte("data1-1data0-0data1-3"), 0644))
+
+	var loaded []string
+	loader := func(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+		id, err := restic.ParseID(h.Name)
+		rtest.OK(t, err)
+		loaded = append(loaded, repo.packsIDToName[id])
+		return repo.loader(ctx, h, length, offset, fn)
+	}
+
+	r := newFileRestorer(tempdir, loader, repo.key, repo.Lookup)
+	file := repo.files[0]
+	r.addPartialFile(file.location, file.blobs.(restic.IDs), file.size, file.size, false, map[int64]struct{}{0: {}, 14: {}})
+
+	rtest.OK(t, r.restoreFiles(context.TODO()))
+	rtest.Equals(t, []string{"pack2"}, loaded)
+
+	data, err := ioutil.ReadFile(filepath.Join(tempdir, "file1"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "data1-1data1-2data1-3", string(data))
+}
+
+func TestFileRestorerPartialSparse(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	zeros := string(make([]byte, 4096))
+
+	repo := newTestRepo([]TestFile{
+		TestFile{
+			name: "file1",
+			blobs: []TestBlob{
+				TestBlob{"data1-1", "pack1"},
+				TestBlob{zeros, "pack1"},
+				TestBlob{"data1-3", "pack1"},
+				TestBlob{zeros, "pack1"},
+			},
+		},
+	})
+	file := repo.files[0]
+
+	// the zeros in the existing file have been overwritten, the file is
+	// extended to its new size before it is restored
+	old := "data1-1" + strings.Repeat("x", len(zeros)) + "data1-3"
+	target := filepath.Join(tempdir, "file1")
+	rtest.OK(t, ioutil.WriteFile(target, []byte(old), 0644))
+	rtest.OK(t, os.Truncate(target, file.size))
+
+	r := newFileRestorer(tempdir, repo.loader, repo.key, repo.Lookup)
+	r.addPartialFile(file.location, file.blobs.(restic.IDs), file.size, int64(len(old)), true, map[int64]struct{}{0: {}, 4103: {}})
+
+	rtest.OK(t, r.restoreFiles(context.TODO()))
+
+	data, err := ioutil.ReadFile(target)
+	rtest.OK(t, err)
+	rtest.Equals(t, repo.fileContent(file), string(data))
+}
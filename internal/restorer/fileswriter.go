@@ -34,7 +34,11 @@ func newFilesWriter(count int) *filesWriter {
 	}
 }
 
-func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, createSize int64) error {
+// writeToFile writes the blob to the file at offset. If createSize is not
+// negative, the file is created with that size. A sparse file is not
+// preallocated, instead the parts which are not written stay holes. A nil
+// blob is not written, but the file is created nonetheless.
+func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, createSize int64, sparse bool) error {
 	bucket := &w.buckets[uint(xxhash.Sum64String(path))%uint(len(w.buckets))]
 
 	acquireWriter := func() (*os.File, error) {
@@ -58,10 +62,19 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 			return nil, err
 		}
 
+		if createSize >= 0 && sparse {
+			// extending the file creates a hole
+			err = wr.Truncate(createSize)
+			if err != nil {
+				_ = wr.Close()
+				return nil, err
+			}
+		}
+
 		bucket.files[path] = wr
 		bucket.users[path] = 1
 
-		if createSize >= 0 {
+		if createSize >= 0 && !sparse {
 			err := preallocateFile(wr, createSize)
 			if err != nil {
 				// Just log the preallocate error but don't let it cause the restore process to fail.
@@ -94,7 +107,9 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 		return err
 	}
 
-	_, err = wr.WriteAt(blob, offset)
+	if blob != nil {
+		_, err = wr.WriteAt(blob, offset)
+	}
 
 	if err != nil {
 		releaseWriter(wr)
@@ -0,0 +1,186 @@
+package restorer
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// OverwriteBehavior controls what happens to files which already exist in
+// the target directory.
+type OverwriteBehavior int
+
+const (
+	// OverwriteAlways replaces all existing files.
+	OverwriteAlways OverwriteBehavior = iota
+	// OverwriteIfChanged replaces existing files, but only the parts which
+	// differ from the snapshot are downloaded and written.
+	OverwriteIfChanged
+	// OverwriteIfNewer replaces existing files which are older than the
+	// files in the snapshot.
+	OverwriteIfNewer
+	// OverwriteNever leaves existing files alone.
+	OverwriteNever
+)
+
+var overwriteBehaviorNames = map[OverwriteBehavior]string{
+	OverwriteAlways:    "always",
+	OverwriteIfChanged: "if-changed",
+	OverwriteIfNewer:   "if-newer",
+	OverwriteNever:     "never",
+}
+
+func (b OverwriteBehavior) String() string {
+	if name, ok := overwriteBehaviorNames[b]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Set parses s and updates b.
+func (b *OverwriteBehavior) Set(s string) error {
+	for behavior, name := range overwriteBehaviorNames {
+		if name == s {
+			*b = behavior
+			return nil
+		}
+	}
+	return errors.Errorf("invalid overwrite behavior %q, must be one of always, if-changed, if-newer or never", s)
+}
+
+// Type returns the type of OverwriteBehavior, usable within
+// github.com/spf13/pflag and in help texts.
+func (b OverwriteBehavior) Type() string {
+	return "behavior"
+}
+
+// shouldOverwrite reports whether the existing file described by fi is
+// replaced by node.
+func (res *Restorer) shouldOverwrite(node *restic.Node, fi os.FileInfo) bool {
+	switch res.Overwrite {
+	case OverwriteNever:
+		return false
+	case OverwriteIfNewer:
+		return node.ModTime.After(fi.ModTime())
+	default:
+		return true
+	}
+}
+
+// presentBlobs splits the file at path into chunks the same way the archiver
+// does and returns the offsets of the blobs from content which are already
+// present at the same position in the file, together with their total size.
+// Content which moved to a different offset is not detected. buf must be large
+// enough to hold the largest chunk.
+//
+// Blobs containing only zeros, such as the holes of sparse files, are compared
+// to the file content at their offset directly. They are then found even if
+// the chunker cuts the existing file at different boundaries.
+func (res *Restorer) presentBlobs(ctx context.Context, path string, content restic.IDs, buf []byte) (map[int64]struct{}, uint64, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	cfg := res.repo.Config()
+	sizes := cfg.ChunkerSizes()
+	chnker := chunker.NewWithBoundaries(f, cfg.ChunkerPolynomial, sizes.Min, sizes.Max)
+	chnker.SetAverageBits(sizes.AverageBits())
+
+	chunks := make(map[int64]restic.ID)
+	for {
+		chunk, err := chnker.Next(buf)
+		if errors.Cause(err) == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+
+		chunks[int64(chunk.Start)] = restic.Hash(chunk.Data)
+	}
+
+	// zeroIDs caches the IDs of blobs containing only zeros by size
+	zeroIDs := make(map[uint]restic.ID)
+	isZeroBlob := func(id restic.ID, size uint) bool {
+		if size > uint(len(buf)) {
+			return false
+		}
+
+		zeroID, ok := zeroIDs[size]
+		if !ok {
+			zeros := buf[:size]
+			for i := range zeros {
+				zeros[i] = 0
+			}
+			zeroID = restic.Hash(zeros)
+			zeroIDs[size] = zeroID
+		}
+		return zeroID.Equal(id)
+	}
+
+	present := make(map[int64]struct{})
+	presentBytes := uint64(0)
+	offset := int64(0)
+	for _, id := range content {
+		size, found := res.repo.LookupBlobSize(id, restic.DataBlob)
+		if !found {
+			return nil, 0, errors.Errorf("unknown blob %s", id.Str())
+		}
+
+		match := false
+		if chunk, ok := chunks[offset]; ok && chunk.Equal(id) {
+			match = true
+		} else if isZeroBlob(id, size) {
+			match, err = isZeroRange(f, offset, buf[:size])
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+
+		if match {
+			present[offset] = struct{}{}
+			presentBytes += uint64(size)
+		}
+		offset += int64(size)
+	}
+
+	debug.Log("%v: %d of %d blobs present", path, len(present), len(content))
+	return present, presentBytes, nil
+}
+
+// isZeroRange reports whether the file f contains only zeros in the range of
+// len(buf) bytes starting at offset. buf is overwritten.
+func isZeroRange(f fs.File, offset int64, buf []byte) (bool, error) {
+	_, err := f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return false, errors.Wrap(err, "Seek")
+	}
+
+	_, err = io.ReadFull(f, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// the file is too short
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "ReadFull")
+	}
+
+	for _, b := range buf {
+		if b != 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
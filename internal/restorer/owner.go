@@ -0,0 +1,96 @@
+package restorer
+
+import (
+	"os/user"
+	"strconv"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// lookupUID returns the ID of the local user with the given name.
+var lookupUID = func(name string) (uint32, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(u.Uid, 10, 32)
+	return uint32(id), err
+}
+
+// lookupGID returns the ID of the local group with the given name.
+var lookupGID = func(name string) (uint32, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(g.Gid, 10, 32)
+	return uint32(id), err
+}
+
+// OwnerMapping configures the owner of restored files and directories. For
+// each ID, an explicit mapping in UIDs or GIDs takes precedence over a lookup
+// by name. If neither applies, the ID stored in the snapshot is used.
+type OwnerMapping struct {
+	// NoOwner leaves the owner alone, restored files belong to the user
+	// running restic.
+	NoOwner bool
+
+	// ByName looks up the names of the user and group stored in the snapshot
+	// in the local user and group database.
+	ByName bool
+
+	// UIDs and GIDs map IDs stored in the snapshot to local IDs.
+	UIDs map[uint32]uint32
+	GIDs map[uint32]uint32
+
+	m      sync.Mutex
+	users  map[string]int
+	groups map[string]int
+}
+
+// ids returns the local user and group ID for node, -1 means that the owner
+// is not changed.
+func (o *OwnerMapping) ids(node *restic.Node) (uid, gid int) {
+	if o.NoOwner {
+		return -1, -1
+	}
+
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	if o.users == nil {
+		o.users = make(map[string]int)
+		o.groups = make(map[string]int)
+	}
+
+	uid = o.mapID(node.UID, node.User, o.UIDs, o.users, lookupUID)
+	gid = o.mapID(node.GID, node.Group, o.GIDs, o.groups, lookupGID)
+	return uid, gid
+}
+
+// mapID returns the local ID for id and name, names which have been looked up
+// before are taken from cache.
+func (o *OwnerMapping) mapID(id uint32, name string, ids map[uint32]uint32, cache map[string]int, lookup func(string) (uint32, error)) int {
+	if mapped, ok := ids[id]; ok {
+		return int(mapped)
+	}
+
+	if !o.ByName || name == "" {
+		return int(id)
+	}
+
+	local, ok := cache[name]
+	if !ok {
+		local = int(id)
+		if found, err := lookup(name); err == nil {
+			local = int(found)
+		} else {
+			debug.Log("unable to find local ID for %q, using %d: %v", name, id, err)
+		}
+		cache[name] = local
+	}
+
+	return local
+}
@@ -0,0 +1,67 @@
+package restorer
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestOwnerMapping(t *testing.T) {
+	defer func(uid, gid func(string) (uint32, error)) {
+		lookupUID, lookupGID = uid, gid
+	}(lookupUID, lookupGID)
+
+	lookups := 0
+	lookupUID = func(name string) (uint32, error) {
+		lookups++
+		if name == "alice" {
+			return 2000, nil
+		}
+		return 0, errors.New("unknown user")
+	}
+	lookupGID = func(name string) (uint32, error) {
+		lookups++
+		if name == "staff" {
+			return 3000, nil
+		}
+		return 0, errors.New("unknown group")
+	}
+
+	alice := &restic.Node{UID: 1000, GID: 100, User: "alice", Group: "staff"}
+	bob := &restic.Node{UID: 1001, GID: 101, User: "bob", Group: "users"}
+	unnamed := &restic.Node{UID: 1002, GID: 102}
+
+	var tests = []struct {
+		mapping  *OwnerMapping
+		node     *restic.Node
+		uid, gid int
+	}{
+		{&OwnerMapping{NoOwner: true}, alice, -1, -1},
+		{&OwnerMapping{}, alice, 1000, 100},
+		{&OwnerMapping{ByName: true}, alice, 2000, 3000},
+		{&OwnerMapping{ByName: true}, bob, 1001, 101},
+		{&OwnerMapping{ByName: true}, unnamed, 1002, 102},
+		{&OwnerMapping{UIDs: map[uint32]uint32{1000: 5}}, alice, 5, 100},
+		{&OwnerMapping{GIDs: map[uint32]uint32{101: 6}}, bob, 1001, 6},
+		{&OwnerMapping{ByName: true, UIDs: map[uint32]uint32{1000: 5}}, alice, 5, 3000},
+	}
+
+	for _, test := range tests {
+		uid, gid := test.mapping.ids(test.node)
+		if uid != test.uid || gid != test.gid {
+			t.Errorf("ids(%v:%v) with %+v = %v:%v, want %v:%v",
+				test.node.UID, test.node.GID, test.mapping, uid, gid, test.uid, test.gid)
+		}
+	}
+
+	// names are only looked up once
+	mapping := &OwnerMapping{ByName: true}
+	lookups = 0
+	for i := 0; i < 3; i++ {
+		mapping.ids(alice)
+		mapping.ids(bob)
+	}
+	rtest.Equals(t, 4, lookups)
+}
@@ -12,6 +12,34 @@ import (
 	"github.com/restic/restic/internal/restic"
 )
 
+// FileAction describes what happens to a file in the target directory.
+type FileAction int
+
+const (
+	// FileCreate is a new file.
+	FileCreate FileAction = iota
+	// FileOverwrite replaces an existing file.
+	FileOverwrite
+	// FileSkip leaves an existing file alone.
+	FileSkip
+	// FileDelete removes a file which is not in the snapshot.
+	FileDelete
+)
+
+func (a FileAction) String() string {
+	switch a {
+	case FileCreate:
+		return "create"
+	case FileOverwrite:
+		return "overwrite"
+	case FileSkip:
+		return "skip"
+	case FileDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
 // Restorer is used to restore a snapshot to a directory.
 type Restorer struct {
 	repo restic.Repository
@@ -19,6 +47,49 @@ type Restorer struct {
 
 	Error        func(location string, err error) error
 	SelectFilter func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool)
+
+	// ReportTotal is called once the number and size of all files to restore
+	// is known.
+	ReportTotal func(files, bytes uint64)
+
+	// CompleteBlob is called for each part of a file which has been written
+	// or was already present in the target directory.
+	CompleteBlob func(location string, bytes uint64)
+
+	// CompleteFile is called when a file has been restored.
+	CompleteFile func(location string)
+
+	// SkipFile is called for existing files which are left alone.
+	SkipFile func(location string, size uint64)
+
+	// PlanFile is called in a dry run for each file in the target directory
+	// which would be touched. size is the number of bytes which would be
+	// written, or the size of the file for FileSkip and FileDelete.
+	PlanFile func(location string, action FileAction, size uint64)
+
+	// Sparse configures whether parts of files which contain only zeros are
+	// restored as holes. Files which contained holes when they were backed
+	// up are always restored as sparse files.
+	Sparse bool
+
+	// Overwrite configures how files which already exist in the target
+	// directory are handled.
+	Overwrite OverwriteBehavior
+
+	// Delete configures whether files in the target directory which are not
+	// contained in the snapshot are removed. Files which are not selected by
+	// SelectFilter are kept.
+	Delete bool
+
+	// DryRun configures whether RestoreTo only reports what it would do by
+	// calling PlanFile. Nothing is downloaded and the target directory is not
+	// modified.
+	DryRun bool
+
+	// Owner configures the owner of restored files and directories. If it is
+	// nil, the IDs stored in the snapshot are used and permission errors are
+	// ignored when not running as root.
+	Owner *OwnerMapping
 }
 
 var restorerAbortOnAllErrors = func(location string, err error) error { return err }
@@ -29,6 +100,11 @@ func NewRestorer(repo restic.Repository, id restic.ID) (*Restorer, error) {
 		repo:         repo,
 		Error:        restorerAbortOnAllErrors,
 		SelectFilter: func(string, string, *restic.Node) (bool, bool) { return true, true },
+		ReportTotal:  func(uint64, uint64) {},
+		CompleteBlob: func(string, uint64) {},
+		CompleteFile: func(string) {},
+		SkipFile:     func(string, uint64) {},
+		PlanFile:     func(string, FileAction, uint64) {},
 	}
 
 	var err error
@@ -155,7 +231,13 @@ func (res *Restorer) restoreNodeTo(ctx context.Context, node *restic.Node, targe
 
 func (res *Restorer) restoreNodeMetadataTo(node *restic.Node, target, location string) error {
 	debug.Log("restoreNodeMetadata %v %v %v", node.Name, target, location)
-	err := node.RestoreMetadata(target)
+	var err error
+	if res.Owner == nil {
+		err = node.RestoreMetadata(target)
+	} else {
+		uid, gid := res.Owner.ids(node)
+		err = node.RestoreMetadataOwner(target, uid, gid)
+	}
 	if err != nil {
 		debug.Log("node.RestoreMetadata(%s) error %v", target, err)
 	}
@@ -163,7 +245,7 @@ func (res *Restorer) restoreNodeMetadataTo(node *restic.Node, target, location s
 }
 
 func (res *Restorer) restoreHardlinkAt(node *restic.Node, target, path, location string) error {
-	if err := fs.Remove(path); !os.IsNotExist(err) {
+	if err := fs.Remove(path); err != nil && !os.IsNotExist(err) {
 		return errors.Wrap(err, "RemoveCreateHardlink")
 	}
 	err := fs.Link(target, path)
@@ -187,6 +269,154 @@ func (res *Restorer) restoreEmptyFileAt(node *restic.Node, target, location stri
 	return res.restoreNodeMetadataTo(node, target, location)
 }
 
+// readdirnames returns the names of all entries in the directory dir.
+func readdirnames(dir string) ([]string, error) {
+	f, err := fs.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := f.Readdirnames(-1)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return entries, f.Close()
+}
+
+// removeUnexpected removes the files in the directory target which are not in
+// the list of expected nodes and are selected by SelectFilter. Directories in
+// the snapshot are processed recursively. It returns the number of entries
+// which are left in the directory.
+func (res *Restorer) removeUnexpected(ctx context.Context, target, location string, expected []*restic.Node) (int, error) {
+	debug.Log("%v %v", target, location)
+
+	entries, err := readdirnames(target)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, res.Error(location, err)
+	}
+
+	nodes := make(map[string]*restic.Node, len(expected))
+	for _, node := range expected {
+		nodes[node.Name] = node
+	}
+
+	remaining := len(entries)
+	for _, name := range entries {
+		nodeTarget := filepath.Join(target, name)
+		nodeLocation := filepath.Join(location, name)
+
+		sanitizeError := func(err error) error {
+			if err != nil {
+				err = res.Error(nodeLocation, err)
+			}
+			return err
+		}
+
+		if node, ok := nodes[name]; ok {
+			if node.Type != "dir" || node.Subtree == nil {
+				continue
+			}
+
+			_, childMayBeSelected := res.SelectFilter(nodeLocation, nodeTarget, node)
+			if !childMayBeSelected {
+				continue
+			}
+
+			// Only descend into real directories, a symlink to a directory
+			// may point outside of the target directory. Anything which is
+			// not a directory is replaced later on.
+			fi, err := fs.Lstat(nodeTarget)
+			if err != nil || !fi.IsDir() {
+				if os.IsNotExist(err) {
+					err = nil
+				}
+				err = sanitizeError(err)
+				if err != nil {
+					return 0, err
+				}
+				continue
+			}
+
+			tree, err := res.repo.LoadTree(ctx, *node.Subtree)
+			if err != nil {
+				err = sanitizeError(err)
+				if err != nil {
+					return 0, err
+				}
+				continue
+			}
+
+			_, err = res.removeUnexpected(ctx, nodeTarget, nodeLocation, tree.Nodes)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		fi, err := fs.Lstat(nodeTarget)
+		var node *restic.Node
+		if err == nil {
+			node, err = restic.NodeFromFileInfo(nodeTarget, fi)
+		}
+		removed := false
+		if err == nil {
+			removed, err = res.removeUnexpectedNode(ctx, node, nodeTarget, nodeLocation)
+		}
+		err = sanitizeError(err)
+		if err != nil {
+			return 0, err
+		}
+		if removed {
+			remaining--
+		}
+	}
+
+	return remaining, nil
+}
+
+// removeUnexpectedNode removes node, which is not contained in the snapshot,
+// if it is selected by SelectFilter. A directory is only removed if all its
+// children have been removed. In a dry run, the removal is only reported.
+func (res *Restorer) removeUnexpectedNode(ctx context.Context, node *restic.Node, target, location string) (removed bool, err error) {
+	selectedForRestore, childMayBeSelected := res.SelectFilter(location, target, node)
+
+	if node.Type == "dir" {
+		var remaining int
+		if childMayBeSelected {
+			remaining, err = res.removeUnexpected(ctx, target, location, nil)
+		} else {
+			var entries []string
+			entries, err = readdirnames(target)
+			remaining = len(entries)
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if remaining > 0 {
+			return false, nil
+		}
+	}
+
+	if !selectedForRestore {
+		return false, nil
+	}
+
+	if res.DryRun {
+		res.PlanFile(location, FileDelete, node.Size)
+		return true, nil
+	}
+
+	debug.Log("removing %v", target)
+	err = fs.Remove(target)
+	return err == nil, err
+}
+
 // RestoreTo creates the directories and files in the snapshot below dst.
 // Before an item is created, res.Filter is called.
 func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
@@ -206,39 +436,176 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 	idx := restic.NewHardlinkIndex()
 
 	filerestorer := newFileRestorer(dst, res.repo.Backend().Load, res.repo.Key(), res.repo.Index().Lookup)
+	filerestorer.completeBlob = res.CompleteBlob
+	filerestorer.completeFile = res.CompleteFile
+
+	if res.Delete {
+		tree, err := res.repo.LoadTree(ctx, *res.sn.Tree)
+		if err != nil {
+			return err
+		}
+
+		_, err = res.removeUnexpected(ctx, dst, string(filepath.Separator), tree.Nodes)
+		if err != nil {
+			return err
+		}
+	}
+
+	// files which already exist and are left alone
+	skip := make(map[string]struct{})
+
+	// empty files which already exist, only their metadata is restored
+	unchanged := make(map[string]struct{})
+
+	// number and size of the files to restore
+	var totalFiles, totalBytes uint64
+
+	// buffer for chunking existing files
+	var chunkBuf []byte
+	if res.Overwrite == OverwriteIfChanged {
+		chunkBuf = make([]byte, res.repo.Config().ChunkerSizes().Max)
+	}
 
 	// first tree pass: create directories and collect all files to restore
 	err = res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
 		enterDir: func(node *restic.Node, target, location string) error {
+			if res.DryRun {
+				return nil
+			}
+
+			// replace anything which is in the way
+			fi, err := fs.Lstat(target)
+			if err == nil && !fi.IsDir() && res.shouldOverwrite(node, fi) {
+				err = fs.RemoveAll(target)
+				if err != nil {
+					return err
+				}
+			}
+
 			// create dir with default permissions
 			// #leaveDir restores dir metadata after visiting all children
 			return fs.MkdirAll(target, 0700)
 		},
 
 		visitNode: func(node *restic.Node, target, location string) error {
-			// create parent dir with default permissions
-			// second pass #leaveDir restores dir metadata after visiting/restoring all children
-			err := fs.MkdirAll(filepath.Dir(target), 0700)
-			if err != nil {
-				return err
+			if !res.DryRun {
+				// create parent dir with default permissions
+				// second pass #leaveDir restores dir metadata after visiting/restoring all children
+				err := fs.MkdirAll(filepath.Dir(target), 0700)
+				if err != nil {
+					return err
+				}
 			}
 
 			if node.Type != "file" {
 				return nil
 			}
 
+			fi, err := fs.Lstat(target)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			exists := err == nil
+
+			action := FileCreate
+			if exists {
+				action = FileOverwrite
+
+				if !res.shouldOverwrite(node, fi) {
+					debug.Log("leaving existing file %v alone", target)
+					skip[location] = struct{}{}
+					totalFiles++
+					totalBytes += node.Size
+					if res.DryRun {
+						res.PlanFile(location, FileSkip, node.Size)
+					} else {
+						res.SkipFile(location, node.Size)
+					}
+					return nil
+				}
+
+				if !fi.Mode().IsRegular() {
+					if !res.DryRun {
+						err = fs.RemoveAll(target)
+						if err != nil {
+							return err
+						}
+					}
+					exists = false
+				}
+			}
+
+			totalFiles++
+
 			if node.Size == 0 {
-				return nil // deal with empty files later
+				if exists && res.Overwrite == OverwriteIfChanged && fi.Size() == 0 && node.Links < 2 {
+					unchanged[location] = struct{}{}
+					if res.DryRun {
+						res.PlanFile(location, FileSkip, 0)
+					} else {
+						res.SkipFile(location, 0)
+					}
+					return nil
+				}
+
+				// deal with empty files later
+				if res.DryRun {
+					res.PlanFile(location, action, 0)
+				}
+				return nil
 			}
 
 			if node.Links > 1 {
 				if idx.Has(node.Inode, node.DeviceID) {
+					// hardlinks are created later
+					if res.DryRun {
+						res.PlanFile(location, action, 0)
+					}
 					return nil
 				}
 				idx.Add(node.Inode, node.DeviceID, location)
 			}
 
-			filerestorer.addFile(location, node.Content, int64(node.Size))
+			totalBytes += node.Size
+
+			if exists && res.Overwrite == OverwriteIfChanged {
+				present, presentBytes, err := res.presentBlobs(ctx, target, node.Content, chunkBuf)
+				if err != nil {
+					return err
+				}
+
+				if res.DryRun {
+					if len(present) == len(node.Content) && fi.Size() == int64(node.Size) {
+						res.PlanFile(location, FileSkip, node.Size)
+					} else {
+						res.PlanFile(location, FileOverwrite, node.Size-presentBytes)
+					}
+					return nil
+				}
+
+				if fi.Size() != int64(node.Size) {
+					err = os.Truncate(target, int64(node.Size))
+					if err != nil {
+						return err
+					}
+				}
+
+				if len(present) == len(node.Content) {
+					res.SkipFile(location, node.Size)
+					return nil
+				}
+
+				res.CompleteBlob(location, presentBytes)
+				filerestorer.addPartialFile(location, node.Content, int64(node.Size), fi.Size(), node.Sparse || res.Sparse, present)
+				return nil
+			}
+
+			if res.DryRun {
+				res.PlanFile(location, action, node.Size)
+				return nil
+			}
+
+			filerestorer.addFile(location, node.Content, int64(node.Size), node.Sparse || res.Sparse)
 
 			return nil
 		},
@@ -248,6 +615,12 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 		return err
 	}
 
+	if res.DryRun {
+		return nil
+	}
+
+	res.ReportTotal(totalFiles, totalBytes)
+
 	err = filerestorer.restoreFiles(ctx)
 	if err != nil {
 		return err
@@ -258,19 +631,50 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 		enterDir: noop,
 		visitNode: func(node *restic.Node, target, location string) error {
 			if node.Type != "file" {
+				fi, err := fs.Lstat(target)
+				if err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				if err == nil {
+					if !res.shouldOverwrite(node, fi) {
+						return nil
+					}
+
+					err = fs.RemoveAll(target)
+					if err != nil {
+						return err
+					}
+				}
+
 				return res.restoreNodeTo(ctx, node, target, location)
 			}
 
+			if _, ok := skip[location]; ok {
+				return nil
+			}
+
+			if _, ok := unchanged[location]; ok {
+				return res.restoreNodeMetadataTo(node, target, location)
+			}
+
 			// create empty files, but not hardlinks to empty files
 			if node.Size == 0 && (node.Links < 2 || !idx.Has(node.Inode, node.DeviceID)) {
 				if node.Links > 1 {
 					idx.Add(node.Inode, node.DeviceID, location)
 				}
-				return res.restoreEmptyFileAt(node, target, location)
+				err := res.restoreEmptyFileAt(node, target, location)
+				if err == nil {
+					res.CompleteFile(location)
+				}
+				return err
 			}
 
 			if idx.Has(node.Inode, node.DeviceID) && idx.GetFilename(node.Inode, node.DeviceID) != location {
-				return res.restoreHardlinkAt(node, filerestorer.targetPath(idx.GetFilename(node.Inode, node.DeviceID)), target, location)
+				err := res.restoreHardlinkAt(node, filerestorer.targetPath(idx.GetFilename(node.Inode, node.DeviceID)), target, location)
+				if err == nil {
+					res.CompleteFile(location)
+				}
+				return err
 			}
 
 			return res.restoreNodeMetadataTo(node, target, location)
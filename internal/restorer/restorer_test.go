@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -26,6 +27,9 @@ type File struct {
 	Data  string
 	Links uint64
 	Inode uint64
+
+	// Chunks, if set, replaces Data and is saved as one blob per chunk
+	Chunks []string
 }
 
 type Dir struct {
@@ -63,7 +67,14 @@ func saveDir(t testing.TB, repo restic.Repository, nodes map[string]Node, inode
 				lc = 1
 			}
 			fc := []restic.ID{}
-			if len(n.(File).Data) > 0 {
+			size := len(node.Data)
+			if len(node.Chunks) > 0 {
+				size = 0
+				for _, chunk := range node.Chunks {
+					fc = append(fc, saveFile(t, repo, File{Data: chunk}))
+					size += len(chunk)
+				}
+			} else if len(n.(File).Data) > 0 {
 				fc = append(fc, saveFile(t, repo, node))
 			}
 			tree.Insert(&restic.Node{
@@ -73,7 +84,7 @@ func saveDir(t testing.TB, repo restic.Repository, nodes map[string]Node, inode
 				UID:     uint32(os.Getuid()),
 				GID:     uint32(os.Getgid()),
 				Content: fc,
-				Size:    uint64(len(n.(File).Data)),
+				Size:    uint64(size),
 				Inode:   fi,
 				Links:   lc,
 			})
@@ -688,3 +699,379 @@ func TestRestorerTraverseTree(t *testing.T) {
 		})
 	}
 }
+
+func TestRestorerOverwrite(t *testing.T) {
+	snapshot := Snapshot{
+		Nodes: map[string]Node{
+			"foo":  File{Data: "content: foo\n"},
+			"same": File{Data: "content: same\n"},
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+				},
+			},
+		},
+	}
+
+	existing := map[string]string{
+		"foo":  "content: a longer and outdated version of foo\n",
+		"same": "content: same\n",
+	}
+
+	var tests = []struct {
+		Overwrite OverwriteBehavior
+		Files     map[string]string
+	}{
+		{
+			Overwrite: OverwriteAlways,
+			Files: map[string]string{
+				"foo":      "content: foo\n",
+				"same":     "content: same\n",
+				"dir/file": "content: file\n",
+			},
+		},
+		{
+			Overwrite: OverwriteIfChanged,
+			Files: map[string]string{
+				"foo":      "content: foo\n",
+				"same":     "content: same\n",
+				"dir/file": "content: file\n",
+			},
+		},
+		{
+			// the files in the snapshot have no modification time
+			Overwrite: OverwriteIfNewer,
+			Files: map[string]string{
+				"foo":      existing["foo"],
+				"same":     "content: same\n",
+				"dir/file": "content: file\n",
+			},
+		},
+		{
+			Overwrite: OverwriteNever,
+			Files: map[string]string{
+				"foo":      existing["foo"],
+				"same":     "content: same\n",
+				"dir/file": "content: file\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Overwrite.String(), func(t *testing.T) {
+			repo, cleanup := repository.TestRepository(t)
+			defer cleanup()
+			_, id := saveSnapshot(t, repo, snapshot)
+
+			res, err := NewRestorer(repo, id)
+			rtest.OK(t, err)
+			res.Overwrite = test.Overwrite
+
+			tempdir, cleanup := rtest.TempDir(t)
+			defer cleanup()
+
+			for filename, content := range existing {
+				rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, filename), []byte(content), 0644))
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+			for filename, content := range test.Files {
+				data, err := ioutil.ReadFile(filepath.Join(tempdir, filepath.FromSlash(filename)))
+				if err != nil {
+					t.Errorf("unable to read file %v: %v", filename, err)
+					continue
+				}
+
+				if !bytes.Equal(data, []byte(content)) {
+					t.Errorf("file %v has wrong content: want %q, got %q", filename, content, data)
+				}
+			}
+		})
+	}
+}
+
+func TestRestorerOverwriteEmptyFile(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"empty": File{},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Overwrite = OverwriteIfChanged
+
+	var skipped, completed []string
+	res.SkipFile = func(location string, size uint64) {
+		skipped = append(skipped, location)
+	}
+	res.CompleteFile = func(location string) {
+		completed = append(completed, location)
+	}
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "empty"), nil, 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	rtest.Equals(t, []string{string(filepath.Separator) + "empty"}, skipped)
+	rtest.Equals(t, 0, len(completed))
+	fi, err := os.Stat(filepath.Join(tempdir, "empty"))
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(0), fi.Size())
+}
+
+func TestRestorerOverwriteSparse(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	// blobs of zeros are found even if they were not cut by the chunker
+	chunks := []string{string(make([]byte, 1<<20)), string(make([]byte, 100))}
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"sparse":  File{Chunks: chunks},
+			"changed": File{Chunks: chunks},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Overwrite = OverwriteIfChanged
+
+	var skipped, completed []string
+	res.SkipFile = func(location string, size uint64) {
+		skipped = append(skipped, toSlash(location))
+	}
+	res.CompleteFile = func(location string) {
+		completed = append(completed, toSlash(location))
+	}
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	size := int64(1<<20 + 100)
+	for _, name := range []string{"sparse", "changed"} {
+		f, err := os.Create(filepath.Join(tempdir, name))
+		rtest.OK(t, err)
+		rtest.OK(t, f.Truncate(size))
+		if name == "changed" {
+			_, err = f.WriteAt([]byte("x"), 1<<20+50)
+			rtest.OK(t, err)
+		}
+		rtest.OK(t, f.Close())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	rtest.Equals(t, []string{"/sparse"}, skipped)
+	rtest.Equals(t, []string{"/changed"}, completed)
+	for _, name := range []string{"sparse", "changed"} {
+		data, err := ioutil.ReadFile(filepath.Join(tempdir, name))
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(data, make([]byte, size)), "file %v has wrong content", name)
+	}
+}
+
+func TestRestorerDelete(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+				},
+			},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Delete = true
+
+	// keep all log files
+	res.SelectFilter = func(item string, dstpath string, node *restic.Node) (bool, bool) {
+		selected := filepath.Ext(item) != ".log"
+		return selected, node.Type == "dir"
+	}
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	for _, filename := range []string{"extra", "dir/extra", "dir/sub/extra", "dir/sub/keep.log", "olddir/file", "keep.log"} {
+		filename = filepath.Join(tempdir, filepath.FromSlash(filename))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		rtest.OK(t, ioutil.WriteFile(filename, []byte("content: extra\n"), 0644))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for _, filename := range []string{"foo", "dir/file", "dir/sub/keep.log", "keep.log"} {
+		_, err := os.Lstat(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		if err != nil {
+			t.Errorf("file %v was removed: %v", filename, err)
+		}
+	}
+
+	for _, filename := range []string{"extra", "dir/extra", "dir/sub/extra", "olddir"} {
+		_, err := os.Lstat(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		if !os.IsNotExist(err) {
+			t.Errorf("file %v was not removed", filename)
+		}
+	}
+}
+
+func TestRestorerProgress(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo":   File{Data: "content: foo\n"},
+			"empty": File{Data: ""},
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file":     File{Data: "content: file\n"},
+					"existing": File{Data: "content: existing\n"},
+				},
+			},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Overwrite = OverwriteNever
+
+	var (
+		m                      sync.Mutex
+		totalFiles, totalBytes uint64
+		files, bytes           uint64
+		skipped                []string
+	)
+	res.ReportTotal = func(f, b uint64) {
+		totalFiles, totalBytes = f, b
+	}
+	res.CompleteBlob = func(location string, b uint64) {
+		m.Lock()
+		bytes += b
+		m.Unlock()
+	}
+	res.CompleteFile = func(location string) {
+		m.Lock()
+		files++
+		m.Unlock()
+	}
+	res.SkipFile = func(location string, size uint64) {
+		skipped = append(skipped, toSlash(location))
+		files++
+		bytes += size
+	}
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	rtest.OK(t, os.Mkdir(filepath.Join(tempdir, "dir"), 0755))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "dir", "existing"), []byte("old content"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	rtest.Equals(t, uint64(4), totalFiles)
+	rtest.Equals(t, uint64(13+14+18), totalBytes)
+	rtest.Equals(t, totalFiles, files)
+	rtest.Equals(t, totalBytes, bytes)
+	rtest.Equals(t, []string{"/dir/existing"}, skipped)
+}
+
+func TestRestorerDryRun(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"new":     File{Data: "content: new\n"},
+			"changed": File{Data: "content: changed\n"},
+			"same":    File{Data: "content: same\n"},
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+				},
+			},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	existing := map[string]string{
+		"changed": "content: old\n",
+		"same":    "content: same\n",
+		"extra":   "content: extra\n",
+	}
+	for filename, content := range existing {
+		rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, filename), []byte(content), 0644))
+	}
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Overwrite = OverwriteIfChanged
+	res.Delete = true
+	res.DryRun = true
+
+	type plan struct {
+		action FileAction
+		size   uint64
+	}
+	actions := make(map[string]plan)
+	res.PlanFile = func(location string, action FileAction, size uint64) {
+		actions[toSlash(location)] = plan{action, size}
+	}
+	res.CompleteFile = func(location string) {
+		t.Errorf("file %v restored in dry run", location)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	rtest.Equals(t, map[string]plan{
+		"/new":      {FileCreate, 13},
+		"/changed":  {FileOverwrite, 17},
+		"/same":     {FileSkip, 14},
+		"/dir/file": {FileCreate, 14},
+		"/extra":    {FileDelete, 15},
+	}, actions)
+
+	// nothing must have been changed
+	entries, err := ioutil.ReadDir(tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, len(existing), len(entries))
+	for filename, content := range existing {
+		data, err := ioutil.ReadFile(filepath.Join(tempdir, filename))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(data))
+	}
+}
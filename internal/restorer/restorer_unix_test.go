@@ -4,6 +4,7 @@ package restorer
 
 import (
 	"context"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -59,3 +60,94 @@ func TestRestorerRestoreEmptyHardlinkedFileds(t *testing.T) {
 		rtest.Equals(t, s1.Ino, s2.Ino)
 	}
 }
+
+func TestRestorerOwnerMapping(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner requires root")
+	}
+
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dirtest": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+				},
+			},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+
+	res.Owner = &OwnerMapping{
+		UIDs: map[uint32]uint32{uint32(os.Getuid()): 12345},
+		GIDs: map[uint32]uint32{uint32(os.Getgid()): 23456},
+	}
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = res.RestoreTo(ctx, tempdir)
+	rtest.OK(t, err)
+
+	for _, name := range []string{"dirtest", "dirtest/file"} {
+		fi, err := os.Lstat(filepath.Join(tempdir, name))
+		rtest.OK(t, err)
+		stat := fi.Sys().(*syscall.Stat_t)
+		rtest.Equals(t, uint32(12345), stat.Uid)
+		rtest.Equals(t, uint32(23456), stat.Gid)
+	}
+}
+
+func TestRestorerDeleteSymlinkedDir(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+				},
+			},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Delete = true
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	// the target contains a symlink to a directory outside of it with the
+	// same name as a directory in the snapshot
+	outside := filepath.Join(tempdir, "outside")
+	target := filepath.Join(tempdir, "target")
+	rtest.OK(t, os.MkdirAll(outside, 0755))
+	rtest.OK(t, os.MkdirAll(target, 0755))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(outside, "precious"), []byte("content: precious\n"), 0644))
+	rtest.OK(t, os.Symlink(outside, filepath.Join(target, "dir")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, target))
+
+	_, err = os.Stat(filepath.Join(outside, "precious"))
+	rtest.OK(t, err)
+
+	fi, err := os.Lstat(filepath.Join(target, "dir"))
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.IsDir(), "symlink was not replaced by a directory")
+
+	data, err := ioutil.ReadFile(filepath.Join(target, "dir", "file"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: file\n", string(data))
+}
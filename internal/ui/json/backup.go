@@ -30,6 +30,7 @@ type Backup struct {
 	*ui.StdioWrapper
 
 	MinUpdatePause time.Duration
+	dry            bool
 
 	term  *termstatus.Terminal
 	v     uint
@@ -366,7 +367,7 @@ func (b *Backup) ReportTotal(item string, s archiver.ScanStats) {
 // Finish prints the finishing messages.
 func (b *Backup) Finish(snapshotID restic.ID) {
 	close(b.finished)
-	b.print(summaryOutput{
+	summary := summaryOutput{
 		MessageType:         "summary",
 		FilesNew:            b.summary.Files.New,
 		FilesChanged:        b.summary.Files.Changed,
@@ -381,7 +382,13 @@ func (b *Backup) Finish(snapshotID restic.ID) {
 		TotalBytesProcessed: b.summary.ProcessedBytes,
 		TotalDuration:       time.Since(b.start).Seconds(),
 		SnapshotID:          snapshotID.Str(),
-	})
+		DryRun:              b.dry,
+	}
+	if b.dry {
+		// the snapshot has not been saved
+		summary.SnapshotID = ""
+	}
+	b.print(summary)
 }
 
 // SetMinUpdatePause sets b.MinUpdatePause. It satisfies the
@@ -390,6 +397,12 @@ func (b *Backup) SetMinUpdatePause(d time.Duration) {
 	b.MinUpdatePause = d
 }
 
+// SetDryRun marks the backup as a dry run, nothing is saved to the
+// repository. It satisfies the ArchiveProgressReporter interface.
+func (b *Backup) SetDryRun() {
+	b.dry = true
+}
+
 type statusUpdate struct {
 	MessageType      string   `json:"message_type"` // "status"
 	SecondsElapsed   uint64   `json:"seconds_elapsed,omitempty"`
@@ -434,5 +447,6 @@ type summaryOutput struct {
 	TotalFilesProcessed uint    `json:"total_files_processed"`
 	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
 	TotalDuration       float64 `json:"total_duration"` // in seconds
-	SnapshotID          string  `json:"snapshot_id"`
+	SnapshotID          string  `json:"snapshot_id,omitempty"`
+	DryRun              bool    `json:"dry_run,omitempty"`
 }
@@ -0,0 +1,309 @@
+package json
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/restorer"
+	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/termstatus"
+)
+
+// Restore reports progress for the `restore` command in JSON.
+type Restore struct {
+	*ui.Message
+	*ui.StdioWrapper
+
+	MinUpdatePause time.Duration
+	dry            bool
+
+	term  *termstatus.Terminal
+	v     uint
+	start time.Time
+
+	totalCh     chan counter
+	processedCh chan counter
+	errCh       chan struct{}
+	finished    chan struct{}
+
+	summary struct {
+		sync.Mutex
+		Files, Bytes struct {
+			Restored uint64
+			Skipped  uint64
+		}
+		Errors uint
+
+		// planned actions in a dry run
+		Plan [restorer.FileDelete + 1]counter
+	}
+}
+
+// NewRestore returns a new restore progress reporter.
+func NewRestore(term *termstatus.Terminal, verbosity uint) *Restore {
+	return &Restore{
+		Message:      ui.NewMessage(term, verbosity),
+		StdioWrapper: ui.NewStdioWrapper(term),
+		term:         term,
+		v:            verbosity,
+		start:        time.Now(),
+
+		// limit to 60fps by default
+		MinUpdatePause: time.Second / 60,
+
+		totalCh:     make(chan counter),
+		processedCh: make(chan counter),
+		errCh:       make(chan struct{}),
+		finished:    make(chan struct{}),
+	}
+}
+
+func (r *Restore) print(status interface{}) {
+	r.term.Print(toJSONString(status))
+}
+
+func (r *Restore) error(status interface{}) {
+	r.term.Error(toJSONString(status))
+}
+
+// Run regularly updates the status lines. It should be called in a separate
+// goroutine.
+func (r *Restore) Run(ctx context.Context) error {
+	var (
+		lastUpdate       time.Time
+		total, processed counter
+		totalKnown       bool
+		errors           uint
+		started          bool
+		secondsRemaining uint64
+	)
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.finished:
+			started = false
+		case t := <-r.totalCh:
+			total = t
+			totalKnown = true
+			started = true
+		case s := <-r.processedCh:
+			processed.Files += s.Files
+			processed.Bytes += s.Bytes
+			started = true
+		case <-r.errCh:
+			errors++
+			started = true
+		case <-t.C:
+			if !started {
+				continue
+			}
+
+			if totalKnown && processed.Bytes > 0 {
+				secs := float64(time.Since(r.start) / time.Second)
+				todo := float64(total.Bytes - processed.Bytes)
+				secondsRemaining = uint64(secs / float64(processed.Bytes) * todo)
+			}
+		}
+
+		// limit update frequency
+		if !started || time.Since(lastUpdate) < r.MinUpdatePause {
+			continue
+		}
+		lastUpdate = time.Now()
+
+		r.update(total, processed, errors, secondsRemaining)
+	}
+}
+
+// update prints a status message.
+func (r *Restore) update(total, processed counter, errors uint, secs uint64) {
+	status := statusUpdate{
+		MessageType:      "status",
+		SecondsElapsed:   uint64(time.Since(r.start) / time.Second),
+		SecondsRemaining: secs,
+		TotalFiles:       total.Files,
+		FilesDone:        processed.Files,
+		TotalBytes:       total.Bytes,
+		BytesDone:        processed.Bytes,
+		ErrorCount:       errors,
+	}
+
+	if total.Bytes > 0 {
+		status.PercentDone = float64(processed.Bytes) / float64(total.Bytes)
+	}
+
+	r.print(status)
+}
+
+// Error is the error callback function for the restorer, it prints the error
+// and returns nil.
+func (r *Restore) Error(location string, err error) error {
+	r.error(errorUpdate{
+		MessageType: "error",
+		Error:       err,
+		During:      "restore",
+		Item:        location,
+	})
+
+	r.summary.Lock()
+	r.summary.Errors++
+	r.summary.Unlock()
+
+	select {
+	case r.errCh <- struct{}{}:
+	case <-r.finished:
+	}
+	return nil
+}
+
+// ReportTotal sets the number and size of all files to restore.
+func (r *Restore) ReportTotal(files, bytes uint64) {
+	select {
+	case r.totalCh <- counter{Files: files, Bytes: bytes}:
+	case <-r.finished:
+	}
+}
+
+// CompleteBlob is called for each part of a file which has been restored.
+func (r *Restore) CompleteBlob(location string, bytes uint64) {
+	r.summary.Lock()
+	r.summary.Bytes.Restored += bytes
+	r.summary.Unlock()
+
+	r.processedCh <- counter{Bytes: bytes}
+}
+
+// CompleteFile is called when a file has been restored.
+func (r *Restore) CompleteFile(location string) {
+	if r.v >= 3 {
+		r.print(restoreVerboseUpdate{
+			MessageType: "verbose_status",
+			Action:      "restored",
+			Item:        location,
+		})
+	}
+
+	r.summary.Lock()
+	r.summary.Files.Restored++
+	r.summary.Unlock()
+
+	r.processedCh <- counter{Files: 1}
+}
+
+// SkipFile is called for existing files which are left alone.
+func (r *Restore) SkipFile(location string, size uint64) {
+	if r.v >= 3 {
+		r.print(restoreVerboseUpdate{
+			MessageType: "verbose_status",
+			Action:      "unchanged",
+			Item:        location,
+			Size:        size,
+		})
+	}
+
+	r.summary.Lock()
+	r.summary.Files.Skipped++
+	r.summary.Bytes.Skipped += size
+	r.summary.Unlock()
+
+	r.processedCh <- counter{Files: 1, Bytes: size}
+}
+
+// PlanFile is called in a dry run for each file which would be touched.
+func (r *Restore) PlanFile(location string, action restorer.FileAction, size uint64) {
+	if r.v >= 2 {
+		r.print(restoreVerboseUpdate{
+			MessageType: "verbose_status",
+			Action:      action.String(),
+			Item:        location,
+			Size:        size,
+		})
+	}
+
+	r.summary.Lock()
+	r.summary.Plan[action].Files++
+	r.summary.Plan[action].Bytes += size
+	r.summary.Unlock()
+}
+
+// SetDryRun marks the restore as a dry run, nothing is written to the target
+// directory.
+func (r *Restore) SetDryRun() {
+	r.dry = true
+}
+
+// SetMinUpdatePause sets r.MinUpdatePause.
+func (r *Restore) SetMinUpdatePause(d time.Duration) {
+	r.MinUpdatePause = d
+}
+
+// Finish prints the summary.
+func (r *Restore) Finish() {
+	close(r.finished)
+
+	r.summary.Lock()
+	defer r.summary.Unlock()
+
+	if r.dry {
+		plan := r.summary.Plan
+		r.print(restorePlanOutput{
+			MessageType:      "summary",
+			DryRun:           true,
+			FilesToCreate:    plan[restorer.FileCreate].Files,
+			BytesToCreate:    plan[restorer.FileCreate].Bytes,
+			FilesToOverwrite: plan[restorer.FileOverwrite].Files,
+			BytesToOverwrite: plan[restorer.FileOverwrite].Bytes,
+			FilesToSkip:      plan[restorer.FileSkip].Files,
+			BytesToSkip:      plan[restorer.FileSkip].Bytes,
+			FilesToDelete:    plan[restorer.FileDelete].Files,
+			BytesToDelete:    plan[restorer.FileDelete].Bytes,
+		})
+		return
+	}
+
+	r.print(restoreSummaryOutput{
+		MessageType:   "summary",
+		FilesRestored: r.summary.Files.Restored,
+		FilesSkipped:  r.summary.Files.Skipped,
+		BytesRestored: r.summary.Bytes.Restored,
+		BytesSkipped:  r.summary.Bytes.Skipped,
+		TotalErrors:   r.summary.Errors,
+		TotalDuration: time.Since(r.start).Seconds(),
+	})
+}
+
+type restoreVerboseUpdate struct {
+	MessageType string `json:"message_type"` // "verbose_status"
+	Action      string `json:"action"`
+	Item        string `json:"item"`
+	Size        uint64 `json:"size,omitempty"`
+}
+
+type restoreSummaryOutput struct {
+	MessageType   string  `json:"message_type"` // "summary"
+	FilesRestored uint64  `json:"files_restored"`
+	FilesSkipped  uint64  `json:"files_skipped"`
+	BytesRestored uint64  `json:"bytes_restored"`
+	BytesSkipped  uint64  `json:"bytes_skipped"`
+	TotalErrors   uint    `json:"total_errors"`
+	TotalDuration float64 `json:"total_duration"` // in seconds
+}
+
+type restorePlanOutput struct {
+	MessageType      string `json:"message_type"` // "summary"
+	DryRun           bool   `json:"dry_run"`
+	FilesToCreate    uint64 `json:"files_to_create"`
+	BytesToCreate    uint64 `json:"bytes_to_create"`
+	FilesToOverwrite uint64 `json:"files_to_overwrite"`
+	BytesToOverwrite uint64 `json:"bytes_to_overwrite"`
+	FilesToSkip      uint64 `json:"files_to_skip"`
+	BytesToSkip      uint64 `json:"bytes_to_skip"`
+	FilesToDelete    uint64 `json:"files_to_delete"`
+	BytesToDelete    uint64 `json:"bytes_to_delete"`
+}
@@ -0,0 +1,269 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/restorer"
+	"github.com/restic/restic/internal/ui/termstatus"
+)
+
+// Restore reports progress for the `restore` command.
+type Restore struct {
+	*Message
+	*StdioWrapper
+
+	MinUpdatePause time.Duration
+	dry            bool
+
+	term  *termstatus.Terminal
+	v     uint
+	start time.Time
+
+	totalCh     chan counter
+	processedCh chan counter
+	errCh       chan struct{}
+	finished    chan struct{}
+
+	summary struct {
+		sync.Mutex
+		Files, Bytes struct {
+			Restored uint64
+			Skipped  uint64
+		}
+		Errors uint
+
+		// planned actions in a dry run
+		Plan [restorer.FileDelete + 1]counter
+	}
+}
+
+// NewRestore returns a new restore progress reporter.
+func NewRestore(term *termstatus.Terminal, verbosity uint) *Restore {
+	return &Restore{
+		Message:      NewMessage(term, verbosity),
+		StdioWrapper: NewStdioWrapper(term),
+		term:         term,
+		v:            verbosity,
+		start:        time.Now(),
+
+		// limit to 60fps by default
+		MinUpdatePause: time.Second / 60,
+
+		totalCh:     make(chan counter),
+		processedCh: make(chan counter),
+		errCh:       make(chan struct{}),
+		finished:    make(chan struct{}),
+	}
+}
+
+// Run regularly updates the status lines. It should be called in a separate
+// goroutine.
+func (r *Restore) Run(ctx context.Context) error {
+	var (
+		lastUpdate       time.Time
+		total, processed counter
+		totalKnown       bool
+		errors           uint
+		started          bool
+		secondsRemaining uint64
+	)
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.finished:
+			started = false
+			r.term.SetStatus([]string{""})
+		case t := <-r.totalCh:
+			total = t
+			totalKnown = true
+			started = true
+		case s := <-r.processedCh:
+			processed.Files += s.Files
+			processed.Bytes += s.Bytes
+			started = true
+		case <-r.errCh:
+			errors++
+			started = true
+		case <-t.C:
+			if !started {
+				continue
+			}
+
+			if totalKnown && processed.Bytes > 0 {
+				secs := float64(time.Since(r.start) / time.Second)
+				todo := float64(total.Bytes - processed.Bytes)
+				secondsRemaining = uint64(secs / float64(processed.Bytes) * todo)
+			}
+		}
+
+		// limit update frequency
+		if !started || time.Since(lastUpdate) < r.MinUpdatePause {
+			continue
+		}
+		lastUpdate = time.Now()
+
+		r.update(total, processed, errors, secondsRemaining)
+	}
+}
+
+// update updates the status lines.
+func (r *Restore) update(total, processed counter, errors uint, secs uint64) {
+	elapsed := time.Since(r.start)
+
+	var rate string
+	if elapsed >= time.Second {
+		rate = fmt.Sprintf(", %s/s", formatBytes(uint64(float64(processed.Bytes)/elapsed.Seconds())))
+	}
+
+	var status string
+	if total.Files == 0 {
+		// no total count available yet
+		status = fmt.Sprintf("[%s] %v files %s, %d errors%s",
+			formatDuration(elapsed),
+			processed.Files, formatBytes(processed.Bytes), errors, rate,
+		)
+	} else {
+		var eta, percent string
+
+		if secs > 0 && processed.Bytes < total.Bytes {
+			eta = fmt.Sprintf(" ETA %s", formatSeconds(secs))
+		}
+		if processed.Bytes < total.Bytes {
+			percent = formatPercent(processed.Bytes, total.Bytes)
+			percent += "  "
+		}
+
+		// include totals
+		status = fmt.Sprintf("[%s] %s%v files %s, total %v files %v, %d errors%s%s",
+			formatDuration(elapsed),
+			percent,
+			processed.Files,
+			formatBytes(processed.Bytes),
+			total.Files,
+			formatBytes(total.Bytes),
+			errors,
+			rate,
+			eta,
+		)
+	}
+
+	r.term.SetStatus([]string{status})
+}
+
+// Error is the error callback function for the restorer, it prints the error
+// and returns nil.
+func (r *Restore) Error(location string, err error) error {
+	r.E("ignoring error for %s: %s\n", location, err)
+
+	r.summary.Lock()
+	r.summary.Errors++
+	r.summary.Unlock()
+
+	select {
+	case r.errCh <- struct{}{}:
+	case <-r.finished:
+	}
+	return nil
+}
+
+// ReportTotal sets the number and size of all files to restore.
+func (r *Restore) ReportTotal(files, bytes uint64) {
+	r.V("restoring %v files, %s", files, formatBytes(bytes))
+
+	select {
+	case r.totalCh <- counter{Files: uint(files), Bytes: bytes}:
+	case <-r.finished:
+	}
+}
+
+// CompleteBlob is called for each part of a file which has been restored.
+func (r *Restore) CompleteBlob(location string, bytes uint64) {
+	r.summary.Lock()
+	r.summary.Bytes.Restored += bytes
+	r.summary.Unlock()
+
+	r.processedCh <- counter{Bytes: bytes}
+}
+
+// CompleteFile is called when a file has been restored.
+func (r *Restore) CompleteFile(location string) {
+	r.VV("restored  %v", location)
+
+	r.summary.Lock()
+	r.summary.Files.Restored++
+	r.summary.Unlock()
+
+	r.processedCh <- counter{Files: 1}
+}
+
+// SkipFile is called for existing files which are left alone.
+func (r *Restore) SkipFile(location string, size uint64) {
+	r.VV("unchanged %v", location)
+
+	r.summary.Lock()
+	r.summary.Files.Skipped++
+	r.summary.Bytes.Skipped += size
+	r.summary.Unlock()
+
+	r.processedCh <- counter{Files: 1, Bytes: size}
+}
+
+// PlanFile is called in a dry run for each file which would be touched.
+func (r *Restore) PlanFile(location string, action restorer.FileAction, size uint64) {
+	r.V("would %-9v %v, %s", action, location, formatBytes(size))
+
+	r.summary.Lock()
+	r.summary.Plan[action].Files++
+	r.summary.Plan[action].Bytes += size
+	r.summary.Unlock()
+}
+
+// SetDryRun marks the restore as a dry run, nothing is written to the target
+// directory.
+func (r *Restore) SetDryRun() {
+	r.dry = true
+}
+
+// SetMinUpdatePause sets r.MinUpdatePause.
+func (r *Restore) SetMinUpdatePause(d time.Duration) {
+	r.MinUpdatePause = d
+}
+
+// Finish prints the finishing messages.
+func (r *Restore) Finish() {
+	close(r.finished)
+
+	r.summary.Lock()
+	defer r.summary.Unlock()
+
+	if r.dry {
+		plan := r.summary.Plan
+		r.P("Would create:    %5d files, %s\n", plan[restorer.FileCreate].Files, formatBytes(plan[restorer.FileCreate].Bytes))
+		r.P("Would overwrite: %5d files, %s\n", plan[restorer.FileOverwrite].Files, formatBytes(plan[restorer.FileOverwrite].Bytes))
+		r.P("Would skip:      %5d files, %s\n", plan[restorer.FileSkip].Files, formatBytes(plan[restorer.FileSkip].Bytes))
+		if plan[restorer.FileDelete].Files > 0 {
+			r.P("Would delete:    %5d files, %s\n", plan[restorer.FileDelete].Files, formatBytes(plan[restorer.FileDelete].Bytes))
+		}
+		return
+	}
+
+	r.P("restored %v files, %v in %s\n",
+		r.summary.Files.Restored,
+		formatBytes(r.summary.Bytes.Restored),
+		formatDuration(time.Since(r.start)),
+	)
+	if r.summary.Files.Skipped > 0 {
+		r.P("skipped %v existing files, %v\n", r.summary.Files.Skipped, formatBytes(r.summary.Bytes.Skipped))
+	}
+	if r.summary.Errors > 0 {
+		r.P("There were %d errors\n", r.summary.Errors)
+	}
+}